@@ -0,0 +1,190 @@
+// Package anonymize removes sensitive data from queries, so that slow query
+// logs can be shared safely. Every literal of a query (strings, numbers,
+// hexadecimal values, including the ones of IN lists) is replaced, and users,
+// hosts, schemas and table names can be masked too.
+//
+// Replacements are deterministic: the same value is always replaced the same
+// way, so queries can still be grouped and compared once anonymised. When a
+// salt is given, literals are replaced by a salted hash of their value, which
+// tells whether two queries use the same values without revealing them.
+// Otherwise they are replaced by placeholders, which is enough to fingerprint
+// queries. Masked names are always hashed, with the salt if any: without a
+// salt, short and common names can be found by brute force.
+//
+// Queries are tokenized with the MySQL rules, so double-quoted values are
+// strings, not identifiers.
+package anonymize
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"strconv"
+	"strings"
+
+	"github.com/devops-works/slowql/query"
+	"github.com/devops-works/slowql/query/lexer"
+	"github.com/devops-works/slowql/query/structure"
+)
+
+// Options tells how to anonymise queries
+type Options struct {
+	// Salt is the key used to hash values. If empty, literals are replaced by
+	// placeholders
+	Salt string
+	// Users, Hosts, Schemas and Tables tell which names have to be masked
+	Users   bool
+	Hosts   bool
+	Schemas bool
+	Tables  bool
+}
+
+// Anonymizer anonymises queries
+type Anonymizer struct {
+	opts Options
+}
+
+// New returns a new anonymizer
+func New(opts Options) *Anonymizer {
+	return &Anonymizer{opts: opts}
+}
+
+// Query returns an anonymised copy of q. Timings and counters are kept as is,
+// while Extra is dropped since it can hold anything, such as the previous
+// statement of the session. The error is the one of the lexer: a truncated
+// query is still anonymised
+func (a *Anonymizer) Query(q query.Query) (query.Query, error) {
+	sql, err := a.sql(q.Query, q.Schema)
+	q.Query = sql
+	q.Extra = nil
+
+	if a.opts.Users && q.User != "" {
+		q.User = a.name("user_", q.User)
+	}
+	if a.opts.Hosts && q.Host != "" {
+		q.Host = a.name("host_", q.Host)
+	}
+	if a.opts.Schemas && q.Schema != "" {
+		q.Schema = a.name("db_", q.Schema)
+	}
+	return q, err
+}
+
+// SQL returns the anonymised version of a query
+func (a *Anonymizer) SQL(q string) (string, error) {
+	return a.sql(q, "")
+}
+
+// sql anonymises a query running in schema
+func (a *Anonymizer) sql(q, schema string) (string, error) {
+	tokens, err := lexer.Tokenize(q)
+
+	// names to mask
+	tables := make(map[string]bool)
+	schemas := make(map[string]bool)
+	if a.opts.Tables || a.opts.Schemas {
+		s := structure.Extract(q)
+		for _, t := range s.Tables {
+			tables[t.Name] = a.opts.Tables
+			if t.Schema != "" {
+				schemas[t.Schema] = a.opts.Schemas
+			}
+		}
+		if schema != "" {
+			schemas[schema] = a.opts.Schemas
+		}
+
+		// USE statements, which can prefix queries in slow query logs
+		start, use := true, false
+		for _, t := range tokens {
+			switch {
+			case t.Kind == lexer.Whitespace || t.Kind == lexer.Comment:
+				continue
+			case use && (t.Kind == lexer.Word || t.Kind == lexer.QuotedIdentifier):
+				schemas[unquote(t.Value)] = a.opts.Schemas
+			}
+			use = start && strings.EqualFold(t.Value, "use")
+			start = t.Value == ";"
+		}
+	}
+
+	var b strings.Builder
+	space := false
+	for _, t := range tokens {
+		switch t.Kind {
+		case lexer.Comment:
+			// comments can hold anything, they are dropped
+			space = true
+			continue
+		case lexer.Whitespace:
+			space = true
+			continue
+		}
+		if space && b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		space = false
+
+		switch t.Kind {
+		case lexer.String:
+			b.WriteString(a.literal(t.Value))
+		case lexer.Number:
+			b.WriteString(a.number(t.Value))
+		case lexer.Word, lexer.QuotedIdentifier:
+			name := unquote(t.Value)
+			switch {
+			case schemas[name]:
+				name = a.name("db_", name)
+			case tables[name]:
+				name = a.name("tbl_", name)
+			default:
+				b.WriteString(t.Value)
+				continue
+			}
+			if t.Kind == lexer.QuotedIdentifier {
+				name = "`" + name + "`"
+			}
+			b.WriteString(name)
+		default:
+			b.WriteString(t.Value)
+		}
+	}
+	return b.String(), err
+}
+
+// literal returns the replacement of a string
+func (a *Anonymizer) literal(v string) string {
+	if a.opts.Salt == "" {
+		return "'?'"
+	}
+	return "'" + hex.EncodeToString(a.hash(v)[:8]) + "'"
+}
+
+// number returns the replacement of a number
+func (a *Anonymizer) number(v string) string {
+	if a.opts.Salt == "" {
+		return "0"
+	}
+	return strconv.FormatUint(binary.BigEndian.Uint64(a.hash(v))%1e9, 10)
+}
+
+// name returns a masked name, made of the prefix followed by a hash of the name
+func (a *Anonymizer) name(prefix, v string) string {
+	return prefix + hex.EncodeToString(a.hash(v)[:6])
+}
+
+// hash returns the salted hash of a value
+func (a *Anonymizer) hash(v string) []byte {
+	mac := hmac.New(sha256.New, []byte(a.opts.Salt))
+	mac.Write([]byte(v))
+	return mac.Sum(nil)
+}
+
+// unquote removes the backticks around an identifier
+func unquote(s string) string {
+	if len(s) >= 2 && s[0] == '`' && s[len(s)-1] == '`' {
+		return strings.ReplaceAll(s[1:len(s)-1], "``", "`")
+	}
+	return s
+}
@@ -0,0 +1,96 @@
+package anonymize
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/devops-works/slowql/query"
+)
+
+func TestAnonymizer_SQL(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    Options
+		q       string
+		want    string
+		wantErr bool
+	}{
+		{name: "strings and numbers", q: "SELECT * FROM users WHERE email = 'john@example.com' AND id = 42",
+			want: "SELECT * FROM users WHERE email = '?' AND id = 0"},
+		{name: "hexadecimal and prefixed strings", q: "SELECT * FROM t WHERE a = 0xDEADBEEF OR b = X'ff' OR c = _utf8mb4'x' OR d = -1.5",
+			want: "SELECT * FROM t WHERE a = 0 OR b = '?' OR c = '?' OR d = -0"},
+		{name: "in list", q: "SELECT * FROM t WHERE token IN ('abc', \"def\", 3)",
+			want: "SELECT * FROM t WHERE token IN ('?', '?', 0)"},
+		{name: "escaped quotes", q: `INSERT INTO t VALUES ('it\'s', 'a''b')`,
+			want: "INSERT INTO t VALUES ('?', '?')"},
+		{name: "comments and whitespace", q: "SELECT /* customer 42 */ a\n\tFROM t -- secret\nWHERE b = 1",
+			want: "SELECT a FROM t WHERE b = 0"},
+		{name: "placeholders and null", q: "UPDATE t SET a = ?, b = NULL WHERE c = $1",
+			want: "UPDATE t SET a = ?, b = NULL WHERE c = $1"},
+		{name: "salted", opts: Options{Salt: "pepper"}, q: "SELECT * FROM t WHERE a = 'x' AND b = 'x' AND c = 'y' AND d = 7",
+			want: "SELECT * FROM t WHERE a = 'da77185978f9432b' AND b = 'da77185978f9432b' AND c = 'bcbd448a604ce995' AND d = 467822188"},
+		{name: "tables", opts: Options{Tables: true}, q: "SELECT users.id FROM `users` JOIN shop.orders o ON o.user_id = users.id",
+			want: "SELECT tbl_9923a9a62b6a.id FROM `tbl_9923a9a62b6a` JOIN shop.tbl_bf2d133aaa1f o ON o.user_id = tbl_9923a9a62b6a.id"},
+		{name: "schemas", opts: Options{Schemas: true}, q: "SELECT * FROM shop.orders",
+			want: "SELECT * FROM db_adbc0a02531a.orders"},
+		{name: "use", opts: Options{Schemas: true}, q: "use `shop`",
+			want: "use `db_adbc0a02531a`"},
+		{name: "prefixed with use", opts: Options{Schemas: true, Tables: true}, q: "use shop;SELECT * FROM users;",
+			want: "use db_adbc0a02531a;SELECT * FROM tbl_9923a9a62b6a;"},
+		{name: "truncated", q: "SELECT * FROM t WHERE a = 'secr",
+			want: "SELECT * FROM t WHERE a = '?'", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := New(tt.opts).SQL(tt.q)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Anonymizer.SQL() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Anonymizer.SQL() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAnonymizer_Query(t *testing.T) {
+	q := query.Query{
+		Time:         time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC),
+		QueryTime:    1.5,
+		LockTime:     0.001,
+		ID:           12,
+		RowsSent:     1,
+		RowsExamined: 1000,
+		BytesSent:    56,
+		User:         "app",
+		Host:         "10.0.0.1",
+		Schema:       "shop",
+		Query:        "SELECT * FROM orders WHERE ref = 'A-42'",
+		Extra:        map[string]string{"Prev_stmt": "SELECT 'secret'"},
+	}
+
+	tests := []struct {
+		name string
+		opts Options
+		want query.Query
+	}{
+		{name: "literals only", opts: Options{},
+			want: query.Query{Time: q.Time, QueryTime: 1.5, LockTime: 0.001, ID: 12, RowsSent: 1, RowsExamined: 1000, BytesSent: 56,
+				User: "app", Host: "10.0.0.1", Schema: "shop", Query: "SELECT * FROM orders WHERE ref = '?'"}},
+		{name: "everything", opts: Options{Users: true, Hosts: true, Schemas: true, Tables: true},
+			want: query.Query{Time: q.Time, QueryTime: 1.5, LockTime: 0.001, ID: 12, RowsSent: 1, RowsExamined: 1000, BytesSent: 56,
+				User: "user_91d7c63d3fdf", Host: "host_23d3f63f51ac", Schema: "db_adbc0a02531a", Query: "SELECT * FROM tbl_bf2d133aaa1f WHERE ref = '?'"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := New(tt.opts).Query(q)
+			if err != nil {
+				t.Errorf("Anonymizer.Query() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Anonymizer.Query() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,153 @@
+package slowql
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// exportFormat is the format of a log exported from AWS CloudWatch
+type exportFormat int
+
+const (
+	// classic is a log that has not been exported, it is read as is
+	classic exportFormat = iota
+	// jsonLines holds one JSON encoded log event per line
+	jsonLines
+	// streamPrefix holds the log events prefixed by their timestamp and log
+	// stream name, as printed by `aws logs tail`
+	streamPrefix
+)
+
+// streamPrefixRegexp matches the timestamp and log stream name that prefix the
+// first line of each event
+var streamPrefixRegexp = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}(?:\.\d+)?(?:Z|[+-]\d{2}:\d{2}) \S+ `)
+
+// banner is written before the events of the kinds whose logs start with one,
+// since RDS and Aurora exports do not have it
+var banner = []string{
+	"/rdsdbbin/mysql/bin/mysqld, Version: unknown (exported from AWS CloudWatch). started with:",
+	"Tcp port: 0  Unix socket: unknown",
+	"Time                 Id Command    Argument",
+}
+
+// cloudWatchEvent is a log event exported as JSON. Only the message is
+// needed, since it holds the original lines with their own # Time header
+type cloudWatchEvent struct {
+	Message string `json:"message"`
+}
+
+// CloudWatchReader unwraps the slow query logs exported from AWS CloudWatch
+// (RDS and Aurora) into the classic slow query log stream
+type CloudWatchReader struct {
+	r       *bufio.Reader
+	kind    Kind
+	format  exportFormat
+	pending []byte
+	started bool
+}
+
+// NewCloudWatchReader returns a reader that unwraps the logs exported from AWS
+// CloudWatch, either as JSON lines or with a per-line stream prefix, so they
+// can be given to NewParser. The format is detected from the first line, and
+// logs that have not been exported are read unchanged
+func NewCloudWatchReader(k Kind, r io.Reader) *CloudWatchReader {
+	return &CloudWatchReader{
+		r:    bufio.NewReader(r),
+		kind: k,
+	}
+}
+
+// Read implements io.Reader
+func (c *CloudWatchReader) Read(p []byte) (int, error) {
+	if !c.started {
+		c.started = true
+		if err := c.detect(); err != nil {
+			return 0, err
+		}
+	}
+
+	if c.format == classic && len(c.pending) == 0 {
+		return c.r.Read(p)
+	}
+
+	for len(c.pending) == 0 {
+		line, err := c.r.ReadString('\n')
+		if line != "" {
+			c.pending = append(c.pending, c.unwrap(line)...)
+		}
+		if err != nil {
+			if len(c.pending) > 0 {
+				break
+			}
+			return 0, err
+		}
+	}
+
+	n := copy(p, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+// Unwrapped tells if the log was exported from AWS CloudWatch and is
+// unwrapped, in which case its lines are not the ones of the export. It is
+// only known once the reading started
+func (c *CloudWatchReader) Unwrapped() bool {
+	return c.format != classic
+}
+
+// detect looks at the first line to find the format of the export, and adds
+// the banner if needed
+func (c *CloudWatchReader) detect() error {
+	// the first line can be longer than the bufio.Reader buffer, so we read
+	// it and put it back in the pending bytes
+	line, err := c.r.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return err
+	}
+
+	switch {
+	case strings.HasPrefix(line, "{"):
+		c.format = jsonLines
+	case streamPrefixRegexp.MatchString(line):
+		c.format = streamPrefix
+	default:
+		c.format = classic
+		c.pending = []byte(line)
+		return nil
+	}
+
+	unwrapped := c.unwrap(line)
+	if c.needsBanner() && !strings.Contains(unwrapped, ", Version: ") {
+		c.pending = append(c.pending, strings.Join(banner, "\n")+"\n"...)
+	}
+	c.pending = append(c.pending, unwrapped...)
+	return nil
+}
+
+// needsBanner tells if the logs of the kind start with a server banner
+func (c *CloudWatchReader) needsBanner() bool {
+	switch c.kind {
+	case MySQL, MariaDB, PXC, General:
+		return true
+	}
+	return false
+}
+
+// unwrap returns the original log lines held by an exported line
+func (c *CloudWatchReader) unwrap(line string) string {
+	switch c.format {
+	case jsonLines:
+		var e cloudWatchEvent
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			// not an event, such as an empty line
+			return ""
+		}
+		return strings.TrimSuffix(e.Message, "\n") + "\n"
+	case streamPrefix:
+		return streamPrefixRegexp.ReplaceAllString(line, "")
+	}
+	return line
+}
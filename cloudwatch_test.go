@@ -0,0 +1,81 @@
+package slowql
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestCloudWatchReader(t *testing.T) {
+	want, err := ioutil.ReadFile("testdata/cloudwatch.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		fixture   string
+		kind      Kind
+		want      string
+		unwrapped bool
+	}{
+		{name: "json lines", fixture: "testdata/cloudwatch.json", kind: MySQL, want: string(want), unwrapped: true},
+		{name: "stream prefix", fixture: "testdata/cloudwatch-stream.log", kind: MySQL, want: string(want), unwrapped: true},
+		{name: "classic", fixture: "testdata/cloudwatch.log", kind: MySQL, want: string(want)},
+		{name: "no banner", fixture: "testdata/cloudwatch.json", kind: TiDB,
+			want: strings.SplitN(string(want), "\n", 4)[3], unwrapped: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fd, err := os.Open(tt.fixture)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer fd.Close()
+
+			r := NewCloudWatchReader(tt.kind, fd)
+			got, err := ioutil.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("got = %q, want %q", got, tt.want)
+			}
+			if r.Unwrapped() != tt.unwrapped {
+				t.Errorf("Unwrapped() = %v, want %v", r.Unwrapped(), tt.unwrapped)
+			}
+		})
+	}
+}
+
+func TestCloudWatchReader_parser(t *testing.T) {
+	fd, err := os.Open("testdata/cloudwatch.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fd.Close()
+
+	p := NewParser(MySQL, NewCloudWatchReader(MySQL, fd))
+	var queries []string
+	for {
+		q := p.GetNext()
+		if q.IsZero() {
+			break
+		}
+		queries = append(queries, q.Query)
+	}
+
+	want := []string{
+		"use shop;SELECT COUNT(*) FROM orders WHERE status = 'pending';",
+		"SELECT id, email FROM customers WHERE created_at > '2021-03-01';",
+	}
+	if len(queries) != len(want) {
+		t.Fatalf("got %d queries, want %d", len(queries), len(want))
+	}
+	for i := range want {
+		if queries[i] != want[i] {
+			t.Errorf("got = %q, want %q", queries[i], want[i])
+		}
+	}
+}
@@ -29,7 +29,7 @@ func main() {
 	start := time.Now()
 	for {
 		q := p.GetNext()
-		if q == (query.Query{}) {
+		if q.IsZero() {
 			break
 		}
 
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/devops-works/slowql"
+	"github.com/devops-works/slowql/anonymize"
+	"github.com/sirupsen/logrus"
+)
+
+type options struct {
+	file   string
+	output string
+	kind   string
+	loglvl string
+	salt   string
+	mask   string
+}
+
+// masks lists the names that can be masked
+var masks = []string{"user", "host", "schema", "table"}
+
+func main() {
+	var opt options
+
+	flag.StringVar(&opt.file, "f", "", "Slow query log file to anonymize")
+	flag.StringVar(&opt.output, "o", "", "File to write the anonymized slow query log to (default to stdout)")
+	flag.StringVar(&opt.kind, "k", "", "Kind of the database (mysql, mariadb, pxc)")
+	flag.StringVar(&opt.loglvl, "l", "info", "Logging level")
+	flag.StringVar(&opt.salt, "salt", "", "Salt used to hash literals. Literals are replaced by placeholders if empty")
+	flag.StringVar(&opt.mask, "mask", "", "Comma separated list of names to mask: user, host, schema, table")
+	flag.Parse()
+
+	if errs := opt.parse(); len(errs) > 0 {
+		flag.Usage()
+		for _, e := range errs {
+			logrus.Warn(e)
+		}
+		logrus.Fatal("cannot parse options")
+	}
+
+	lvl, err := logrus.ParseLevel(opt.loglvl)
+	if err != nil {
+		logrus.Fatalf("cannot parse log level: %s", err)
+	}
+	logrus.SetLevel(lvl)
+
+	kind, err := parseKind(opt.kind)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	in, err := os.Open(opt.file)
+	if err != nil {
+		logrus.Fatalf("cannot open slow query log file: %s", err)
+	}
+	defer in.Close()
+	logrus.Debugf("file %s successfully opened", opt.file)
+
+	var out io.Writer = os.Stdout
+	if opt.output != "" {
+		fd, err := os.Create(opt.output)
+		if err != nil {
+			logrus.Fatalf("cannot create output file: %s", err)
+		}
+		defer fd.Close()
+		out = fd
+	}
+
+	n, err := run(kind, in, out, opt.anonymizer())
+	if err != nil {
+		logrus.Fatalf("cannot anonymize %s: %s", opt.file, err)
+	}
+	logrus.Infof("%d queries anonymized", n)
+}
+
+func (o *options) parse() []error {
+	var errs []error
+	if o.file == "" {
+		errs = append(errs, errors.New("no slow query log file provided"))
+	} else if o.kind == "" {
+		errs = append(errs, errors.New("no database kind provided"))
+	}
+
+	if o.mask != "" {
+		for _, m := range strings.Split(o.mask, ",") {
+			if !stringInSlice(strings.TrimSpace(m), masks) {
+				errs = append(errs, errors.New("cannot mask "+m))
+			}
+		}
+	}
+	return errs
+}
+
+// anonymizer returns the anonymizer configured by the options
+func (o options) anonymizer() *anonymize.Anonymizer {
+	opts := anonymize.Options{Salt: o.salt}
+	for _, m := range strings.Split(o.mask, ",") {
+		switch strings.TrimSpace(m) {
+		case "user":
+			opts.Users = true
+		case "host":
+			opts.Hosts = true
+		case "schema":
+			opts.Schemas = true
+		case "table":
+			opts.Tables = true
+		}
+	}
+	return anonymize.New(opts)
+}
+
+// parseKind converts a kind from string to slowql.Kind. Only the kinds that
+// can be written back are accepted
+func parseKind(kind string) (slowql.Kind, error) {
+	switch kind {
+	case "mysql":
+		return slowql.MySQL, nil
+	case "mariadb":
+		return slowql.MariaDB, nil
+	case "pxc":
+		return slowql.PXC, nil
+	}
+	return slowql.Unknown, errors.New("kind not recognised: " + kind)
+}
+
+// run reads the slow query log from in and writes its anonymized version to
+// out. It returns the number of queries written
+func run(kind slowql.Kind, in io.Reader, out io.Writer, a *anonymize.Anonymizer) (int, error) {
+	w, err := slowql.NewWriter(kind, out)
+	if err != nil {
+		return 0, err
+	}
+
+	// logs exported from AWS CloudWatch are unwrapped, the other ones are
+	// read unchanged
+	p := slowql.NewParser(kind, slowql.NewCloudWatchReader(kind, in))
+	if err := w.WriteServerMeta(p.GetServerMeta()); err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for {
+		q := p.GetNext()
+		if q.IsZero() {
+			break
+		}
+
+		q, err = a.Query(q)
+		if err != nil {
+			logrus.Debugf("query %d may be truncated: %s", count+1, err)
+		}
+		if err := w.Write(q); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+func stringInSlice(s string, sl []string) bool {
+	for _, v := range sl {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
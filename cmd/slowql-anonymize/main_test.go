@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/devops-works/slowql"
+)
+
+func Test_options_parse(t *testing.T) {
+	tests := []struct {
+		name    string
+		opt     options
+		wantErr bool
+	}{
+		{name: "working", opt: options{file: "file", kind: "mysql"}, wantErr: false},
+		{name: "masks", opt: options{file: "file", kind: "mysql", mask: "user, host,schema,table"}, wantErr: false},
+		{name: "no file", opt: options{kind: "mysql"}, wantErr: true},
+		{name: "no kind", opt: options{file: "file"}, wantErr: true},
+		{name: "unknown mask", opt: options{file: "file", kind: "mysql", mask: "user,password"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.opt.parse()
+			if (len(got) > 0) != tt.wantErr {
+				t.Errorf("options.parse() = %v, wantErr %v", got, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_run(t *testing.T) {
+	log := `/usr/sbin/mysqld, Version: 8.0.26 (MySQL Community Server - GPL). started with:
+Tcp port: 3306  Unix socket: /var/run/mysqld/mysqld.sock
+Time                 Id Command    Argument
+# Time: 2021-09-01T10:00:00.123456Z
+# User@Host: alice[alice] @  [10.0.0.5]  Id:    42
+# Query_time: 1.500000  Lock_time: 0.000100 Rows_sent: 1  Rows_examined: 5000
+SET timestamp=1630490400;
+SELECT * FROM customers WHERE email = 'alice@example.com';
+`
+
+	opt := options{salt: "pepper", mask: "user,host"}
+	var out bytes.Buffer
+	n, err := run(slowql.MySQL, strings.NewReader(log), &out, opt.anonymizer())
+	if err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+	if n != 1 {
+		t.Errorf("run() = %d queries, want 1", n)
+	}
+
+	for _, secret := range []string{"alice", "10.0.0.5", "example.com"} {
+		if strings.Contains(out.String(), secret) {
+			t.Errorf("run() output contains %q:\n%s", secret, out.String())
+		}
+	}
+
+	// the output must be a valid slow query log with the same timings
+	p := slowql.NewParser(slowql.MySQL, &out)
+	if srv := p.GetServerMeta(); srv.Port != 3306 {
+		t.Errorf("server port = %d, want 3306", srv.Port)
+	}
+	q := p.GetNext()
+	if q.QueryTime != 1.5 || q.LockTime != 0.0001 || q.RowsExamined != 5000 || q.ID != 42 {
+		t.Errorf("timings not preserved: %+v", q)
+	}
+	if !strings.HasPrefix(q.Query, "SELECT * FROM customers WHERE email = '") {
+		t.Errorf("unexpected query: %s", q.Query)
+	}
+}
@@ -2,22 +2,33 @@ package main
 
 import (
 	"errors"
+	"hash/fnv"
 	"io"
+	"runtime"
 	"sync"
 	"time"
 
 	"github.com/devops-works/slowql"
+	"github.com/devops-works/slowql/digest"
 	"github.com/devops-works/slowql/query"
 	"github.com/sirupsen/logrus"
 )
 
 type app struct {
-	mu             sync.Mutex
-	logger         *logrus.Logger
-	kind           slowql.Kind
+	// agg holds the results once the aggregators of the workers are merged
+	agg     *digest.Aggregator
+	logger  *logrus.Logger
+	kind    slowql.Kind
+	groupBy string
+	filter  *slowql.Filter
+	// workers is the number of goroutines digesting queries
+	workers int
+	// samples is the number of queries sampled in each entry
+	samples int
+	// bucket is the width of the intervals queries are followed over, or 0
+	bucket         time.Duration
 	fd             io.Reader
 	p              slowql.Parser
-	res            map[string]statistics
 	digestDuration time.Duration
 	queriesNumber  int
 }
@@ -25,8 +36,9 @@ type app struct {
 func newApp(loglevel, kind string) (*app, error) {
 	var a app
 
-	// init res map
-	a.res = make(map[string]statistics)
+	a.groupBy = "fingerprint"
+	a.workers = runtime.NumCPU()
+	a.samples = digest.DefaultSamples
 
 	// create application logger
 	a.logger = logrus.New()
@@ -55,6 +67,10 @@ func newApp(loglevel, kind string) (*app, error) {
 		a.kind = slowql.MariaDB
 	case "pxc":
 		a.kind = slowql.PXC
+	case "postgresql":
+		a.kind = slowql.PostgreSQL
+	case "tidb":
+		a.kind = slowql.TiDB
 	default:
 		return nil, errors.New("kind not recognised: " + kind)
 	}
@@ -62,58 +78,142 @@ func newApp(loglevel, kind string) (*app, error) {
 	return &a, nil
 }
 
-func (a *app) digest(q query.Query, wg *sync.WaitGroup) error {
-	defer wg.Done()
-	var s statistics
-	s.Fingerprint = fingerprint(q.Query)
-	s.Hash = hash(s.Fingerprint)
-
-	a.mu.Lock()
-	defer a.mu.Unlock()
-
-	if cur, ok := a.res[s.Hash]; ok {
-		// there is already results
-		cur.Calls++
-		cur.CumBytesSent += q.BytesSent
-		cur.CumKilled += q.Killed
-		cur.CumLockTime += q.LockTime
-		cur.CumRowsExamined += q.RowsExamined
-		cur.CumRowsSent += q.RowsSent
-		cur.CumQueryTime += q.QueryTime
-		cur.QueryTimes = append(cur.QueryTimes, q.QueryTime)
-
-		// update max time
-		if q.QueryTime > cur.MaxTime {
-			cur.MaxTime = q.QueryTime
+// routed is a query sent to a shard, with the entries of the shard it is
+// accounted for in
+type routed struct {
+	q       query.Query
+	entries []digest.Entry
+	// whole tells the shard to account for the query in its totals, load and
+	// buckets, which only one of the shards of the query does
+	whole bool
+}
+
+// keyed is a query with the entries it belongs to
+type keyed struct {
+	q       query.Query
+	entries []digest.Entry
+}
+
+// digestAll digests the queries received on queries until it is closed, and
+// merges the results in a.agg. A pool of a.workers goroutines fingerprints the
+// queries and a single router sends them by hash to as many shards, each
+// having its own aggregator, so that each entry lives in exactly one of them.
+// The queries are handed to the workers and collected from them in turn, so
+// each shard receives its queries in the order of the log, and the schema of
+// the entries does not change from one run to another
+func (a *app) digestAll(queries <-chan query.Query) error {
+	var err error
+	a.agg, err = digest.NewAggregator(a.groupBy)
+	if err != nil {
+		// the queries are drained, so that the sender is not blocked
+		for range queries {
 		}
+		return err
+	}
+	a.agg.SetSamples(a.samples)
+	if a.bucket > 0 {
+		a.agg.SetBucket(a.bucket)
+	}
 
-		// update min time
-		if q.QueryTime < cur.MinTime {
-			cur.MinTime = q.QueryTime
+	aggs := make([]*digest.Aggregator, a.workers)
+	shards := make([]chan routed, a.workers)
+	var shardsWg sync.WaitGroup
+	for i := range aggs {
+		aggs[i], _ = digest.NewAggregator(a.groupBy)
+		aggs[i].SetSamples(a.samples)
+		if a.bucket > 0 {
+			aggs[i].SetBucket(a.bucket)
 		}
+		shards[i] = make(chan routed, queueSize)
+		shardsWg.Add(1)
+		go func(agg *digest.Aggregator, shard <-chan routed) {
+			defer shardsWg.Done()
+			for r := range shard {
+				agg.AddEntries(r.q, r.entries, r.whole)
+			}
+		}(aggs[i], shards[i])
+	}
 
-		// update the entry in the map
-		a.res[s.Hash] = cur
-	} else {
-		// it is the first time this hash appears
-		s.Calls++
-		s.CumBytesSent = q.BytesSent
-		s.CumKilled = q.Killed
-		s.CumLockTime = q.LockTime
-		s.CumRowsExamined = q.RowsExamined
-		s.CumRowsSent = q.RowsSent
-		s.CumQueryTime = q.QueryTime
-		s.MinTime = q.QueryTime
-		s.MaxTime = q.QueryTime
-		s.MeanTime = q.QueryTime
-		s.QueryTimes = append(s.QueryTimes, q.QueryTime)
-
-		// getting those values is done only once: same hash == same fingerprint & schema
-		s.Schema = q.Schema
-
-		// add the entry to the map
-		a.res[s.Hash] = s
+	ins := make([]chan query.Query, a.workers)
+	outs := make([]chan keyed, a.workers)
+	for i := range ins {
+		ins[i] = make(chan query.Query, queueSize)
+		outs[i] = make(chan keyed, queueSize)
+		go func(in <-chan query.Query, out chan<- keyed) {
+			for q := range in {
+				out <- keyed{q: q, entries: a.agg.Key(q)}
+			}
+			close(out)
+		}(ins[i], outs[i])
 	}
+	go func() {
+		n := 0
+		for q := range queries {
+			ins[n%len(ins)] <- q
+			n++
+		}
+		for _, in := range ins {
+			close(in)
+		}
+	}()
+
+	// the workers are read in the order they were handed the queries, so
+	// the first one without a result marks the end of the queries
+	for n := 0; ; n++ {
+		k, ok := <-outs[n%len(outs)]
+		if !ok {
+			break
+		}
+		route(k, shards)
+	}
+	for _, shard := range shards {
+		close(shard)
+	}
+	shardsWg.Wait()
 
+	for _, agg := range aggs {
+		if err := a.agg.Merge(agg); err != nil {
+			return err
+		}
+	}
 	return nil
 }
+
+// route sends a query to the shards of its entries. A query grouped by table
+// can have entries in several shards: the first one accounts for the query as
+// a whole
+func route(k keyed, shards []chan routed) {
+	q, entries := k.q, k.entries
+	if len(entries) == 1 {
+		shards[shardOf(entries[0].Hash, len(shards))] <- routed{q: q, entries: entries, whole: true}
+		return
+	}
+
+	var order []int
+	byShard := make(map[int][]digest.Entry)
+	for _, e := range entries {
+		i := shardOf(e.Hash, len(shards))
+		if _, ok := byShard[i]; !ok {
+			order = append(order, i)
+		}
+		byShard[i] = append(byShard[i], e)
+	}
+	for n, i := range order {
+		shards[i] <- routed{q: q, entries: byShard[i], whole: n == 0}
+	}
+}
+
+// shardOf returns the shard of an entry among n, by hash
+func shardOf(hash string, n int) int {
+	h := fnv.New32a()
+	h.Write([]byte(hash))
+	return int(h.Sum32() % uint32(n))
+}
+
+// formatTime formats a bound of the time window, which is empty when not set
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339Nano)
+}
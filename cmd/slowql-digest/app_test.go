@@ -1,9 +1,15 @@
 package main
 
 import (
+	"fmt"
+	"math"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/devops-works/slowql"
+	"github.com/devops-works/slowql/digest"
+	"github.com/devops-works/slowql/query"
 	"github.com/sirupsen/logrus"
 )
 
@@ -31,6 +37,10 @@ func Test_newApp(t *testing.T) {
 			loglevel: logrus.ErrorLevel, kind: slowql.MySQL, wantErr: false},
 		{name: "fatal - mysql", args: args{loglevel: "fatal", kind: "mysql"},
 			loglevel: logrus.FatalLevel, kind: slowql.MySQL, wantErr: false},
+		{name: "info - postgresql", args: args{loglevel: "info", kind: "postgresql"},
+			loglevel: logrus.InfoLevel, kind: slowql.PostgreSQL, wantErr: false},
+		{name: "info - tidb", args: args{loglevel: "info", kind: "tidb"},
+			loglevel: logrus.InfoLevel, kind: slowql.TiDB, wantErr: false},
 		{name: "unknown - mysql", args: args{loglevel: "foobar", kind: "mysql"},
 			loglevel: logrus.InfoLevel, kind: slowql.MySQL, wantErr: true},
 		{name: "info - unknown", args: args{loglevel: "info", kind: "plop"},
@@ -55,3 +65,191 @@ func Test_newApp(t *testing.T) {
 		})
 	}
 }
+
+// syntheticQueries parses a synthetic MySQL slow query log of n queries, spread
+// over 100 fingerprints, one every second. Each query has its own query time
+func syntheticQueries(n int) []query.Query {
+	var b strings.Builder
+	b.WriteString("/usr/sbin/mysqld, Version: 8.0.26 (MySQL Community Server - GPL). started with:\n")
+	b.WriteString("Tcp port: 3306  Unix socket: /var/run/mysqld/mysqld.sock\n")
+	b.WriteString("Time                 Id Command    Argument\n")
+	start := time.Date(2021, 3, 23, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < n; i++ {
+		t := start.Add(time.Duration(i) * time.Second)
+		fmt.Fprintf(&b, "# Time: %s\n", t.Format("2006-01-02T15:04:05.000000Z07:00"))
+		fmt.Fprintf(&b, "# User@Host: app[app] @  [10.0.0.1]  Id: %d\n", i)
+		fmt.Fprintf(&b, "# Query_time: %.6f  Lock_time: 0.000%03d Rows_sent: %d  Rows_examined: %d\n",
+			float64(i%100)/100+float64(i)*1e-6, i%1000, i%10, i%5000)
+		fmt.Fprintf(&b, "SET timestamp=%d;\nSELECT * FROM t%d WHERE id = %d;\n", t.Unix(), i%100, i)
+	}
+
+	var queries []query.Query
+	p := slowql.NewParser(slowql.MySQL, strings.NewReader(b.String()))
+	for {
+		q := p.GetNext()
+		if q.IsZero() {
+			return queries
+		}
+		queries = append(queries, q)
+	}
+}
+
+// digestQueries digests the queries with a pool of workers
+func digestQueries(a *app, queries []query.Query) error {
+	ch := make(chan query.Query, queueSize*a.workers)
+	go func() {
+		for _, q := range queries {
+			ch <- q
+		}
+		close(ch)
+	}()
+	return a.digestAll(ch)
+}
+
+func Test_app_digestAll(t *testing.T) {
+	queries := syntheticQueries(5000)
+
+	one, _ := newApp("error", "mysql")
+	one.workers = 1
+	one.bucket = time.Minute
+	if err := digestQueries(one, queries); err != nil {
+		t.Fatalf("digestAll() error = %v", err)
+	}
+
+	for _, workers := range []int{2, 8} {
+		t.Run(fmt.Sprintf("%d workers", workers), func(t *testing.T) {
+			a, _ := newApp("error", "mysql")
+			a.workers = workers
+			a.bucket = time.Minute
+			if err := digestQueries(a, queries); err != nil {
+				t.Fatalf("digestAll() error = %v", err)
+			}
+
+			totals, wantTotals := a.agg.Totals(), one.agg.Totals()
+			if totals.Calls != len(queries) || totals.CumRowsExamined != wantTotals.CumRowsExamined {
+				t.Errorf("digestAll() totals = %+v, want %+v", totals, wantTotals)
+			}
+			for class, want := range one.agg.Load() {
+				if got := a.agg.Load()[class]; got.Calls != want.Calls {
+					t.Errorf("digestAll() load of %s = %+v, want %+v", class, got, want)
+				}
+			}
+			if len(a.agg.Timeline()) != len(one.agg.Timeline()) {
+				t.Errorf("digestAll() timeline has %d minutes, want %d", len(a.agg.Timeline()), len(one.agg.Timeline()))
+			}
+			for start, want := range one.agg.Buckets() {
+				if got := a.agg.Buckets()[start]; got.Calls != want.Calls || got.Sketch.Quantile(0.95) != want.Sketch.Quantile(0.95) {
+					t.Errorf("digestAll() bucket %d = %+v, want %+v", start, got, want)
+				}
+			}
+			res := make(map[string]digest.Entry)
+			for _, e := range a.agg.Entries() {
+				res[e.Hash] = e
+			}
+			if len(res) != len(one.agg.Entries()) {
+				t.Fatalf("digestAll() = %d entries, want %d", len(res), len(one.agg.Entries()))
+			}
+			for _, want := range one.agg.Entries() {
+				hash := want.Hash
+				got := res[hash]
+				if got.Calls != want.Calls || got.MinTime != want.MinTime || got.MaxTime != want.MaxTime ||
+					got.Slowest != want.Slowest || got.CumRowsSent != want.CumRowsSent ||
+					math.Abs(got.CumQueryTime-want.CumQueryTime) > 1e-9 {
+					t.Errorf("digestAll() entry %s = %+v, want %+v", hash, got, want)
+				}
+				for _, q := range []float64{0.5, 0.95, 0.99} {
+					if got.Sketch.Quantile(q) != want.Sketch.Quantile(q) ||
+						got.Sketches["rows_examined"].Quantile(q) != want.Sketches["rows_examined"].Quantile(q) {
+						t.Errorf("digestAll() entry %s has a different distribution", hash)
+					}
+				}
+				if len(got.Buckets) != len(want.Buckets) {
+					t.Errorf("digestAll() entry %s has %d buckets, want %d", hash, len(got.Buckets), len(want.Buckets))
+				}
+				if len(got.Samples) != len(want.Samples) {
+					t.Errorf("digestAll() entry %s has %d samples, want %d", hash, len(got.Samples), len(want.Samples))
+				}
+			}
+		})
+	}
+}
+
+func Test_app_digestAll_order(t *testing.T) {
+	var queries []query.Query
+	for i := 0; i < 1000; i++ {
+		queries = append(queries, query.Query{Schema: fmt.Sprintf("db%d", i), Query: "SELECT 1"})
+	}
+
+	for run := 0; run < 10; run++ {
+		a, _ := newApp("error", "mysql")
+		a.workers = 8
+		if err := digestQueries(a, queries); err != nil {
+			t.Fatalf("digestAll() error = %v", err)
+		}
+		entries := a.agg.Entries()
+		if len(entries) != 1 || entries[0].Schema != "db0" {
+			t.Fatalf("digestAll() = %+v, want a single entry of schema db0", entries)
+		}
+	}
+}
+
+func Test_app_route(t *testing.T) {
+	tests := []struct {
+		name    string
+		groupBy string
+		q       string
+		want    int
+	}{
+		{name: "fingerprint", groupBy: "fingerprint", q: "SELECT * FROM a JOIN b ON a.id = b.id", want: 1},
+		{name: "tables", groupBy: "table", q: "SELECT * FROM a JOIN b ON a.id = b.id JOIN c ON b.id = c.id JOIN d ON c.id = d.id", want: 4},
+		{name: "no table", groupBy: "table", q: "SELECT 1", want: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, _ := newApp("error", "mysql")
+			a.agg, _ = digest.NewAggregator(tt.groupBy)
+			shards := make([]chan routed, 3)
+			for i := range shards {
+				shards[i] = make(chan routed, tt.want)
+			}
+			q := query.Query{Schema: "shop", Query: tt.q}
+			route(keyed{q: q, entries: a.agg.Key(q)}, shards)
+
+			entries, whole := 0, 0
+			for i, shard := range shards {
+				close(shard)
+				for r := range shard {
+					for _, e := range r.entries {
+						if shardOf(e.Hash, len(shards)) != i {
+							t.Errorf("route() sent %s to shard %d", e.Hash, i)
+						}
+					}
+					entries += len(r.entries)
+					if r.whole {
+						whole++
+					}
+				}
+			}
+			if entries != tt.want || whole != 1 {
+				t.Errorf("route() = %d entries, %d whole, want %d entries, 1 whole", entries, whole, tt.want)
+			}
+		})
+	}
+}
+
+func BenchmarkApp_digestAll(b *testing.B) {
+	queries := syntheticQueries(100000)
+	for _, workers := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("%d workers", workers), func(b *testing.B) {
+			start := time.Now()
+			for i := 0; i < b.N; i++ {
+				a, _ := newApp("error", "mysql")
+				a.workers = workers
+				if err := digestQueries(a, queries); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(len(queries)*b.N)/time.Since(start).Seconds(), "queries/s")
+		})
+	}
+}
@@ -9,16 +9,31 @@ import (
 	"io/ioutil"
 	"os"
 	"time"
+
+	"github.com/devops-works/slowql/digest"
 )
 
-// results is the datastrcucture that will be saved on disk
+// results is the datastrcucture that will be saved on disk. Width is the width
+// of the intervals of Buckets, which is wider than Bucket when the log spans
+// more than digest.MaxBuckets intervals
 type results struct {
-	File          string        `json:"file"`
-	Date          time.Time     `json:"date"`
-	TotalDuration time.Duration `json:"total_duration"`
-	Hash          string        `json:"hash"`
-	ServerMeta    serverMeta    `json:"server_meta"`
-	Data          []statistics  `json:"data"`
+	File          string                  `json:"file"`
+	Date          time.Time               `json:"date"`
+	TotalDuration time.Duration           `json:"total_duration"`
+	Hash          string                  `json:"hash"`
+	GroupBy       string                  `json:"group_by"`
+	Filter        string                  `json:"filter"`
+	Since         time.Time               `json:"since"`
+	Until         time.Time               `json:"until"`
+	Samples       int                     `json:"samples"`
+	Bucket        time.Duration           `json:"bucket"`
+	Width         time.Duration           `json:"width"`
+	Buckets       map[int64]digest.Bucket `json:"buckets,omitempty"`
+	ServerMeta    serverMeta              `json:"server_meta"`
+	Data          []digest.Entry          `json:"data"`
+	Load          map[string]digest.Load  `json:"load"`
+	Totals        digest.Totals           `json:"totals"`
+	Timeline      map[int64]digest.Load   `json:"timeline"`
 }
 
 // findCache looks a for a cache file stored in the same directory than the slow
@@ -33,8 +48,26 @@ func findCache(f string) bool {
 // restoreCache reads the cache and returns its contents if the SHA-256 of the
 // file and the one stored in the cache match
 func restoreCache(f string) (results, error) {
+	r, err := readCache(f + ".cache")
+	if err != nil {
+		return r, err
+	}
+
+	hash, err := getSha256(f)
+	if err != nil {
+		return r, err
+	}
+
+	if hash != r.Hash {
+		return r, errors.New("hashes does not match, log file must have changed since cache creation")
+	}
+	return r, nil
+}
+
+// readCache reads a cache file, whether its log still exists or not
+func readCache(path string) (results, error) {
 	var r results
-	cache, err := os.Open(f + ".cache")
+	cache, err := os.Open(path)
 	if err != nil {
 		return r, err
 	}
@@ -48,14 +81,31 @@ func restoreCache(f string) (results, error) {
 	if err := json.Unmarshal(rawBytes, &r); err != nil {
 		return r, err
 	}
-
-	hash, err := getSha256(f)
-	if err != nil {
-		return r, err
+	// caches created before queries could be grouped by table are grouped by
+	// fingerprint
+	if r.GroupBy == "" {
+		r.GroupBy = "fingerprint"
+	}
+	// caches created before the totals were kept only have the entries, which
+	// hold each query once when grouped by fingerprint
+	if r.Totals.Calls == 0 {
+		for _, s := range r.Data {
+			r.Totals.Calls += s.Calls
+			r.Totals.CumQueryTime += s.CumQueryTime
+			r.Totals.CumLockTime += s.CumLockTime
+			r.Totals.CumRowsSent += s.CumRowsSent
+			r.Totals.CumRowsExamined += s.CumRowsExamined
+			r.Totals.CumBytesSent += s.CumBytesSent
+			r.Totals.CumKilled += s.CumKilled
+		}
 	}
 
-	if hash != r.Hash {
-		return r, errors.New("hashes does not match, log file must have changed since cache creation")
+	// caches created before the query times and the other metrics were
+	// sketched cannot give their percentiles
+	for _, s := range r.Data {
+		if s.Sketch == nil || len(s.Sketches) != len(digest.Metrics) {
+			return r, errors.New("cache was created without the query time distributions")
+		}
 	}
 	return r, nil
 }
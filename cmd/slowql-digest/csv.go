@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/devops-works/slowql/digest"
+)
+
+// csvHeader returns the columns of the CSV and TSV outputs, with a column per
+// percentile of the query time, such as p95_time, the slowest query and the
+// given number of sampled queries with where they come from, such as
+// sample2_user, followed by the minimum, maximum, mean and percentiles of
+// each metric whose distribution is shown, such as rows_examined_p95. Times
+// are in seconds
+func csvHeader(percentiles []float64, metrics []string, samples int) []string {
+	header := []string{"rank", "hash", "fingerprint", "table", "user", "host", "schema", "calls",
+		"cum_query_time", "min_time", "max_time", "mean_time"}
+	for _, p := range percentiles {
+		header = append(header, digest.PercentileName(p)+"_time")
+	}
+	header = append(header, "stddev_time",
+		"cum_lock_time", "cum_rows_sent", "cum_rows_examined", "cum_bytes_sent",
+		"cum_killed", "cum_errored", "concurrency",
+		"slowest_query", "slowest_time", "slowest_query_time", "slowest_user", "slowest_host", "slowest_schema", "slowest_line")
+	for i := 1; i <= samples; i++ {
+		prefix := "sample" + strconv.Itoa(i)
+		header = append(header, prefix+"_query", prefix+"_time", prefix+"_query_time", prefix+"_user",
+			prefix+"_host", prefix+"_schema", prefix+"_line")
+	}
+	for _, m := range metrics {
+		header = append(header, m+"_min", m+"_max", m+"_mean")
+		for _, p := range percentiles {
+			header = append(header, m+"_"+digest.PercentileName(p))
+		}
+	}
+	return header
+}
+
+// writeCSV writes the top entries of the report with a row per entry, the
+// values being separated by comma
+func writeCSV(w io.Writer, r report, comma rune) error {
+	cw := csv.NewWriter(w)
+	cw.Comma = comma
+	// every row has the columns of the entry with the most samples
+	samples := 0
+	for i, s := range r.stats {
+		if i == r.top {
+			break
+		}
+		if len(s.Samples) > samples {
+			samples = len(s.Samples)
+		}
+	}
+	if err := cw.Write(csvHeader(r.percentiles, r.metrics, samples)); err != nil {
+		return err
+	}
+
+	for i, s := range r.stats {
+		if i == r.top {
+			break
+		}
+		row := []string{
+			strconv.Itoa(i + 1),
+			s.Hash,
+			s.Fingerprint,
+			s.Table,
+			s.User,
+			s.Host,
+			s.Schema,
+			strconv.Itoa(s.Calls),
+			formatFloat(s.CumQueryTime),
+			formatFloat(s.MinTime),
+			formatFloat(s.MaxTime),
+			formatFloat(s.MeanTime),
+		}
+		for _, p := range r.percentiles {
+			row = append(row, formatFloat(s.Percentiles[digest.PercentileName(p)]))
+		}
+		row = append(row,
+			formatFloat(s.StddevTime),
+			formatFloat(s.CumLockTime),
+			strconv.Itoa(s.CumRowsSent),
+			strconv.Itoa(s.CumRowsExamined),
+			strconv.Itoa(s.CumBytesSent),
+			strconv.Itoa(s.CumKilled),
+			strconv.Itoa(s.CumErrored),
+			formatFloat(s.Concurrency),
+		)
+		row = append(row, csvSample(s.Slowest)...)
+		for j := 0; j < samples; j++ {
+			var sample digest.Sample
+			if j < len(s.Samples) {
+				sample = s.Samples[j]
+			}
+			row = append(row, csvSample(sample)...)
+		}
+		for _, m := range r.metrics {
+			d := s.Distributions[m]
+			row = append(row, formatFloat(d.Min), formatFloat(d.Max), formatFloat(d.Mean))
+			for _, p := range r.percentiles {
+				row = append(row, formatFloat(d.Percentiles[digest.PercentileName(p)]))
+			}
+		}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// writeCSVSeries writes a row per interval of the whole log, with a rank of 0
+// and no hash, followed by a row per interval of each top entry, the values
+// being separated by comma. Every series has the intervals without queries,
+// so that they line up
+func writeCSVSeries(w io.Writer, r report, comma rune) error {
+	cw := csv.NewWriter(w)
+	cw.Comma = comma
+	pName := digest.PercentileName(highestPercentile(r.percentiles))
+	if err := cw.Write([]string{"rank", "hash", "start", "calls", "cum_query_time", pName + "_time"}); err != nil {
+		return err
+	}
+
+	write := func(rank int, hash string, buckets map[int64]digest.Bucket) error {
+		for _, p := range reportSeries(r, buckets) {
+			row := []string{strconv.Itoa(rank), hash, p.Start.Format(time.RFC3339),
+				strconv.Itoa(p.Calls), formatFloat(p.CumQueryTime), formatFloat(p.Percentile)}
+			if err := cw.Write(row); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := write(0, "", r.buckets); err != nil {
+		return err
+	}
+	for i, s := range r.stats {
+		if i == r.top {
+			break
+		}
+		if err := write(i+1, s.Hash, s.Buckets); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// formatFloat formats a float with the fewest digits needed
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// csvSample returns the columns of a sampled query: the query, its time,
+// query time, user, host, schema and line. Unknown values are empty, as is
+// every column when there is no sample
+func csvSample(s digest.Sample) []string {
+	row := make([]string, 7)
+	if s.Query == "" {
+		return row
+	}
+	row[0] = s.Query
+	if !s.Time.IsZero() {
+		row[1] = s.Time.Format(time.RFC3339Nano)
+	}
+	row[2] = formatFloat(s.QueryTime)
+	row[3], row[4], row[5] = s.User, s.Host, s.Schema
+	if s.Line > 0 {
+		row[6] = strconv.Itoa(s.Line)
+	}
+	return row
+}
@@ -0,0 +1,379 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"time"
+
+	"github.com/devops-works/slowql/digest"
+	ar "github.com/logrusorgru/aurora"
+	"github.com/sirupsen/logrus"
+)
+
+// diffOutputs lists the output formats of diff
+var diffOutputs = []string{"json", "markdown", "text"}
+
+// failStatus is the exit status of diff with -fail, when queries regressed or
+// are new
+const failStatus = 3
+
+// diffReport holds everything the outputs of diff can show
+type diffReport struct {
+	before  results
+	after   results
+	changes []digest.Change
+	groupBy string
+	// percentile is the percentile of the query time that is compared
+	percentile float64
+	// threshold is the percentage above which a change is reported
+	threshold float64
+	minCalls  int
+	minDelta  time.Duration
+	top       int
+}
+
+// diffMain digests two logs, or restores the digest of the first one from a
+// baseline cache, and reports the entries that are new, gone, or whose call
+// rate, mean or percentile of the query time changed by more than a threshold
+func diffMain(args []string) {
+	var o options
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %[1]s diff [options] before.log after.log\n       %[1]s diff [options] -baseline before.log.cache after.log\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	o.setFlags(fs)
+	fs.StringVar(&o.baseline, "baseline", "", "Cache of the digest to compare with, instead of a log")
+	fs.Float64Var(&o.threshold, "threshold", 20, "Percentage above which a change of the call rate, mean or percentile of the query time is reported")
+	fs.Float64Var(&o.diffPercentile, "diff-percentile", 95, "Percentile of the query time that is compared")
+	fs.IntVar(&o.minCalls, "min-calls", 10, "Calls below which a change of the call rate is not reported")
+	fs.DurationVar(&o.minDelta, "min-delta", time.Millisecond, "Change of the mean or percentile of the query time below which it is not reported")
+	fs.BoolVar(&o.fail, "fail", false, fmt.Sprintf("Exit with status %d when queries regressed or are new", failStatus))
+	// the flags can only fail with ExitOnError
+	_ = fs.Parse(args)
+	if o.help() {
+		return
+	}
+
+	var errs []error
+	switch {
+	case o.baseline != "" && fs.NArg() == 1:
+		o.logfile = fs.Arg(0)
+	case o.baseline == "" && fs.NArg() == 2:
+		o.logfile = fs.Arg(1)
+	default:
+		errs = append(errs, errors.New("diff needs two logs, or a baseline and a log"))
+	}
+	errs = append(errs, o.parse()...)
+	if !stringInSlice(o.output, diffOutputs) {
+		errs = append(errs, errors.New("diff cannot write "+o.output+", only json, markdown or text"))
+	}
+	if o.diffPercentile <= 0 || o.diffPercentile > 100 {
+		errs = append(errs, errors.New("diff percentile must be above 0 and at most 100"))
+	} else if !floatInSlice(o.diffPercentile, o.percentileValues) {
+		o.percentileValues = append(o.percentileValues, o.diffPercentile)
+	}
+	if o.minCalls < 0 || o.minDelta < 0 {
+		errs = append(errs, errors.New("min-calls and min-delta cannot be negative"))
+	}
+	if len(errs) != 0 {
+		fs.Usage()
+		for _, e := range errs {
+			logrus.Warn(e)
+		}
+		logrus.Fatal("cannot parse options")
+	}
+
+	var before results
+	if o.baseline != "" {
+		var err error
+		before, err = readCache(o.baseline)
+		if err != nil {
+			logrus.Fatalf("cannot read baseline: %s", err)
+		}
+		// the baseline must have digested the queries as the log is
+		if before.GroupBy != o.groupBy || before.Filter != o.filter ||
+			!before.Since.Equal(o.sinceTime) || !before.Until.Equal(o.untilTime) {
+			logrus.Fatalf("baseline was created with -group-by %s -filter %q -since %q -until %q",
+				before.GroupBy, before.Filter, formatTime(before.Since), formatTime(before.Until))
+		}
+		before.Data = digest.Compute(before.Data, before.TotalDuration, o.percentileValues)
+	} else {
+		bo := o
+		bo.logfile = fs.Arg(0)
+		before, _ = digestFile(&bo)
+	}
+	after, logger := digestFile(&o)
+
+	d := diffReport{
+		before:     before,
+		after:      after,
+		groupBy:    o.groupBy,
+		percentile: o.diffPercentile,
+		threshold:  o.threshold,
+		minCalls:   o.minCalls,
+		minDelta:   o.minDelta,
+		top:        o.top,
+	}
+	d.changes = digest.Diff(before.Data, after.Data, d.options())
+	if err := writeDiff(os.Stdout, o.output, d); err != nil {
+		logger.Fatalf("cannot write diff: %s", err)
+	}
+
+	if o.fail {
+		for _, c := range d.changes {
+			if c.Kind == digest.Regressed || c.Kind == digest.New {
+				os.Exit(failStatus)
+			}
+		}
+	}
+}
+
+// options returns the options of digest.Diff
+func (d diffReport) options() digest.DiffOptions {
+	return digest.DiffOptions{
+		Percentile: d.percentile,
+		Threshold:  d.threshold / 100,
+		Before:     d.before.TotalDuration,
+		After:      d.after.TotalDuration,
+		MinCalls:   d.minCalls,
+		MinDelta:   d.minDelta.Seconds(),
+	}
+}
+
+// writeDiff writes the diff to w in the given format
+func writeDiff(w io.Writer, format string, d diffReport) error {
+	switch format {
+	case "text":
+		return writeDiffText(w, d)
+	case "json":
+		return writeDiffJSON(w, d)
+	case "markdown":
+		return writeDiffMarkdown(w, d)
+	}
+	return errors.New("unknown output format: " + format)
+}
+
+// byKind returns the changes of a kind
+func (d diffReport) byKind(kind digest.ChangeKind) []digest.Change {
+	var changes []digest.Change
+	for _, c := range d.changes {
+		if c.Kind == kind {
+			changes = append(changes, c)
+		}
+	}
+	return changes
+}
+
+// diffTitles are the titles of the kinds of changes in the reports
+var diffTitles = map[digest.ChangeKind]string{
+	digest.Regressed: "Regressed",
+	digest.New:       "New",
+	digest.Improved:  "Improved",
+	digest.Gone:      "Gone",
+}
+
+// writeDiffText writes the diff as a colourised text, for terminals: the
+// top entries of each kind of change
+func writeDiffText(w io.Writer, d diffReport) error {
+	ew := &errWriter{w: w}
+	pName := digest.PercentileName(d.percentile)
+
+	ew.printf("\n=-= Diff =-=\n\n")
+	ew.printf("%-22s : %s, %d calls in %s, %d entries\n", "Before", d.before.File, d.before.Totals.Calls, d.before.TotalDuration, len(d.before.Data))
+	ew.printf("%-22s : %s, %d calls in %s, %d entries\n", "After", d.after.File, d.after.Totals.Calls, d.after.TotalDuration, len(d.after.Data))
+	ew.printf("%-22s : %.4g%% of call rate, mean time or %s\n", "Threshold", d.threshold, pName)
+	ew.printf("%-22s : %d calls, %s of query time\n", "Minimum", d.minCalls, d.minDelta)
+
+	title := groupTitle(d.groupBy) + " #"
+	for _, kind := range digest.ChangeKinds {
+		changes := d.byKind(kind)
+		ew.printf("\n=-= %s (%d) =-=\n", diffTitles[kind], len(changes))
+		for i, c := range changes {
+			if i == d.top {
+				ew.printf("\n... and %d more\n", len(changes)-d.top)
+				break
+			}
+			e := c.Entry()
+			ew.printf("\n%s%d\n%s", ar.Bold(ar.Underline(title)), ar.Bold(ar.Underline(i+1)), identity(e))
+			switch kind {
+			case digest.New, digest.Gone:
+				ew.printf("%-22s : %d\n%-22s : %s\n%-22s : %s\n",
+					"Calls", e.Calls,
+					"Mean time", fsecsToDuration(e.MeanTime),
+					pName, fsecsToDuration(e.Percentiles[pName]))
+			default:
+				ew.printf("%-22s : %d -> %d (%s)\n%-22s : %s -> %s (%s)\n%-22s : %s -> %s (%s)\n",
+					"Calls", c.Before.Calls, c.After.Calls, formatChange(c.Calls),
+					"Mean time", fsecsToDuration(c.Before.MeanTime), fsecsToDuration(c.After.MeanTime), formatChange(c.Mean),
+					pName, fsecsToDuration(c.Before.Percentiles[pName]), fsecsToDuration(c.After.Percentiles[pName]), formatChange(c.Percentile))
+			}
+		}
+	}
+	ew.printf("\n")
+	return ew.err
+}
+
+// writeDiffMarkdown writes the diff as GitHub flavoured markdown, to be posted
+// on pull requests: a table of the top entries of each kind of change
+func writeDiffMarkdown(w io.Writer, d diffReport) error {
+	ew := &errWriter{w: w}
+	pName := digest.PercentileName(d.percentile)
+
+	ew.printf("## Diff\n\n")
+	ew.printf("| | File | Calls | Duration | Entries |\n| --- | --- | ---: | ---: | ---: |\n")
+	ew.printf("| Before | %s | %d | %s | %d |\n", markdownCell(d.before.File), d.before.Totals.Calls, d.before.TotalDuration, len(d.before.Data))
+	ew.printf("| After | %s | %d | %s | %d |\n", markdownCell(d.after.File), d.after.Totals.Calls, d.after.TotalDuration, len(d.after.Data))
+	ew.printf("\nChanges of more than %.4g%% of the call rate, mean time or %s, with at least %d calls or %s of query time.\n",
+		d.threshold, pName, d.minCalls, d.minDelta)
+
+	for _, kind := range digest.ChangeKinds {
+		changes := d.byKind(kind)
+		ew.printf("\n### %s (%d)\n\n", diffTitles[kind], len(changes))
+		if len(changes) == 0 {
+			ew.printf("None.\n")
+			continue
+		}
+		ew.printf("| # | %s | Calls | Mean time | %s |\n| ---: | --- | ---: | ---: | ---: |\n", groupTitle(d.groupBy), pName)
+		for i, c := range changes {
+			if i == d.top {
+				ew.printf("\nAnd %d more.\n", len(changes)-d.top)
+				break
+			}
+			e := c.Entry()
+			name := markdownCell("`" + entryName(e) + "`")
+			switch kind {
+			case digest.New, digest.Gone:
+				ew.printf("| %d | %s | %d | %s | %s |\n", i+1, name, e.Calls,
+					fsecsToDuration(e.MeanTime), fsecsToDuration(e.Percentiles[pName]))
+			default:
+				ew.printf("| %d | %s | %d → %d (%s) | %s → %s (%s) | %s → %s (%s) |\n", i+1, name,
+					c.Before.Calls, c.After.Calls, formatChange(c.Calls),
+					fsecsToDuration(c.Before.MeanTime), fsecsToDuration(c.After.MeanTime), formatChange(c.Mean),
+					fsecsToDuration(c.Before.Percentiles[pName]), fsecsToDuration(c.After.Percentiles[pName]), formatChange(c.Percentile))
+			}
+		}
+	}
+	return ew.err
+}
+
+// jsonDiff is the JSON document written by diff with -output json. Changes
+// are in percents, and durations in seconds
+type jsonDiff struct {
+	Version    int            `json:"version"`
+	GroupBy    string         `json:"group_by"`
+	Percentile string         `json:"percentile"`
+	Threshold  float64        `json:"threshold"`
+	MinCalls   int            `json:"min_calls"`
+	MinDelta   float64        `json:"min_delta"`
+	Before     jsonDiffDigest `json:"before"`
+	After      jsonDiffDigest `json:"after"`
+	Changes    []jsonChange   `json:"changes"`
+}
+
+// jsonDiffDigest is one of the compared digests
+type jsonDiffDigest struct {
+	File     string  `json:"file"`
+	Calls    int     `json:"calls"`
+	Duration float64 `json:"duration"`
+	Entries  int     `json:"entries"`
+}
+
+// jsonChange is an entry that changed. Before is omitted for new entries, and
+// After for gone ones. A change from zero is null
+type jsonChange struct {
+	Kind             string         `json:"kind"`
+	Hash             string         `json:"hash"`
+	Fingerprint      string         `json:"fingerprint,omitempty"`
+	Table            string         `json:"table,omitempty"`
+	User             string         `json:"user,omitempty"`
+	Host             string         `json:"host,omitempty"`
+	Schema           string         `json:"schema"`
+	Before           *jsonDiffEntry `json:"before,omitempty"`
+	After            *jsonDiffEntry `json:"after,omitempty"`
+	CallsChange      *float64       `json:"calls_change,omitempty"`
+	MeanChange       *float64       `json:"mean_change,omitempty"`
+	PercentileChange *float64       `json:"percentile_change,omitempty"`
+}
+
+// jsonDiffEntry is an entry in one of the compared digests
+type jsonDiffEntry struct {
+	Calls      int     `json:"calls"`
+	QueryTime  float64 `json:"query_time"`
+	Mean       float64 `json:"mean"`
+	Percentile float64 `json:"percentile"`
+}
+
+// writeDiffJSON writes the diff as a JSON document. Every change is written,
+// whatever -top
+func writeDiffJSON(w io.Writer, d diffReport) error {
+	pName := digest.PercentileName(d.percentile)
+	doc := jsonDiff{
+		Version:    jsonVersion,
+		GroupBy:    d.groupBy,
+		Percentile: pName,
+		Threshold:  d.threshold,
+		MinCalls:   d.minCalls,
+		MinDelta:   d.minDelta.Seconds(),
+		Before: jsonDiffDigest{File: d.before.File, Calls: d.before.Totals.Calls,
+			Duration: d.before.TotalDuration.Seconds(), Entries: len(d.before.Data)},
+		After: jsonDiffDigest{File: d.after.File, Calls: d.after.Totals.Calls,
+			Duration: d.after.TotalDuration.Seconds(), Entries: len(d.after.Data)},
+		Changes: []jsonChange{},
+	}
+
+	entry := func(e digest.Entry) *jsonDiffEntry {
+		return &jsonDiffEntry{Calls: e.Calls, QueryTime: e.CumQueryTime, Mean: e.MeanTime, Percentile: e.Percentiles[pName]}
+	}
+	for _, c := range d.changes {
+		e := c.Entry()
+		jc := jsonChange{
+			Kind:        c.Kind.String(),
+			Hash:        e.Hash,
+			Fingerprint: e.Fingerprint,
+			Table:       e.Table,
+			User:        e.User,
+			Host:        e.Host,
+			Schema:      e.Schema,
+		}
+		if c.Kind != digest.New {
+			jc.Before = entry(c.Before)
+		}
+		if c.Kind != digest.Gone {
+			jc.After = entry(c.After)
+		}
+		if c.Kind == digest.Regressed || c.Kind == digest.Improved {
+			jc.CallsChange = percent(c.Calls)
+			jc.MeanChange = percent(c.Mean)
+			jc.PercentileChange = percent(c.Percentile)
+		}
+		doc.Changes = append(doc.Changes, jc)
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(doc)
+}
+
+// percent returns a relative change in percents, or nil for a change from
+// zero, which JSON cannot hold
+func percent(change float64) *float64 {
+	if math.IsInf(change, 0) {
+		return nil
+	}
+	p := change * 100
+	return &p
+}
+
+// formatChange formats a relative change in percents, such as +50.00%
+func formatChange(change float64) string {
+	if math.IsInf(change, 1) {
+		return "from 0"
+	}
+	return fmt.Sprintf("%+.2f%%", change*100)
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/devops-works/slowql/digest"
+)
+
+func testDiff() diffReport {
+	entry := func(hash, fp string, calls int, mean, p95 float64) digest.Entry {
+		return digest.Entry{Hash: hash, Fingerprint: fp, Schema: "shop", Calls: calls, CumQueryTime: float64(calls) * mean,
+			MeanTime: mean, Percentiles: map[string]float64{"p95": p95}}
+	}
+	before := []digest.Entry{
+		entry("4a1cb28f", "select * from orders where id = ?", 50, 0.1, 0.2),
+		entry("9e0d7c11", "delete from carts where updated_at < ?", 10, 0.5, 0.6),
+	}
+	after := []digest.Entry{
+		entry("4a1cb28f", "select * from orders where id = ?", 50, 0.4, 0.8),
+		entry("b7e2a903", "select * from items where order_id in(?+)", 100, 0.05, 0.06),
+	}
+	d := diffReport{
+		before:     results{File: "before.log", TotalDuration: time.Hour, Totals: digest.Totals{Calls: 60}, Data: before},
+		after:      results{File: "after.log", TotalDuration: time.Hour, Totals: digest.Totals{Calls: 150}, Data: after},
+		groupBy:    "fingerprint",
+		percentile: 95,
+		threshold:  20,
+		minCalls:   10,
+		minDelta:   time.Millisecond,
+		top:        3,
+	}
+	d.changes = digest.Diff(before, after, d.options())
+	return d
+}
+
+func Test_writeDiff(t *testing.T) {
+	tests := []struct {
+		format string
+		want   []string
+	}{
+		{format: "text", want: []string{
+			"Before                 : before.log, 60 calls in 1h0m0s, 2 entries\n",
+			"Threshold              : 20% of call rate, mean time or p95\n",
+			"Minimum                : 10 calls, 1ms of query time\n",
+			"=-= Regressed (1) =-=",
+			"Calls                  : 50 -> 50 (+0.00%)\nMean time              : 100ms -> 400ms (+300.00%)\np95                    : 200ms -> 800ms (+300.00%)\n",
+			"=-= New (1) =-=",
+			"Fingerprint            : select * from items where order_id in(?+)\nCalls                  : 100\nMean time              : 50ms\np95                    : 60ms\n",
+			"=-= Improved (0) =-=",
+			"=-= Gone (1) =-=",
+		}},
+		{format: "markdown", want: []string{
+			"| Before | before.log | 60 | 1h0m0s | 2 |\n",
+			"with at least 10 calls or 1ms of query time.\n",
+			"### Regressed (1)\n\n| # | Query | Calls | Mean time | p95 |\n",
+			"| 1 | `select * from orders where id = ?` | 50 → 50 (+0.00%) | 100ms → 400ms (+300.00%) | 200ms → 800ms (+300.00%) |\n",
+			"### Improved (0)\n\nNone.\n",
+			"| 1 | `delete from carts where updated_at < ?` | 10 | 500ms | 600ms |\n",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			var b bytes.Buffer
+			if err := writeDiff(&b, tt.format, testDiff()); err != nil {
+				t.Fatalf("writeDiff() error = %v", err)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(b.String(), want) {
+					t.Errorf("writeDiff() does not contain %q:\n%s", want, b.String())
+				}
+			}
+		})
+	}
+}
+
+func Test_writeDiff_top(t *testing.T) {
+	d := testDiff()
+	d.top = 0
+	var b bytes.Buffer
+	if err := writeDiff(&b, "text", d); err != nil {
+		t.Fatalf("writeDiff() error = %v", err)
+	}
+	if strings.Contains(b.String(), "Fingerprint") || !strings.Contains(b.String(), "... and 1 more") {
+		t.Errorf("writeDiff() shows more than the top entries:\n%s", b.String())
+	}
+}
+
+func Test_writeDiffJSON(t *testing.T) {
+	var b bytes.Buffer
+	if err := writeDiff(&b, "json", testDiff()); err != nil {
+		t.Fatalf("writeDiff() error = %v", err)
+	}
+	var got jsonDiff
+	if err := json.Unmarshal(b.Bytes(), &got); err != nil {
+		t.Fatalf("cannot decode document: %s", err)
+	}
+
+	if got.Percentile != "p95" || got.Threshold != 20 || got.MinCalls != 10 || got.MinDelta != 0.001 ||
+		got.Before.Entries != 2 || got.After.Calls != 150 || got.After.Duration != 3600 {
+		t.Errorf("writeDiffJSON() = %+v", got)
+	}
+	if len(got.Changes) != 3 {
+		t.Fatalf("writeDiffJSON() has %d changes, want 3", len(got.Changes))
+	}
+
+	regressed, added, gone := got.Changes[0], got.Changes[1], got.Changes[2]
+	if regressed.Kind != "regressed" || regressed.Before.Mean != 0.1 || regressed.After.Mean != 0.4 ||
+		*regressed.CallsChange != 0 || math.Abs(*regressed.MeanChange-300) > 1e-9 {
+		t.Errorf("writeDiffJSON() regressed = %+v", regressed)
+	}
+	if added.Kind != "new" || added.Before != nil || added.After.Calls != 100 || added.MeanChange != nil {
+		t.Errorf("writeDiffJSON() new = %+v", added)
+	}
+	if gone.Kind != "gone" || gone.After != nil || gone.Hash != "9e0d7c11" {
+		t.Errorf("writeDiffJSON() gone = %+v", gone)
+	}
+}
+
+func Test_formatChange(t *testing.T) {
+	tests := []struct {
+		name   string
+		change float64
+		want   string
+	}{
+		{name: "increase", change: 0.5, want: "+50.00%"},
+		{name: "decrease", change: -0.25, want: "-25.00%"},
+		{name: "unchanged", change: 0, want: "+0.00%"},
+		{name: "from zero", change: math.Inf(1), want: "from 0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := formatChange(tt.change); got != tt.want {
+				t.Errorf("formatChange() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
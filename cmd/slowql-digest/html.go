@@ -0,0 +1,467 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/devops-works/slowql/digest"
+	"github.com/devops-works/slowql/query/structure"
+	"github.com/devops-works/slowql/sketch"
+)
+
+const (
+	// histogramBinsPerDecade is the number of bins of the latency histograms
+	// for each power of ten
+	histogramBinsPerDecade = 4
+	// maxHistogramBins bounds the number of bins of a latency histogram
+	maxHistogramBins = 40
+	// maxTimelineBars bounds the number of bars of the query time chart
+	maxTimelineBars = 120
+
+	chartWidth  = 600.0
+	chartHeight = 120.0
+)
+
+// htmlReport is the data of the HTML template
+type htmlReport struct {
+	Meta   serverMeta
+	Totals digest.Totals
+	Load   []htmlLoad
+	// Title and Column name the entries, after how they are grouped, such as
+	// Queries and Fingerprint
+	Title    string
+	Column   string
+	Order    string
+	Dec      bool
+	Entries  []htmlEntry
+	Timeline htmlChart
+	// Percentiles are the names of the percentiles columns
+	Percentiles []string
+}
+
+type htmlLoad struct {
+	Class     string
+	Calls     int
+	QueryTime time.Duration
+	Share     float64
+}
+
+type htmlEntry struct {
+	Rank            int
+	Hash            string
+	Name            string
+	Schema          string
+	Calls           int
+	CumQueryTime    float64
+	MeanTime        float64
+	Percentiles     []float64
+	MaxTime         float64
+	CumRowsExamined int
+	CumBytesSent    int
+	Concurrency     float64
+	Slowest         htmlSample
+	Samples         []htmlSample
+	// Users and Hosts are the main users and hosts of the entry, formatted
+	Users, Hosts  string
+	Histogram     htmlChart
+	Distributions []htmlDistribution
+}
+
+// htmlSample is a sampled query, with where it comes from
+type htmlSample struct {
+	Query string
+	From  string
+}
+
+// htmlDistribution is the distribution of a metric of an entry, formatted
+type htmlDistribution struct {
+	Metric         string
+	Min, Max, Mean string
+	Percentiles    []string
+}
+
+// htmlChart is a bar chart, drawn as SVG
+type htmlChart struct {
+	Bars        []htmlBar
+	First, Last string
+	Max         string
+}
+
+type htmlBar struct {
+	X, Y, W, H float64
+	Title      string
+}
+
+// writeHTML writes the report as a self-contained HTML page, without any
+// external resource, so that it can be shared as a single file
+func writeHTML(w io.Writer, r report) error {
+	data := htmlReport{
+		Meta:     r.meta,
+		Totals:   r.totals,
+		Title:    "Queries",
+		Column:   "Fingerprint",
+		Order:    r.order,
+		Dec:      r.dec,
+		Timeline: timelineChart(r.timeline),
+	}
+	if t := groupTitle(r.groupBy); t != "Query" {
+		data.Title, data.Column = t+"s", t
+	}
+	for _, p := range r.percentiles {
+		data.Percentiles = append(data.Percentiles, digest.PercentileName(p))
+	}
+
+	var total float64
+	for _, l := range r.load {
+		total += l.CumQueryTime
+	}
+	for _, c := range structure.Classes {
+		l := r.load[c.String()]
+		share := 0.0
+		if total > 0 {
+			share = 100 * l.CumQueryTime / total
+		}
+		data.Load = append(data.Load, htmlLoad{
+			Class:     c.String(),
+			Calls:     l.Calls,
+			QueryTime: fsecsToDuration(l.CumQueryTime),
+			Share:     share,
+		})
+	}
+
+	for i, s := range r.stats {
+		if i == r.top {
+			break
+		}
+		name := entryName(s)
+		var percentiles []float64
+		for _, p := range data.Percentiles {
+			percentiles = append(percentiles, s.Percentiles[p])
+		}
+		var distributions []htmlDistribution
+		for _, m := range r.metrics {
+			d := s.Distributions[m]
+			hd := htmlDistribution{
+				Metric: metricTitles[m],
+				Min:    formatMetric(m, d.Min),
+				Max:    formatMetric(m, d.Max),
+				Mean:   formatMetric(m, d.Mean),
+			}
+			for _, p := range data.Percentiles {
+				hd.Percentiles = append(hd.Percentiles, formatMetric(m, d.Percentiles[p]))
+			}
+			distributions = append(distributions, hd)
+		}
+		var slowest htmlSample
+		if s.Slowest.Query != "" {
+			slowest = htmlSample{Query: s.Slowest.Query, From: formatSample(s.Slowest)}
+		}
+		var samples []htmlSample
+		for _, sample := range s.Samples {
+			samples = append(samples, htmlSample{Query: sample.Query, From: formatSample(sample)})
+		}
+		data.Entries = append(data.Entries, htmlEntry{
+			Rank:            i + 1,
+			Hash:            s.Hash,
+			Name:            name,
+			Schema:          s.Schema,
+			Calls:           s.Calls,
+			CumQueryTime:    s.CumQueryTime,
+			MeanTime:        s.MeanTime,
+			Percentiles:     percentiles,
+			MaxTime:         s.MaxTime,
+			CumRowsExamined: s.CumRowsExamined,
+			CumBytesSent:    s.CumBytesSent,
+			Concurrency:     s.Concurrency,
+			Slowest:         slowest,
+			Samples:         samples,
+			Users:           formatShares(s.Users, s.CumQueryTime),
+			Hosts:           formatShares(s.Hosts, s.CumQueryTime),
+			Histogram:       histogramChart(s.Sketch),
+			Distributions:   distributions,
+		})
+	}
+
+	return htmlTemplate.Execute(w, data)
+}
+
+// histogramChart returns the latency histogram of the query times, with
+// logarithmic bins
+func histogramChart(sk *sketch.Sketch) htmlChart {
+	var c htmlChart
+	if sk == nil || sk.Count() == 0 {
+		return c
+	}
+
+	bin := func(t float64) int {
+		if t <= 0 {
+			// the smallest logged time is a microsecond
+			t = 1e-6
+		}
+		return int(math.Floor(math.Log10(t) * histogramBinsPerDecade))
+	}
+	counts := make(map[int]int)
+	lo, hi := math.MaxInt32, math.MinInt32
+	sk.Buckets(func(lower, upper float64, count uint64) {
+		// the buckets of the sketch are much narrower than the bins, so
+		// their middle stands for all their values
+		b := bin(math.Sqrt(lower * upper))
+		counts[b] += int(count)
+		if b < lo {
+			lo = b
+		}
+		if b > hi {
+			hi = b
+		}
+	})
+	// the slowest bins matter more than the fastest ones
+	if hi-lo+1 > maxHistogramBins {
+		for b := lo; b <= hi-maxHistogramBins; b++ {
+			counts[hi-maxHistogramBins+1] += counts[b]
+		}
+		lo = hi - maxHistogramBins + 1
+	}
+
+	max := 0
+	for b := lo; b <= hi; b++ {
+		if counts[b] > max {
+			max = counts[b]
+		}
+	}
+
+	bound := func(b int) time.Duration {
+		return roundDuration(time.Duration(math.Pow(10, float64(b)/histogramBinsPerDecade) * float64(time.Second)))
+	}
+	width := chartWidth / float64(hi-lo+1)
+	for b := lo; b <= hi; b++ {
+		h := chartHeight * float64(counts[b]) / float64(max)
+		c.Bars = append(c.Bars, htmlBar{
+			X:     float64(b-lo) * width,
+			Y:     chartHeight - h,
+			W:     width,
+			H:     h,
+			Title: fmt.Sprintf("%s to %s: %d calls", bound(b), bound(b+1), counts[b]),
+		})
+	}
+	c.First = bound(lo).String()
+	c.Last = bound(hi + 1).String()
+	c.Max = fmt.Sprintf("%d calls", max)
+	return c
+}
+
+// timelineChart returns the chart of the query time over time, from the load
+// of each minute
+func timelineChart(timeline map[int64]digest.Load) htmlChart {
+	var c htmlChart
+	if len(timeline) == 0 {
+		return c
+	}
+
+	var minutes []int64
+	for m := range timeline {
+		minutes = append(minutes, m)
+	}
+	sort.Slice(minutes, func(i, j int) bool { return minutes[i] < minutes[j] })
+	first, last := minutes[0], minutes[len(minutes)-1]
+
+	// group minutes so that there are at most maxTimelineBars bars
+	step := int64(60)
+	if n := (last-first)/60 + 1; n > maxTimelineBars {
+		step = 60 * ((n + maxTimelineBars - 1) / maxTimelineBars)
+	}
+	bars := int((last-first)/step) + 1
+	sums := make([]float64, bars)
+	for _, m := range minutes {
+		sums[(m-first)/step] += timeline[m].CumQueryTime
+	}
+
+	max := 0.0
+	for _, s := range sums {
+		max = math.Max(max, s)
+	}
+
+	width := chartWidth / float64(bars)
+	for i, s := range sums {
+		h := 0.0
+		if max > 0 {
+			h = chartHeight * s / max
+		}
+		start := time.Unix(first+int64(i)*step, 0).UTC()
+		c.Bars = append(c.Bars, htmlBar{
+			X:     float64(i) * width,
+			Y:     chartHeight - h,
+			W:     width,
+			H:     h,
+			Title: fmt.Sprintf("%s: %s", start.Format("2006-01-02 15:04"), fsecsToDuration(s)),
+		})
+	}
+	c.First = time.Unix(first, 0).UTC().Format("2006-01-02 15:04")
+	c.Last = time.Unix(last+60, 0).UTC().Format("2006-01-02 15:04")
+	c.Max = fmt.Sprintf("%s per %s", fsecsToDuration(max), time.Duration(step)*time.Second)
+	return c
+}
+
+// roundDuration rounds d to three significant digits
+func roundDuration(d time.Duration) time.Duration {
+	if d < 1000 {
+		return d
+	}
+	return d.Round(time.Duration(math.Pow(10, math.Floor(math.Log10(float64(d)))-2)))
+}
+
+var htmlTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"duration": fsecsToDuration,
+	"width":    func() float64 { return chartWidth },
+	"height":   func() float64 { return chartHeight },
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>slowql digest{{with .Meta.Version}} - {{.}}{{end}}</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; margin: 2em; color: #222; }
+h1, h2 { font-weight: 600; }
+table { border-collapse: collapse; margin-bottom: 1.5em; }
+th, td { border: 1px solid #ddd; padding: 4px 8px; text-align: left; vertical-align: top; }
+th { background: #f4f4f4; }
+#entries th { cursor: pointer; user-select: none; }
+#entries th:after { content: " \2195"; color: #aaa; }
+td.num { text-align: right; font-variant-numeric: tabular-nums; }
+code, pre { font-family: Menlo, Consolas, monospace; font-size: 0.9em; }
+pre { background: #f7f7f7; padding: 8px; white-space: pre-wrap; word-break: break-all; }
+svg rect { fill: #4c72b0; }
+svg rect:hover { fill: #dd8452; }
+.axis { display: flex; justify-content: space-between; width: {{width}}px; color: #666; font-size: 0.8em; }
+.entry { margin-bottom: 2em; }
+</style>
+</head>
+<body>
+<h1>slowql digest</h1>
+
+<h2>Server meta</h2>
+<table>
+<tr><th>Binary</th><td>{{.Meta.Binary}}</td></tr>
+<tr><th>Port</th><td>{{.Meta.Port}}</td></tr>
+<tr><th>Socket</th><td>{{.Meta.Socket}}</td></tr>
+<tr><th>Version</th><td>{{.Meta.Version}}</td></tr>
+<tr><th>Version description</th><td>{{.Meta.VersionDescription}}</td></tr>
+<tr><th>Digest duration</th><td>{{.Meta.Duration}}</td></tr>
+<tr><th>Real duration</th><td>{{.Meta.RealDuration}}</td></tr>
+<tr><th>Calls</th><td>{{.Totals.Calls}}</td></tr>
+<tr><th>Cum Query Time</th><td>{{duration .Totals.CumQueryTime}}</td></tr>
+<tr><th>Bytes handled</th><td>{{.Meta.Bytes}}</td></tr>
+</table>
+
+<h2>Load split</h2>
+<table>
+<tr><th>Class</th><th>Calls</th><th>Query time</th><th>Share</th></tr>
+{{- range .Load}}
+<tr><td>{{.Class}}</td><td class="num">{{.Calls}}</td><td class="num">{{.QueryTime}}</td><td class="num">{{printf "%.2f" .Share}}%</td></tr>
+{{- end}}
+</table>
+
+<h2>Query time over time</h2>
+{{- with .Timeline}}{{if .Bars}}
+<svg width="{{width}}" height="{{height}}" role="img">
+{{- range .Bars}}
+<rect x="{{.X}}" y="{{.Y}}" width="{{.W}}" height="{{.H}}"><title>{{.Title}}</title></rect>
+{{- end}}
+</svg>
+<div class="axis"><span>{{.First}}</span><span>max {{.Max}}</span><span>{{.Last}}</span></div>
+{{- else}}
+<p>No timeline available.</p>
+{{- end}}{{end}}
+
+<h2>{{.Title}}</h2>
+<p>Sorted by {{.Order}}, {{if .Dec}}decreasing{{else}}increasing{{end}}. Click on a column to sort the table.</p>
+<table id="entries">
+<thead>
+<tr><th>#</th><th>{{.Column}}</th><th>Schema</th><th>Calls</th><th>Cum Query Time</th><th>Mean</th>{{range .Percentiles}}<th>{{.}}</th>{{end}}<th>Max</th><th>Rows examined</th><th>Bytes sent</th><th>Concurrency</th></tr>
+</thead>
+<tbody>
+{{- range .Entries}}
+<tr>
+<td class="num" data-value="{{.Rank}}"><a href="#q{{.Rank}}">{{.Rank}}</a></td>
+<td><code>{{.Name}}</code></td>
+<td>{{.Schema}}</td>
+<td class="num" data-value="{{.Calls}}">{{.Calls}}</td>
+<td class="num" data-value="{{.CumQueryTime}}">{{duration .CumQueryTime}}</td>
+<td class="num" data-value="{{.MeanTime}}">{{duration .MeanTime}}</td>
+{{range .Percentiles}}<td class="num" data-value="{{.}}">{{duration .}}</td>{{end}}
+<td class="num" data-value="{{.MaxTime}}">{{duration .MaxTime}}</td>
+<td class="num" data-value="{{.CumRowsExamined}}">{{.CumRowsExamined}}</td>
+<td class="num" data-value="{{.CumBytesSent}}">{{.CumBytesSent}}</td>
+<td class="num" data-value="{{.Concurrency}}">{{printf "%.4f" .Concurrency}}%</td>
+</tr>
+{{- end}}
+</tbody>
+</table>
+
+{{- range .Entries}}
+<div class="entry" id="q{{.Rank}}">
+<h3>#{{.Rank}} <small>{{.Hash}}</small></h3>
+<pre>{{.Name}}</pre>
+{{- if .Users}}
+<p>Users: {{.Users}}</p>
+{{- end}}
+{{- if .Hosts}}
+<p>Hosts: {{.Hosts}}</p>
+{{- end}}
+{{- with .Histogram}}{{if .Bars}}
+<svg width="{{width}}" height="{{height}}" role="img">
+{{- range .Bars}}
+<rect x="{{.X}}" y="{{.Y}}" width="{{.W}}" height="{{.H}}"><title>{{.Title}}</title></rect>
+{{- end}}
+</svg>
+<div class="axis"><span>{{.First}}</span><span>max {{.Max}}</span><span>{{.Last}}</span></div>
+{{- end}}{{end}}
+{{- if .Distributions}}
+<table>
+<tr><th>Metric</th><th>Min</th><th>Max</th><th>Mean</th>{{range $.Percentiles}}<th>{{.}}</th>{{end}}</tr>
+{{- range .Distributions}}
+<tr><td>{{.Metric}}</td><td class="num">{{.Min}}</td><td class="num">{{.Max}}</td><td class="num">{{.Mean}}</td>{{range .Percentiles}}<td class="num">{{.}}</td>{{end}}</tr>
+{{- end}}
+</table>
+{{- end}}
+{{- with .Slowest}}{{if .Query}}
+<details>
+<summary>Slowest query: {{.From}}</summary>
+<pre>{{.Query}}</pre>
+</details>
+{{- end}}{{end}}
+{{- if .Samples}}
+<details>
+<summary>Sampled queries</summary>
+{{- range .Samples}}
+<p>{{.From}}</p>
+<pre>{{.Query}}</pre>
+{{- end}}
+</details>
+{{- end}}
+</div>
+{{- end}}
+
+<script>
+document.querySelectorAll("#entries th").forEach(function (th, col) {
+  var asc = false;
+  th.addEventListener("click", function () {
+    var tbody = document.querySelector("#entries tbody");
+    var rows = Array.prototype.slice.call(tbody.rows);
+    asc = !asc;
+    rows.sort(function (a, b) {
+      var x = a.cells[col], y = b.cells[col];
+      var vx = x.dataset.value, vy = y.dataset.value;
+      var c = vx !== undefined ? parseFloat(vx) - parseFloat(vy) : x.textContent.localeCompare(y.textContent);
+      return asc ? c : -c;
+    });
+    rows.forEach(function (r) { tbody.appendChild(r); });
+  });
+});
+</script>
+</body>
+</html>
+`))
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/devops-works/slowql/digest"
+	"github.com/devops-works/slowql/query/structure"
+)
+
+// jsonVersion is the version of the JSON document. It is increased when a
+// field is removed or its meaning changes, not when a field is added
+const jsonVersion = 1
+
+// jsonReport is the JSON document written with -output json. Durations are
+// in seconds
+type jsonReport struct {
+	Version    int                 `json:"version"`
+	Server     jsonServer          `json:"server"`
+	GroupBy    string              `json:"group_by"`
+	SortBy     string              `json:"sort_by"`
+	Decreasing bool                `json:"decreasing"`
+	Totals     jsonTotals          `json:"totals"`
+	Load       map[string]jsonLoad `json:"load"`
+	// Bucket, SeriesPercentile and Series are only set with -bucket
+	Bucket           float64     `json:"bucket,omitempty"`
+	SeriesPercentile string      `json:"series_percentile,omitempty"`
+	Series           []jsonPoint `json:"series,omitempty"`
+	Entries          []jsonEntry `json:"entries"`
+}
+
+type jsonServer struct {
+	Binary             string  `json:"binary"`
+	Port               int     `json:"port"`
+	Socket             string  `json:"socket"`
+	Version            string  `json:"version"`
+	VersionShort       string  `json:"version_short"`
+	VersionDescription string  `json:"version_description"`
+	DigestDuration     float64 `json:"digest_duration"`
+	RealDuration       float64 `json:"real_duration"`
+	Bytes              int     `json:"bytes"`
+}
+
+type jsonTotals struct {
+	Entries      int     `json:"entries"`
+	Calls        int     `json:"calls"`
+	QueryTime    float64 `json:"query_time"`
+	LockTime     float64 `json:"lock_time"`
+	RowsSent     int     `json:"rows_sent"`
+	RowsExamined int     `json:"rows_examined"`
+	BytesSent    int     `json:"bytes_sent"`
+	Killed       int     `json:"killed"`
+}
+
+type jsonLoad struct {
+	Calls     int     `json:"calls"`
+	QueryTime float64 `json:"query_time"`
+}
+
+// jsonEntry is a fingerprint, or a table, user, host, schema or combination
+// of them, depending on the grouping
+type jsonEntry struct {
+	Hash         string           `json:"hash"`
+	Fingerprint  string           `json:"fingerprint,omitempty"`
+	Table        string           `json:"table,omitempty"`
+	User         string           `json:"user,omitempty"`
+	Host         string           `json:"host,omitempty"`
+	Schema       string           `json:"schema"`
+	Calls        int              `json:"calls"`
+	Errored      int              `json:"errored"`
+	Killed       int              `json:"killed"`
+	Concurrency  float64          `json:"concurrency"`
+	QueryTime    jsonDistribution `json:"query_time"`
+	LockTime     jsonMetric       `json:"lock_time"`
+	RowsSent     jsonMetric       `json:"rows_sent"`
+	RowsExamined jsonMetric       `json:"rows_examined"`
+	BytesSent    jsonMetric       `json:"bytes_sent"`
+	Slowest      *jsonSample      `json:"slowest,omitempty"`
+	Samples      []jsonSample     `json:"samples,omitempty"`
+	Users        []jsonShare      `json:"users,omitempty"`
+	Hosts        []jsonShare      `json:"hosts,omitempty"`
+	// Series only has the intervals with queries of the entry
+	Series []jsonPoint `json:"series,omitempty"`
+}
+
+// jsonPoint is an interval of a series, with the calls, query time and its
+// percentile of the queries logged in it
+type jsonPoint struct {
+	Start      time.Time `json:"start"`
+	Calls      int       `json:"calls"`
+	QueryTime  float64   `json:"query_time"`
+	Percentile float64   `json:"percentile"`
+}
+
+// jsonSample is a query of an entry. Its time, user, host, schema and line
+// are omitted when unknown
+type jsonSample struct {
+	Query     string     `json:"query"`
+	Time      *time.Time `json:"time,omitempty"`
+	QueryTime float64    `json:"query_time"`
+	User      string     `json:"user,omitempty"`
+	Host      string     `json:"host,omitempty"`
+	Schema    string     `json:"schema,omitempty"`
+	Line      int        `json:"line,omitempty"`
+}
+
+// jsonShare is the load of a user or a host in an entry
+type jsonShare struct {
+	Name      string  `json:"name"`
+	Calls     int     `json:"calls"`
+	QueryTime float64 `json:"query_time"`
+}
+
+// jsonMetric is a metric aggregated as a sum, with its distribution when it
+// is selected with -metrics
+type jsonMetric struct {
+	Sum float64 `json:"sum"`
+	*jsonSummary
+}
+
+type jsonSummary struct {
+	Min         float64            `json:"min"`
+	Max         float64            `json:"max"`
+	Mean        float64            `json:"mean"`
+	Percentiles map[string]float64 `json:"percentiles"`
+}
+
+type jsonDistribution struct {
+	Sum         float64            `json:"sum"`
+	Min         float64            `json:"min"`
+	Max         float64            `json:"max"`
+	Mean        float64            `json:"mean"`
+	Stddev      float64            `json:"stddev"`
+	Percentiles map[string]float64 `json:"percentiles"`
+}
+
+// writeJSON writes the report as a JSON document. Every entry is written,
+// whatever -top
+func writeJSON(w io.Writer, r report) error {
+	doc := jsonReport{
+		Version: jsonVersion,
+		Server: jsonServer{
+			Binary:             r.meta.Binary,
+			Port:               r.meta.Port,
+			Socket:             r.meta.Socket,
+			Version:            r.meta.Version,
+			VersionShort:       r.meta.VersionShort,
+			VersionDescription: r.meta.VersionDescription,
+			DigestDuration:     r.meta.Duration.Seconds(),
+			RealDuration:       r.meta.RealDuration.Seconds(),
+			Bytes:              r.meta.Bytes,
+		},
+		GroupBy:    r.groupBy,
+		SortBy:     r.order,
+		Decreasing: r.dec,
+		Totals: jsonTotals{
+			Entries:      len(r.stats),
+			Calls:        r.totals.Calls,
+			QueryTime:    r.totals.CumQueryTime,
+			LockTime:     r.totals.CumLockTime,
+			RowsSent:     r.totals.CumRowsSent,
+			RowsExamined: r.totals.CumRowsExamined,
+			BytesSent:    r.totals.CumBytesSent,
+			Killed:       r.totals.CumKilled,
+		},
+		Load:    make(map[string]jsonLoad),
+		Entries: []jsonEntry{},
+	}
+
+	// every class is written, even without queries, so that consumers do not
+	// have to handle missing keys
+	for _, c := range structure.Classes {
+		l := r.load[c.String()]
+		doc.Load[c.String()] = jsonLoad{Calls: l.Calls, QueryTime: l.CumQueryTime}
+	}
+
+	if r.bucket > 0 {
+		doc.Bucket = r.bucket.Seconds()
+		doc.SeriesPercentile = digest.PercentileName(highestPercentile(r.percentiles))
+		doc.Series = newJSONSeries(reportSeries(r, r.buckets), true)
+	}
+
+	for _, s := range r.stats {
+		// caches created before queries were sampled have no slowest query
+		var slowest *jsonSample
+		if s.Slowest.Query != "" {
+			js := newJSONSample(s.Slowest)
+			slowest = &js
+		}
+		var samples []jsonSample
+		for _, sample := range s.Samples {
+			samples = append(samples, newJSONSample(sample))
+		}
+		doc.Entries = append(doc.Entries, jsonEntry{
+			Hash:        s.Hash,
+			Fingerprint: s.Fingerprint,
+			Table:       s.Table,
+			User:        s.User,
+			Host:        s.Host,
+			Schema:      s.Schema,
+			Calls:       s.Calls,
+			Errored:     s.CumErrored,
+			Killed:      s.CumKilled,
+			Concurrency: s.Concurrency,
+			QueryTime: jsonDistribution{
+				Sum:         s.CumQueryTime,
+				Min:         s.MinTime,
+				Max:         s.MaxTime,
+				Mean:        s.MeanTime,
+				Stddev:      s.StddevTime,
+				Percentiles: s.Percentiles,
+			},
+			LockTime:     newJSONMetric(s, "lock_time", s.CumLockTime, r.metrics),
+			RowsSent:     newJSONMetric(s, "rows_sent", float64(s.CumRowsSent), r.metrics),
+			RowsExamined: newJSONMetric(s, "rows_examined", float64(s.CumRowsExamined), r.metrics),
+			BytesSent:    newJSONMetric(s, "bytes_sent", float64(s.CumBytesSent), r.metrics),
+			Slowest:      slowest,
+			Samples:      samples,
+			Users:        newJSONShares(s.Users),
+			Hosts:        newJSONShares(s.Hosts),
+			Series:       newJSONSeries(reportSeries(r, s.Buckets), false),
+		})
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(doc)
+}
+
+// newJSONMetric returns a metric of an entry, with its distribution when it
+// is one of the shown metrics
+func newJSONMetric(s digest.Entry, metric string, sum float64, shown []string) jsonMetric {
+	jm := jsonMetric{Sum: sum}
+	if d, ok := s.Distributions[metric]; ok && stringInSlice(metric, shown) {
+		jm.jsonSummary = &jsonSummary{Min: d.Min, Max: d.Max, Mean: d.Mean, Percentiles: d.Percentiles}
+	}
+	return jm
+}
+
+// newJSONShares returns every user or host of an entry, the most query time
+// first
+func newJSONShares(loads map[string]digest.Load) []jsonShare {
+	var shares []jsonShare
+	for _, s := range digest.Top(loads, len(loads)) {
+		shares = append(shares, jsonShare{Name: s.Name, Calls: s.Calls, QueryTime: s.CumQueryTime})
+	}
+	return shares
+}
+
+// newJSONSample returns a sampled query
+func newJSONSample(s digest.Sample) jsonSample {
+	js := jsonSample{
+		Query:     s.Query,
+		QueryTime: s.QueryTime,
+		User:      s.User,
+		Host:      s.Host,
+		Schema:    s.Schema,
+		Line:      s.Line,
+	}
+	if !s.Time.IsZero() {
+		js.Time = &s.Time
+	}
+	return js
+}
+
+// newJSONSeries returns the points of a series. The intervals without queries
+// are only kept if empty is true, so that the series of every entry do not
+// repeat the whole span
+func newJSONSeries(points []digest.Point, empty bool) []jsonPoint {
+	var series []jsonPoint
+	for _, p := range points {
+		if p.Calls == 0 && !empty {
+			continue
+		}
+		series = append(series, jsonPoint{Start: p.Start, Calls: p.Calls, QueryTime: p.CumQueryTime, Percentile: p.Percentile})
+	}
+	return series
+}
@@ -2,16 +2,14 @@ package main
 
 import (
 	"bytes"
-	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"os"
-	"sort"
-	"sync"
 	"time"
 
 	"github.com/devops-works/slowql"
+	"github.com/devops-works/slowql/digest"
 	"github.com/devops-works/slowql/query"
 	"github.com/devops-works/slowql/server"
 	ar "github.com/logrusorgru/aurora"
@@ -26,28 +24,39 @@ type options struct {
 	order    string
 	dec      bool
 	nocache  bool
-}
-
-type statistics struct {
-	Hash            string
-	Fingerprint     string
-	Schema          string
-	Calls           int
-	CumErrored      int
-	CumKilled       int
-	CumQueryTime    float64
-	CumLockTime     float64
-	CumRowsSent     int
-	CumRowsExamined int
-	CumBytesSent    int
-	Concurrency     float64
-	MinTime         float64
-	MaxTime         float64
-	MeanTime        float64
-	P50Time         float64
-	P95Time         float64
-	StddevTime      float64
-	QueryTimes      []float64
+	groupBy  string
+	filter   string
+	since    string
+	until    string
+	output   string
+
+	percentiles string
+	// percentileValues are the percentiles once parsed
+	percentileValues []float64
+	metrics          string
+	// metricNames are the metrics whose distribution is shown, once parsed
+	metricNames []string
+	workers     int
+	// samples is the number of queries sampled in each entry
+	samples int
+	// bucket is the width of the intervals queries are followed over, or 0
+	bucket time.Duration
+	// baseline is the cache diff compares with, instead of a log
+	baseline string
+	// threshold is the percentage above which diff reports a change
+	threshold float64
+	// diffPercentile is the percentile of the query time diff compares
+	diffPercentile float64
+	// minCalls and minDelta are the calls and the change of the query time
+	// below which diff does not report a change
+	minCalls int
+	minDelta time.Duration
+	// fail makes diff exit with failStatus when queries regressed or are new
+	fail bool
+
+	// sinceTime and untilTime are since and until once parsed
+	sinceTime time.Time
+	untilTime time.Time
 }
 
 type serverMeta struct {
@@ -63,96 +72,159 @@ type serverMeta struct {
 	Bytes        int
 }
 
-var orders = []string{"bytes_sent", "calls", "concurrency", "killed", "lock_time",
-	"max_time", "mean_time", "min_time", "p50", "p90", "query_time", "random", "rows_examined", "rows_sent"}
+// queueSize is the number of parsed queries that can wait for each worker
+const queueSize = 256
+
+// dbKinds lists the values of -k
+var dbKinds = []string{"mariadb", "mysql", "postgresql", "pxc", "tidb"}
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "diff" {
+		diffMain(os.Args[2:])
+		return
+	}
+
 	var o options
 	flag.StringVar(&o.logfile, "f", "/log/slowquery.log", "Slow query log file to digest "+ar.Red("(required)").String())
-	flag.StringVar(&o.loglevel, "l", "info", "Log level")
-	flag.StringVar(&o.kind, "k", "", "Database kind. Use ? to see all the available values  "+ar.Red("(required)").String())
-	flag.IntVar(&o.top, "top", 3, "Top queries to show")
-	flag.StringVar(&o.order, "sort-by", "random", "How to sort queries. Use ? to see all the available values")
-	flag.BoolVar(&o.dec, "dec", false, "Sort by decreasing order")
-	flag.BoolVar(&o.nocache, "no-cache", false, "Do not use cache, if cache exists")
+	o.setFlags(flag.CommandLine)
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %[1]s [options]\n       %[1]s diff [options] before.log after.log\n", os.Args[0])
+		flag.PrintDefaults()
+	}
 	flag.Parse()
+	if o.help() {
+		return
+	}
 
+	errs := o.parse()
+	if len(errs) != 0 {
+		flag.Usage()
+		for _, e := range errs {
+			logrus.Warn(e)
+		}
+		logrus.Fatal("cannot parse options")
+	}
+
+	res, logger := digestFile(&o)
+	if err := writeReport(os.Stdout, o.output, newReport(res, o)); err != nil {
+		logger.Fatalf("cannot write report: %s", err)
+	}
+	logger.Debug("end of program, exiting")
+}
+
+// setFlags defines the flags shared by the digest of a log and the diff of
+// two logs
+func (o *options) setFlags(fs *flag.FlagSet) {
+	fs.StringVar(&o.loglevel, "l", "info", "Log level")
+	fs.StringVar(&o.kind, "k", "", "Database kind. Use ? to see all the available values  "+ar.Red("(required)").String())
+	fs.IntVar(&o.top, "top", 3, "Top queries to show")
+	fs.StringVar(&o.order, "sort-by", "random", "How to sort queries. Use ? to see all the available values")
+	fs.BoolVar(&o.dec, "dec", false, "Sort by decreasing order")
+	fs.BoolVar(&o.nocache, "no-cache", false, "Do not use cache, if cache exists")
+	fs.StringVar(&o.groupBy, "group-by", "fingerprint", "How to group queries: fingerprint, table, user, host, schema, or a comma separated combination of them, e.g. fingerprint,user")
+	fs.StringVar(&o.filter, "filter", "", "Only digest the queries matching this expression, e.g. 'query_time > 1 && schema == \"shop\"'")
+	fs.StringVar(&o.since, "since", "", "Only digest the queries logged at or after this time, e.g. 2021-03-23T11:00:00Z or -2h")
+	fs.StringVar(&o.until, "until", "", "Only digest the queries logged at or before this time, e.g. 2021-03-23T12:00:00Z or -1h")
+	fs.StringVar(&o.percentiles, "percentiles", "50,95", "Comma separated percentiles to compute, e.g. 50,95,99,99.9")
+	fs.StringVar(&o.metrics, "metrics", "", "Comma separated metrics whose distribution is shown besides the query time: all, lock_time, rows_sent, rows_examined or bytes_sent")
+	fs.IntVar(&o.samples, "samples", digest.DefaultSamples, "Number of queries picked at random in each entry, besides the slowest one")
+	fs.DurationVar(&o.bucket, "bucket", 0, "Follow the calls, query time and its highest percentile over intervals of this width, e.g. 1m (default none)")
+	fs.IntVar(&o.workers, "workers", 0, "Number of workers digesting queries (default the number of CPUs)")
+	fs.StringVar(&o.output, "output", "text", "Output format: csv, csv-series, html, json, markdown, pt, text, tsv or tsv-series")
+}
+
+// help prints the available values of -sort-by or -k when they are ?, and
+// returns true if it did
+func (o *options) help() bool {
 	if o.order == "?" {
 		fmt.Println("Available values:")
-		for _, val := range orders {
+		for _, val := range digest.Orders {
 			fmt.Printf("    %s\n", val)
 		}
-		return
+		fmt.Println("    p<percentile>, for any of -percentiles (e.g. p95)")
+		for _, m := range digest.Metrics {
+			fmt.Printf("    %s_<min|max|mean|p<percentile>> (e.g. %s_p95)\n", m, m)
+		}
+		return true
 	}
 
-	dbKinds := []string{"mariadb", "mysql", "pxc"}
 	if o.kind == "?" {
 		fmt.Println("Available values:")
 		for _, val := range dbKinds {
 			fmt.Printf("    %s\n", val)
 		}
-		return
-	}
-
-	errs := o.parse()
-	if len(errs) != 0 {
-		flag.Usage()
-		for _, e := range errs {
-			logrus.Warn(e)
-		}
-		logrus.Fatal("cannot parse options")
+		return true
 	}
+	return false
+}
 
+// digestFile digests o.logfile, or restores its cache when it was created
+// with the same options, and returns the results with their entries computed
+// and sorted, along with the logger of the digest
+func digestFile(o *options) (results, *logrus.Logger) {
 	a, err := newApp(o.loglevel, o.kind)
 	if err != nil {
 		logrus.Fatalf("cannot create app: %s", err)
 	}
+	a.groupBy = o.groupBy
+	a.samples = o.samples
+	a.bucket = o.bucket
+	if o.workers > 0 {
+		a.workers = o.workers
+	}
+	if o.filter != "" {
+		a.filter, err = slowql.NewFilter(o.filter)
+		if err != nil {
+			logrus.Fatalf("cannot parse filter: %s", err)
+		}
+	}
 
 	// if we want to use cache and the cache file exists...
 	if !o.nocache && findCache(o.logfile) {
 		a.logger.Infof("cache found: %s. Trying to restore it", o.logfile+".cache")
 		// ...we try to restore it
 		res, err := restoreCache(o.logfile)
+		if err == nil && (res.GroupBy != o.groupBy || res.Filter != o.filter ||
+			!res.Since.Equal(o.sinceTime) || !res.Until.Equal(o.untilTime) || res.Samples != o.samples || res.Bucket != o.bucket) {
+			err = fmt.Errorf("cache was created with -group-by %s -filter %q -since %q -until %q -samples %d -bucket %s",
+				res.GroupBy, res.Filter, formatTime(res.Since), formatTime(res.Until), res.Samples, res.Bucket)
+		}
 		if err != nil {
 			a.logger.Errorf("cannot restore cache: %s", err)
 			a.logger.Warn("continuing without cache")
 		} else {
 			a.logger.Infof("%s restored", o.logfile+".cache")
-			cacheResults, err := sortResults(res.Data, o.order, o.dec)
-			if err != nil {
-				a.logger.Errorf("cannot sort results: %s", err)
-				o.order = "random"
-				cacheResults, err = sortResults(cacheResults, o.order, o.dec)
-				if err != nil {
-					a.logger.Fatalf("cannot sort results: %s", err)
-				}
-			}
 			a.logger.Infof("cache has timestamp: %s", res.Date)
-			stats, err := computeStats(cacheResults, res.TotalDuration)
-			if err != nil {
-				a.logger.Errorf("cannot compute statistics: %s. This can lead to inacurrate stats", err)
-			}
-			stats, err = sortResults(stats, o.order, o.dec)
-			if err != nil {
-				a.logger.Errorf("cannot sort results: %s", err)
+			// percentiles are computed again, since they can differ from the
+			// ones of the cache
+			res.Data = digest.Compute(res.Data, res.TotalDuration, o.percentileValues)
+			if err := digest.Sort(res.Data, o.order, o.dec); err != nil {
+				a.logger.Errorf("cannot sort results: %s, using 'random'", err)
 				o.order = "random"
-				stats, err = sortResults(stats, o.order, o.dec)
-				if err != nil {
-					a.logger.Fatalf("cannot sort results: %s", err)
-				}
 			}
-			showResults(stats, res.ServerMeta, o.order, o.top, o.dec)
-			return
+			return res, a.logger
 		}
 		a.logger.Info("cache will not be used")
 	}
 
-	a.fd, err = os.Open(o.logfile)
+	fd, err := os.Open(o.logfile)
 	if err != nil {
 		a.logger.Fatalf("cannot open log file: %s", err)
 	}
+	defer fd.Close()
+	a.fd = fd
 	a.logger.Debugf("%s successfully opened", o.logfile)
 
+	// skip the queries logged before the window without reading them
+	if !o.sinceTime.IsZero() {
+		a.fd, err = slowql.Seek(a.kind, fd, o.sinceTime)
+		if err != nil {
+			a.logger.Fatalf("cannot seek to %s: %s", formatTime(o.sinceTime), err)
+		}
+	}
+	// the lines of a log read from the middle are not the ones of the file
+	seeked := a.fd != io.Reader(fd)
+
 	// no need to compute stuff if it will not be displayed
 	if a.logger.Level >= logrus.InfoLevel {
 		fd, err := os.Open(o.logfile)
@@ -169,161 +241,125 @@ func main() {
 	}
 
 	var q query.Query
-	var wg sync.WaitGroup
 	var realStart, realEnd time.Time
 	firstPass := true
-	a.p = slowql.NewParser(a.kind, a.fd)
+	// logs exported from AWS CloudWatch are unwrapped, the other ones are
+	// read unchanged
+	cw := slowql.NewCloudWatchReader(a.kind, a.fd)
+	a.p = slowql.NewParser(a.kind, cw)
 	a.logger.Debug("slowql parser created")
 	a.logger.Debug("query analysis started")
 	start := time.Now()
+	// the queue is bounded, so that parsing does not get too far ahead of the
+	// workers
+	queries := make(chan query.Query, queueSize*a.workers)
+	done := make(chan error)
+	go func() {
+		done <- a.digestAll(queries)
+	}()
 	for {
 		q = a.p.GetNext()
-		if q == (query.Query{}) {
+		if q.IsZero() {
 			a.logger.Debug("no more queries, breaking for loop")
 			break
 		}
+		in, past := slowql.InWindow(q.Time, o.sinceTime, o.untilTime)
+		if past {
+			a.logger.Debug("past the time window, breaking for loop")
+			break
+		}
+		if !in {
+			continue
+		}
 		if firstPass {
 			realStart = q.Time
 			firstPass = false
 		}
 		realEnd = q.Time
+		if a.filter != nil && !a.filter.Match(q) {
+			continue
+		}
+		// neither are the lines of an unwrapped CloudWatch export
+		if seeked || cw.Unwrapped() {
+			q.Line = 0
+		}
 		a.queriesNumber++
-		wg.Add(1)
-		go a.digest(q, &wg)
+		queries <- q
+	}
+	close(queries)
+	if err := <-done; err != nil {
+		a.logger.Fatalf("cannot merge results: %s", err)
 	}
-	wg.Wait()
 	a.digestDuration = time.Since(start)
 
 	a.logger.Infof("digest duration: %s", a.digestDuration)
 	a.logger.Infof("parsed %d queries", a.queriesNumber)
-	a.logger.Infof("found %d different queries hashs", len(a.res))
+	res := a.agg.Entries()
+	a.logger.Infof("found %d different queries hashs", len(res))
 
 	srv := a.p.GetServerMeta()
 	srvMeta := getMeta(srv)
 	srvMeta.Duration = a.digestDuration
-
-	var res []statistics
-	for _, val := range a.res {
-		res = append(res, val)
-		srvMeta.Bytes += val.CumBytesSent
-	}
+	// the totals count each query once, whatever the grouping
+	srvMeta.Bytes = a.agg.Totals().CumBytesSent
 
 	realDuration := realEnd.Sub(realStart)
 	srvMeta.RealDuration = realDuration
 
-	res, err = computeStats(res, realDuration)
-	if err != nil {
-		a.logger.Errorf("cannot compute statistics: %s. This can lead to inacurrate stats", err)
+	if a.agg.Bucket() != o.bucket {
+		a.logger.Infof("bucket widened to %s to keep at most %d intervals", a.agg.Bucket(), digest.MaxBuckets)
 	}
 
-	res, err = sortResults(res, o.order, o.dec)
-	if err != nil {
-		a.logger.Errorf("cannot sort results: %s", err)
+	res = digest.Compute(res, realDuration, o.percentileValues)
+	if err := digest.Sort(res, o.order, o.dec); err != nil {
+		a.logger.Errorf("cannot sort results: %s, using 'random'", err)
 		o.order = "random"
-		res, err = sortResults(res, o.order, o.dec)
-		if err != nil {
-			a.logger.Fatalf("cannot sort results: %s", err)
-		}
 	}
 
-	showResults(res, srvMeta, o.order, o.top, o.dec)
+	cache := results{
+		File:          o.logfile,
+		Date:          time.Now(),
+		TotalDuration: realDuration,
+		GroupBy:       o.groupBy,
+		Filter:        o.filter,
+		Since:         o.sinceTime,
+		Until:         o.untilTime,
+		Samples:       o.samples,
+		Bucket:        o.bucket,
+		Width:         a.agg.Bucket(),
+		Buckets:       a.agg.Buckets(),
+		Data:          res,
+		Load:          a.agg.Load(),
+		Totals:        a.agg.Totals(),
+		Timeline:      a.agg.Timeline(),
+		ServerMeta:    srvMeta,
+	}
 	if !o.nocache {
 		a.logger.Info("saving results in cache file")
-		cache := results{
-			File:          o.logfile,
-			Date:          time.Now(),
-			TotalDuration: realDuration,
-			Data:          res,
-			ServerMeta:    srvMeta,
-		}
 		if err := saveCache(cache); err != nil {
 			a.logger.Errorf("cannot save results in cache file: %s", err)
 		}
 	}
-	a.logger.Debug("end of program, exiting")
+	return cache, a.logger
 }
 
-func showResults(res []statistics, sm serverMeta, order string, count int, dec bool) {
-	howTo := "increasing"
-	if dec {
-		howTo = "decreasing"
-	}
-
-	// show server's meta
-	fmt.Printf(`
-=-= Server meta =-=
-	
-Binary              : %s
-Port                : %d
-Socket              : %s
-Version             : %s
-Version short       : %s
-Version description : %s
-
-Digest duration     : %s
-Real duration       : %s
-
-Bytes handled       : %d
-	`,
-		sm.Binary,
-		sm.Port,
-		sm.Socket,
-		sm.Version,
-		sm.VersionShort,
-		sm.VersionDescription,
-		sm.Duration,
-		sm.RealDuration,
-		sm.Bytes)
-
-	// show queries stats
-	fmt.Printf("\n=-= Queries stats =-=\n")
-	fmt.Printf("\nSorted by: %s, %s\n", ar.Bold(order), ar.Bold(howTo))
-	fmt.Printf("Showing top %d queries\n", ar.Bold(count))
-	for i := 0; i < len(res); i++ {
-		if count == 0 {
-			break
-		}
-
-		fmt.Printf(`
-%s%d
-Calls                  : %d
-Hash                   : %s
-Fingerprint            : %s
-Schema                 : %s
-Min/Max/Mean time      : %s/%s/%s
-p50/p95                : %s/%s
-Concurrency            : %2.4f%%
-Standard deviation     : %s
-Cum Query Time         : %s
-Cum Lock Time          : %s
-Cum Bytes sent         : %d
-Cum Rows Examined/Sent : %d/%d
-Cum Killed             : %d
-			`,
-			ar.Bold(ar.Underline("Query #")),
-			ar.Bold(ar.Underline(i+1)),
-			res[i].Calls,
-			res[i].Hash,
-			res[i].Fingerprint,
-			res[i].Schema,
-			fsecsToDuration(res[i].MinTime),
-			fsecsToDuration(res[i].MaxTime),
-			fsecsToDuration(res[i].MeanTime),
-			fsecsToDuration(res[i].P50Time),
-			fsecsToDuration(res[i].P95Time),
-			res[i].Concurrency,
-			fsecsToDuration(res[i].StddevTime),
-			fsecsToDuration(res[i].CumQueryTime),
-			fsecsToDuration(res[i].CumLockTime),
-			res[i].CumBytesSent,
-			res[i].CumRowsExamined,
-			res[i].CumRowsSent,
-			res[i].CumKilled,
-		)
-
-		count--
+// newReport returns the report of the results, as set by the options
+func newReport(res results, o options) report {
+	return report{
+		meta:        res.ServerMeta,
+		totals:      res.Totals,
+		load:        res.Load,
+		timeline:    res.Timeline,
+		stats:       res.Data,
+		groupBy:     res.GroupBy,
+		order:       o.order,
+		dec:         o.dec,
+		top:         o.top,
+		percentiles: o.percentileValues,
+		metrics:     o.metricNames,
+		bucket:      res.Width,
+		buckets:     res.Buckets,
 	}
-	fmt.Println()
 }
 
 func lineCounter(r io.Reader) (int, error) {
@@ -343,74 +379,6 @@ func lineCounter(r io.Reader) (int, error) {
 	}
 }
 
-func sortResults(s []statistics, order string, dec bool) ([]statistics, error) {
-	switch order {
-	case "random":
-		break
-	case "calls":
-		sort.SliceStable(s, func(i, j int) bool {
-			return s[i].Calls < s[j].Calls
-		})
-	case "bytes_sent":
-		sort.SliceStable(s, func(i, j int) bool {
-			return s[i].CumBytesSent < s[j].CumBytesSent
-		})
-	case "query_time":
-		sort.SliceStable(s, func(i, j int) bool {
-			return s[i].CumQueryTime < s[j].CumQueryTime
-		})
-	case "lock_time":
-		sort.SliceStable(s, func(i, j int) bool {
-			return s[i].CumLockTime < s[j].CumLockTime
-		})
-	case "rows_sent":
-		sort.SliceStable(s, func(i, j int) bool {
-			return s[i].CumRowsSent < s[j].CumRowsSent
-		})
-	case "rows_examined":
-		sort.SliceStable(s, func(i, j int) bool {
-			return s[i].CumRowsExamined < s[j].CumRowsExamined
-		})
-	case "killed":
-		sort.SliceStable(s, func(i, j int) bool {
-			return s[i].CumKilled < s[j].CumKilled
-		})
-	case "min_time":
-		sort.SliceStable(s, func(i, j int) bool {
-			return s[i].MinTime < s[j].MinTime
-		})
-	case "max_time":
-		sort.SliceStable(s, func(i, j int) bool {
-			return s[i].MaxTime < s[j].MaxTime
-		})
-	case "mean_time":
-		sort.SliceStable(s, func(i, j int) bool {
-			return s[i].MeanTime < s[j].MeanTime
-		})
-	case "p50", "P50":
-		sort.SliceStable(s, func(i, j int) bool {
-			return s[i].P50Time < s[j].P50Time
-		})
-	case "p95", "P95":
-		sort.SliceStable(s, func(i, j int) bool {
-			return s[i].P95Time < s[j].P95Time
-		})
-	case "concurrency":
-		sort.SliceStable(s, func(i, j int) bool {
-			return s[i].Concurrency < s[j].Concurrency
-		})
-	default:
-		return nil, errors.New("unknown order, using 'random'")
-	}
-
-	if dec {
-		for i, j := 0, len(s)-1; i < j; i, j = i+1, j-1 {
-			s[i], s[j] = s[j], s[i]
-		}
-	}
-	return s, nil
-}
-
 func getMeta(srv server.Server) serverMeta {
 	var sm serverMeta
 	sm.Binary = srv.Binary
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/devops-works/slowql/digest"
+	"github.com/devops-works/slowql/query/structure"
+)
+
+// writeMarkdown writes the report as GitHub flavoured markdown, to be pasted
+// in tickets and pull requests: tables for the server meta, the load split and
+// the top entries, followed by the fingerprints in code blocks
+func writeMarkdown(w io.Writer, r report) error {
+	ew := &errWriter{w: w}
+
+	ew.printf("## Server meta\n\n")
+	ew.printf("| | |\n| --- | --- |\n")
+	for _, row := range [][2]string{
+		{"Binary", r.meta.Binary},
+		{"Port", fmt.Sprint(r.meta.Port)},
+		{"Socket", r.meta.Socket},
+		{"Version", r.meta.Version},
+		{"Version description", r.meta.VersionDescription},
+		{"Digest duration", r.meta.Duration.String()},
+		{"Real duration", r.meta.RealDuration.String()},
+		{"Calls", fmt.Sprint(r.totals.Calls)},
+		{"Cum Query Time", fsecsToDuration(r.totals.CumQueryTime).String()},
+		{"Bytes handled", fmt.Sprint(r.meta.Bytes)},
+	} {
+		ew.printf("| %s | %s |\n", row[0], markdownCell(row[1]))
+	}
+
+	if len(r.load) > 0 {
+		var total float64
+		for _, l := range r.load {
+			total += l.CumQueryTime
+		}
+		ew.printf("\n## Load split\n\n")
+		ew.printf("| Class | Calls | Query time | Share |\n| --- | ---: | ---: | ---: |\n")
+		for _, c := range structure.Classes {
+			l := r.load[c.String()]
+			share := 0.0
+			if total > 0 {
+				share = 100 * l.CumQueryTime / total
+			}
+			ew.printf("| %s | %d | %s | %.2f%% |\n", c, l.Calls, fsecsToDuration(l.CumQueryTime), share)
+		}
+	}
+
+	top := r.stats
+	if len(top) > r.top {
+		top = top[:r.top]
+	}
+	howTo := "increasing"
+	if r.dec {
+		howTo = "decreasing"
+	}
+	title, id := "Queries", "Hash"
+	if t := groupTitle(r.groupBy); t != "Query" {
+		title, id = t+"s", t
+	}
+	ew.printf("\n## %s\n\n", title)
+	ew.printf("Top %d, sorted by %s, %s.\n\n", len(top), r.order, howTo)
+	var pHeader, pAlign string
+	for _, p := range r.percentiles {
+		pHeader += " " + digest.PercentileName(p) + " |"
+		pAlign += " ---: |"
+	}
+	ew.printf("| # | %s | Schema | Calls | Cum Query Time | Min | Max | Mean |%s Concurrency | Rows examined/sent | Bytes sent |\n", id, pHeader)
+	ew.printf("| ---: | --- | --- | ---: | ---: | ---: | ---: | ---: |%s ---: | ---: | ---: |\n", pAlign)
+	for i, s := range top {
+		// fingerprints are too long for the table, they follow it
+		short := s
+		if short.Table == "" && short.Fingerprint != "" {
+			short.Fingerprint = s.Hash
+		}
+		name := "`" + entryName(short) + "`"
+		var pValues string
+		for _, p := range r.percentiles {
+			pValues += fmt.Sprintf(" %s |", fsecsToDuration(s.Percentiles[digest.PercentileName(p)]))
+		}
+		ew.printf("| %d | %s | %s | %d | %s | %s | %s | %s |%s %.4f%% | %d/%d | %d |\n",
+			i+1, markdownCell(name), markdownCell(s.Schema), s.Calls,
+			fsecsToDuration(s.CumQueryTime), fsecsToDuration(s.MinTime), fsecsToDuration(s.MaxTime),
+			fsecsToDuration(s.MeanTime), pValues,
+			s.Concurrency, s.CumRowsExamined, s.CumRowsSent, s.CumBytesSent)
+	}
+
+	if len(r.metrics) > 0 {
+		ew.printf("\n## Distributions\n\n")
+		ew.printf("| # | Metric | Min | Max | Mean |%s\n", pHeader)
+		ew.printf("| ---: | --- | ---: | ---: | ---: |%s\n", pAlign)
+		for i, s := range top {
+			for _, m := range r.metrics {
+				d := s.Distributions[m]
+				var pValues string
+				for _, p := range r.percentiles {
+					pValues += " " + formatMetric(m, d.Percentiles[digest.PercentileName(p)]) + " |"
+				}
+				ew.printf("| %d | %s | %s | %s | %s |%s\n", i+1, metricTitles[m],
+					formatMetric(m, d.Min), formatMetric(m, d.Max), formatMetric(m, d.Mean), pValues)
+			}
+		}
+	}
+
+	for i, s := range top {
+		if s.Fingerprint == "" && s.Slowest.Query == "" {
+			continue
+		}
+		if s.Fingerprint != "" {
+			fence := markdownFence(s.Fingerprint)
+			ew.printf("\n### #%d `%s`\n\n%ssql\n%s\n%s\n", i+1, s.Hash, fence, s.Fingerprint, fence)
+		} else {
+			ew.printf("\n### #%d %s\n", i+1, markdownCell("`"+entryName(s)+"`"))
+		}
+		if len(s.Users) > 0 {
+			ew.printf("\nUsers: %s\n", formatShares(s.Users, s.CumQueryTime))
+		}
+		if len(s.Hosts) > 0 {
+			ew.printf("\nHosts: %s\n", formatShares(s.Hosts, s.CumQueryTime))
+		}
+		// caches created before queries were sampled have none
+		if s.Slowest.Query != "" {
+			samples := "-- slowest: " + formatSample(s.Slowest) + "\n" + s.Slowest.Query + "\n"
+			for _, sample := range s.Samples {
+				samples += "-- sample: " + formatSample(sample) + "\n" + sample.Query + "\n"
+			}
+			fence := markdownFence(samples)
+			ew.printf("\nSlowest and sampled queries:\n\n%ssql\n%s%s\n", fence, samples, fence)
+		}
+	}
+	return ew.err
+}
+
+// markdownCell escapes the pipes and line breaks of a table cell
+func markdownCell(s string) string {
+	s = strings.ReplaceAll(s, "|", "\\|")
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// markdownFence returns a code fence longer than any run of backticks of s
+func markdownFence(s string) string {
+	longest, run := 0, 0
+	for _, c := range s {
+		if c == '`' {
+			run++
+			if run > longest {
+				longest = run
+			}
+		} else {
+			run = 0
+		}
+	}
+	if longest < 3 {
+		return "```"
+	}
+	return strings.Repeat("`", longest+1)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func Test_writeMarkdown(t *testing.T) {
+	r := testReport()
+	r.stats[0].Schema = "a|b"
+
+	var b bytes.Buffer
+	if err := writeMarkdown(&b, r); err != nil {
+		t.Fatalf("writeMarkdown() error = %v", err)
+	}
+	for _, want := range []string{
+		"## Server meta\n\n| | |\n| --- | --- |\n| Binary | /usr/sbin/mysqld |\n",
+		"| read | 4 | 7s | 93.33% |\n",
+		"Top 1, sorted by query_time, decreasing.\n",
+		"| 1 | `4a1cb28f` | a\\|b | 4 | 7s | 1s | 3s | 1.75s | 1.5s | 3s | 0.1900% | 4000/40 | 2048 |\n",
+		"### #1 `4a1cb28f`\n\n```sql\nselect * from orders where id = ?\n```\n",
+	} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("writeMarkdown() does not contain %q:\n%s", want, b.String())
+		}
+	}
+	if strings.Contains(b.String(), "\x1b[") {
+		t.Errorf("writeMarkdown() has ANSI escape codes:\n%s", b.String())
+	}
+	if strings.Contains(b.String(), "delete from carts") {
+		t.Errorf("writeMarkdown() shows more than the top entries:\n%s", b.String())
+	}
+}
+
+func Test_markdownFence(t *testing.T) {
+	tests := []struct {
+		name string
+		s    string
+		want string
+	}{
+		{name: "no backticks", s: "select ?", want: "```"},
+		{name: "identifiers", s: "select `a` from `t`", want: "```"},
+		{name: "fence", s: "select '```'", want: "````"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := markdownFence(tt.s); got != tt.want {
+				t.Errorf("markdownFence() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,17 +1,89 @@
 package main
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/devops-works/slowql"
+	"github.com/devops-works/slowql/digest"
+)
 
 func (o *options) parse() []error {
 	var errs []error
+
+	o.percentileValues = nil
+	for _, s := range strings.Split(o.percentiles, ",") {
+		p, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+		if err != nil || p <= 0 || p > 100 {
+			errs = append(errs, fmt.Errorf("invalid percentile %q: must be between 0 and 100", s))
+			continue
+		}
+		o.percentileValues = append(o.percentileValues, p)
+	}
+
+	o.metricNames = nil
+	for _, m := range strings.Split(o.metrics, ",") {
+		m = strings.TrimSpace(m)
+		switch {
+		case m == "":
+		case m == "all":
+			o.metricNames = append([]string(nil), digest.Metrics...)
+		case stringInSlice(m, digest.Metrics):
+			if !stringInSlice(m, o.metricNames) {
+				o.metricNames = append(o.metricNames, m)
+			}
+		default:
+			errs = append(errs, errors.New("unknown metric: "+m))
+		}
+	}
+
+	// the cache is used whatever the order of the dimensions
+	if dimensions, err := digest.ParseGroupBy(o.groupBy); err == nil {
+		o.groupBy = strings.Join(dimensions, ",")
+	}
+
 	if o.logfile == "" {
 		errs = append(errs, errors.New("no slow query log file provided"))
 	} else if o.kind == "" {
 		errs = append(errs, errors.New("no database kind provided"))
 	} else if o.top <= 0 {
 		errs = append(errs, errors.New("top cannot be negative or equal to zero"))
-	} else if !stringInSlice(o.order, orders) {
+	} else if !digest.IsOrder(o.order, o.percentileValues) {
 		errs = append(errs, errors.New("unknown order"))
+	} else if _, err := digest.ParseGroupBy(o.groupBy); err != nil {
+		errs = append(errs, err)
+	} else if !stringInSlice(o.output, outputs) {
+		errs = append(errs, errors.New("unknown output format: "+o.output))
+	} else if o.workers < 0 {
+		errs = append(errs, errors.New("workers cannot be negative"))
+	} else if o.samples < 0 {
+		errs = append(errs, errors.New("samples cannot be negative"))
+	} else if o.bucket < 0 || o.bucket%time.Second != 0 {
+		errs = append(errs, errors.New("bucket must be a whole number of seconds"))
+	} else if strings.HasSuffix(o.output, "-series") && o.bucket == 0 {
+		errs = append(errs, errors.New(o.output+" requires -bucket"))
+	} else if o.threshold < 0 {
+		errs = append(errs, errors.New("threshold cannot be negative"))
+	}
+
+	// relative times are relative to the start of the program
+	now := time.Now()
+	var err error
+	if o.since != "" {
+		if o.sinceTime, err = slowql.ParseTime(o.since, now); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if o.until != "" {
+		if o.untilTime, err = slowql.ParseTime(o.until, now); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if !o.sinceTime.IsZero() && !o.untilTime.IsZero() && o.untilTime.Before(o.sinceTime) {
+		errs = append(errs, errors.New("until cannot be before since"))
 	}
 
 	return errs
@@ -25,3 +97,12 @@ func stringInSlice(s string, sl []string) bool {
 	}
 	return false
 }
+
+func floatInSlice(f float64, sl []float64) bool {
+	for _, v := range sl {
+		if f == v {
+			return true
+		}
+	}
+	return false
+}
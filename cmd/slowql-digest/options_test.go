@@ -2,43 +2,88 @@ package main
 
 import (
 	"testing"
+	"time"
 )
 
 func Test_options_parse(t *testing.T) {
 	type fields struct {
-		logfile  string
-		loglevel string
-		kind     string
-		top      int
-		order    string
-		dec      bool
-		nocache  bool
+		logfile     string
+		loglevel    string
+		kind        string
+		top         int
+		order       string
+		dec         bool
+		nocache     bool
+		groupBy     string
+		since       string
+		until       string
+		output      string
+		percentiles string
+		metrics     string
+		workers     int
+		bucket      time.Duration
+		threshold   float64
 	}
 	tests := []struct {
 		name    string
 		fields  fields
 		wantErr bool
 	}{
-		{name: "working", fields: fields{logfile: "file", kind: "mysql", top: 1337, order: "random"}, wantErr: false},
+		{name: "working", fields: fields{logfile: "file", kind: "mysql", top: 1337, order: "random", groupBy: "fingerprint", output: "text", percentiles: "50,95"}, wantErr: false},
+		{name: "group by table", fields: fields{logfile: "file", kind: "mysql", top: 1337, order: "random", groupBy: "table", output: "text", percentiles: "50,95"}, wantErr: false},
 		{name: "no logfile", fields: fields{kind: "mysql", top: 1337, order: "random"}, wantErr: true},
 		{name: "no kind", fields: fields{logfile: "file", top: 1337, order: "random"}, wantErr: true},
 		{name: "incorrect top", fields: fields{logfile: "file", kind: "mysql", top: -1000, order: "random"}, wantErr: true},
 		{name: "incorrect order", fields: fields{logfile: "file", kind: "mysql", top: -1000, order: "incorrect"}, wantErr: true},
+		{name: "group by several dimensions", fields: fields{logfile: "file", kind: "mysql", top: 1337, order: "random", groupBy: "user,fingerprint", output: "text", percentiles: "50,95"}, wantErr: false},
+		{name: "incorrect group by", fields: fields{logfile: "file", kind: "mysql", top: 1337, order: "random", groupBy: "column"}, wantErr: true},
+		{name: "time window", fields: fields{logfile: "file", kind: "mysql", top: 1337, order: "random", groupBy: "fingerprint", output: "text", since: "-2h", until: "2099-01-01", percentiles: "50,95"}, wantErr: false},
+		{name: "json output", fields: fields{logfile: "file", kind: "mysql", top: 1337, order: "random", groupBy: "fingerprint", output: "json", percentiles: "50,95"}, wantErr: false},
+		{name: "incorrect output", fields: fields{logfile: "file", kind: "mysql", top: 1337, order: "random", groupBy: "fingerprint", output: "xml"}, wantErr: true},
+		{name: "incorrect since", fields: fields{logfile: "file", kind: "mysql", top: 1337, order: "random", groupBy: "fingerprint", output: "text", since: "yesterday"}, wantErr: true},
+		{name: "sort by percentile", fields: fields{logfile: "file", kind: "mysql", top: 1337, order: "p99.9", groupBy: "fingerprint", output: "text", percentiles: "50, 99.9"}, wantErr: false},
+		{name: "sort by missing percentile", fields: fields{logfile: "file", kind: "mysql", top: 1337, order: "p99", groupBy: "fingerprint", output: "text", percentiles: "50,95"}, wantErr: true},
+		{name: "incorrect percentile", fields: fields{logfile: "file", kind: "mysql", top: 1337, order: "random", groupBy: "fingerprint", output: "text", percentiles: "50,101"}, wantErr: true},
+		{name: "metrics", fields: fields{logfile: "file", kind: "mysql", top: 1337, order: "random", groupBy: "fingerprint", output: "text", percentiles: "50,95", metrics: "lock_time, rows_examined"}, wantErr: false},
+		{name: "all metrics", fields: fields{logfile: "file", kind: "mysql", top: 1337, order: "random", groupBy: "fingerprint", output: "text", percentiles: "50,95", metrics: "all"}, wantErr: false},
+		{name: "incorrect metric", fields: fields{logfile: "file", kind: "mysql", top: 1337, order: "random", groupBy: "fingerprint", output: "text", percentiles: "50,95", metrics: "cpu_time"}, wantErr: true},
+		{name: "sort by metric percentile", fields: fields{logfile: "file", kind: "mysql", top: 1337, order: "rows_examined_p95", groupBy: "fingerprint", output: "text", percentiles: "50,95"}, wantErr: false},
+		{name: "sort by metric max", fields: fields{logfile: "file", kind: "mysql", top: 1337, order: "lock_time_max", groupBy: "fingerprint", output: "text", percentiles: "50,95"}, wantErr: false},
+		{name: "sort by missing metric percentile", fields: fields{logfile: "file", kind: "mysql", top: 1337, order: "rows_examined_p99", groupBy: "fingerprint", output: "text", percentiles: "50,95"}, wantErr: true},
+		{name: "sort by unknown metric statistic", fields: fields{logfile: "file", kind: "mysql", top: 1337, order: "bytes_sent_median", groupBy: "fingerprint", output: "text", percentiles: "50,95"}, wantErr: true},
+		{name: "workers", fields: fields{logfile: "file", kind: "mysql", top: 1337, order: "random", groupBy: "fingerprint", output: "text", percentiles: "50,95", workers: 4}, wantErr: false},
+		{name: "negative workers", fields: fields{logfile: "file", kind: "mysql", top: 1337, order: "random", groupBy: "fingerprint", output: "text", percentiles: "50,95", workers: -1}, wantErr: true},
+		{name: "bucket", fields: fields{logfile: "file", kind: "mysql", top: 1337, order: "random", groupBy: "fingerprint", output: "text", percentiles: "50,95", bucket: time.Minute}, wantErr: false},
+		{name: "series", fields: fields{logfile: "file", kind: "mysql", top: 1337, order: "random", groupBy: "fingerprint", output: "csv-series", percentiles: "50,95", bucket: time.Minute}, wantErr: false},
+		{name: "series without bucket", fields: fields{logfile: "file", kind: "mysql", top: 1337, order: "random", groupBy: "fingerprint", output: "tsv-series", percentiles: "50,95"}, wantErr: true},
+		{name: "negative bucket", fields: fields{logfile: "file", kind: "mysql", top: 1337, order: "random", groupBy: "fingerprint", output: "text", percentiles: "50,95", bucket: -time.Minute}, wantErr: true},
+		{name: "bucket below a second", fields: fields{logfile: "file", kind: "mysql", top: 1337, order: "random", groupBy: "fingerprint", output: "text", percentiles: "50,95", bucket: 1500 * time.Millisecond}, wantErr: true},
+		{name: "negative threshold", fields: fields{logfile: "file", kind: "mysql", top: 1337, order: "random", groupBy: "fingerprint", output: "text", percentiles: "50,95", threshold: -10}, wantErr: true},
+		{name: "until before since", fields: fields{logfile: "file", kind: "mysql", top: 1337, order: "random", groupBy: "fingerprint", output: "text", since: "-1h", until: "-2h"}, wantErr: true},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			o := &options{
-				logfile:  tt.fields.logfile,
-				loglevel: tt.fields.loglevel,
-				kind:     tt.fields.kind,
-				top:      tt.fields.top,
-				order:    tt.fields.order,
-				dec:      tt.fields.dec,
-				nocache:  tt.fields.nocache,
+				logfile:     tt.fields.logfile,
+				loglevel:    tt.fields.loglevel,
+				kind:        tt.fields.kind,
+				top:         tt.fields.top,
+				order:       tt.fields.order,
+				dec:         tt.fields.dec,
+				nocache:     tt.fields.nocache,
+				groupBy:     tt.fields.groupBy,
+				since:       tt.fields.since,
+				until:       tt.fields.until,
+				output:      tt.fields.output,
+				percentiles: tt.fields.percentiles,
+				metrics:     tt.fields.metrics,
+				workers:     tt.fields.workers,
+				bucket:      tt.fields.bucket,
+				threshold:   tt.fields.threshold,
 			}
 			got := o.parse()
 
-			if len(got) > 0 && tt.wantErr == false {
+			if (len(got) > 0) != tt.wantErr {
 				t.Errorf("options.parse() = %v, want %v", got, tt.wantErr)
 			}
 		})
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/devops-works/slowql/digest"
+)
+
+// outputs lists the available output formats
+var outputs = []string{"csv", "csv-series", "html", "json", "markdown", "pt", "text", "tsv", "tsv-series"}
+
+// report holds everything an output format can show
+type report struct {
+	meta   serverMeta
+	totals digest.Totals
+	load   map[string]digest.Load
+	// timeline is the load of each minute, by Unix time
+	timeline map[int64]digest.Load
+	stats    []digest.Entry
+	groupBy  string
+	order    string
+	dec      bool
+	top      int
+	// percentiles are the percentiles to show
+	percentiles []float64
+	// metrics are the metrics whose distribution is shown, besides the query
+	// time
+	metrics []string
+	// bucket is the width of the intervals of the series, or 0 if there are
+	// none
+	bucket time.Duration
+	// buckets are the queries of each interval, by Unix time of its start
+	buckets map[int64]digest.Bucket
+}
+
+// writeReport writes the report to w in the given format
+func writeReport(w io.Writer, format string, r report) error {
+	switch format {
+	case "text":
+		return writeText(w, r)
+	case "json":
+		return writeJSON(w, r)
+	case "csv":
+		return writeCSV(w, r, ',')
+	case "tsv":
+		return writeCSV(w, r, '\t')
+	case "csv-series":
+		return writeCSVSeries(w, r, ',')
+	case "tsv-series":
+		return writeCSVSeries(w, r, '\t')
+	case "html":
+		return writeHTML(w, r)
+	case "pt":
+		return writePT(w, r)
+	case "markdown":
+		return writeMarkdown(w, r)
+	}
+	return errors.New("unknown output format: " + format)
+}
+
+// errWriter writes formatted text, and keeps the first error so that it is
+// checked once
+type errWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (ew *errWriter) printf(format string, a ...interface{}) {
+	if ew.err != nil {
+		return
+	}
+	_, ew.err = fmt.Fprintf(ew.w, format, a...)
+}
@@ -0,0 +1,440 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/devops-works/slowql/digest"
+	"github.com/devops-works/slowql/query"
+	"github.com/devops-works/slowql/sketch"
+)
+
+// testSketch returns a sketch of the given query times
+func testSketch(times ...float64) *sketch.Sketch {
+	s := sketch.New()
+	for _, t := range times {
+		s.Add(t)
+	}
+	return s
+}
+
+// testReport returns a report of two fingerprints, showing the top one. The
+// first one has the distributions of the other metrics
+func testReport() report {
+	r := report{
+		meta: serverMeta{
+			Binary:       "/usr/sbin/mysqld",
+			Port:         3306,
+			Version:      "8.0.26",
+			Duration:     1500 * time.Millisecond,
+			RealDuration: time.Hour,
+			Bytes:        3072,
+		},
+		totals: digest.Totals{Calls: 5, CumQueryTime: 7.5, CumLockTime: 0.01, CumRowsSent: 50, CumRowsExamined: 5000, CumBytesSent: 3072},
+		load: map[string]digest.Load{
+			"read":  {Calls: 4, CumQueryTime: 7},
+			"write": {Calls: 1, CumQueryTime: 0.5},
+		},
+		stats: []digest.Entry{
+			{Hash: "4a1cb28f", Fingerprint: "select * from orders where id = ?", Schema: "shop", Calls: 4,
+				CumQueryTime: 7, CumLockTime: 0.008, CumRowsSent: 40, CumRowsExamined: 4000, CumBytesSent: 2048,
+				MinTime: 1, MaxTime: 3, MeanTime: 1.75, StddevTime: 0.8, Concurrency: 0.19,
+				Percentiles: map[string]float64{"p50": 1.5, "p95": 3}, Sketch: testSketch(1.2, 1.5, 1.5, 3),
+				Slowest: digest.Sample{Query: "SELECT * FROM orders WHERE id = 3", Time: time.Date(2021, 3, 23, 0, 30, 0, 0, time.UTC),
+					QueryTime: 3, User: "app", Host: "10.0.0.1", Schema: "shop", Line: 120},
+				Samples: []digest.Sample{{Query: "SELECT * FROM orders\nWHERE id = 1", QueryTime: 1.2, Line: 12}}},
+			{Hash: "9e0d7c11", Fingerprint: "delete from carts where updated_at < ?", Schema: "shop", Calls: 1,
+				CumQueryTime: 0.5, CumLockTime: 0.002, CumRowsSent: 10, CumRowsExamined: 1000, CumBytesSent: 1024,
+				MinTime: 0.5, MaxTime: 0.5, MeanTime: 0.5, Concurrency: 0.01,
+				Percentiles: map[string]float64{"p50": 0.5, "p95": 0.5}, Sketch: testSketch(0.5)},
+		},
+		groupBy:     "fingerprint",
+		order:       "query_time",
+		dec:         true,
+		top:         1,
+		percentiles: []float64{50, 95},
+	}
+	r.stats[0].Sketches = map[string]*sketch.Sketch{
+		"lock_time":     testSketch(0.001, 0.002, 0.002, 0.003),
+		"rows_sent":     testSketch(10, 10, 10, 10),
+		"rows_examined": testSketch(500, 1000, 1000, 1500),
+		"bytes_sent":    testSketch(512, 512, 512, 512),
+	}
+	computed := digest.Compute([]digest.Entry{r.stats[0]}, time.Hour, r.percentiles)
+	r.stats[0].Distributions = computed[0].Distributions
+	return r
+}
+
+func Test_writeReport(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  string
+		want    []string
+		wantErr bool
+	}{
+		{name: "text", format: "text", want: []string{"=-= Server meta =-=", "Query #", "select * from orders where id = ?"}},
+		{name: "json", format: "json", want: []string{`"version": 1`, `"select * from orders where id = ?"`}},
+		{name: "csv", format: "csv", want: []string{"rank,hash,fingerprint", "1,4a1cb28f,select * from orders where id = ?"}},
+		{name: "tsv", format: "tsv", want: []string{"rank\thash\tfingerprint", "1\t4a1cb28f\tselect * from orders where id = ?"}},
+		{name: "markdown", format: "markdown", want: []string{"## Server meta", "```sql\nselect * from orders where id = ?\n```"}},
+		{name: "pt", format: "pt", want: []string{"# Profile", "SELECT orders"}},
+		{name: "unknown", format: "xml", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b bytes.Buffer
+			err := writeReport(&b, tt.format, testReport())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("writeReport() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(b.String(), want) {
+					t.Errorf("writeReport() does not contain %q:\n%s", want, b.String())
+				}
+			}
+		})
+	}
+}
+
+func Test_writeReport_metrics(t *testing.T) {
+	tests := []struct {
+		format string
+		want   []string
+	}{
+		{format: "text", want: []string{"Rows examined          : 500/1500/1000 min/max/mean, 1002/1002 p50/p95\n"}},
+		{format: "json", want: []string{`"min": 500,`, `"max": 1500,`, `"p95": 1002`}},
+		{format: "csv", want: []string{",rows_examined_min,rows_examined_max,rows_examined_mean,rows_examined_p50,rows_examined_p95\n",
+			",500,1500,1000,1002,1002\n"}},
+		{format: "markdown", want: []string{"## Distributions", "| 1 | Rows examined | 500 | 1500 | 1000 | 1002 | 1002 |\n"}},
+		{format: "html", want: []string{"<tr><td>Rows examined</td><td class=\"num\">500</td><td class=\"num\">1500</td><td class=\"num\">1000</td><td class=\"num\">1002</td><td class=\"num\">1002</td></tr>"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			r := testReport()
+			r.metrics = []string{"rows_examined"}
+			var b bytes.Buffer
+			if err := writeReport(&b, tt.format, r); err != nil {
+				t.Fatalf("writeReport() error = %v", err)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(b.String(), want) {
+					t.Errorf("writeReport() does not contain %q:\n%s", want, b.String())
+				}
+			}
+			if strings.Contains(b.String(), "Rows sent          ") || strings.Contains(b.String(), "rows_sent_min") {
+				t.Errorf("writeReport() shows a metric that is not selected:\n%s", b.String())
+			}
+		})
+	}
+}
+
+func Test_writeReport_groupBy(t *testing.T) {
+	tests := []struct {
+		format string
+		want   []string
+	}{
+		{format: "text", want: []string{"Query #", "User                   : app\n",
+			"Hosts                  : 10.0.0.1 85.71% (3 calls), 10.0.0.2 14.29% (1 calls)\n"}},
+		{format: "json", want: []string{`"user": "app",`, `"name": "10.0.0.1",`}},
+		{format: "csv", want: []string{",table,user,host,schema,", ",,app,,shop,"}},
+		{format: "markdown", want: []string{"| 1 | `4a1cb28f (user app)` |", "Hosts: 10.0.0.1 85.71% (3 calls), 10.0.0.2 14.29% (1 calls)\n"}},
+		{format: "html", want: []string{"<code>select * from orders where id = ? (user app)</code>", "<p>Hosts: 10.0.0.1 85.71% (3 calls)"}},
+		{format: "pt", want: []string{"# Hosts        10.0.0.1 (3/75%), 10.0.0.2 (1/25%)\n", "# Users        app\n"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			r := testReport()
+			r.groupBy = "fingerprint,user"
+			r.stats[0].User = "app"
+			r.stats[0].Hosts = map[string]digest.Load{
+				"10.0.0.1": {Calls: 3, CumQueryTime: 6},
+				"10.0.0.2": {Calls: 1, CumQueryTime: 1},
+			}
+			var b bytes.Buffer
+			if err := writeReport(&b, tt.format, r); err != nil {
+				t.Fatalf("writeReport() error = %v", err)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(b.String(), want) {
+					t.Errorf("writeReport() does not contain %q:\n%s", want, b.String())
+				}
+			}
+		})
+	}
+}
+
+func Test_writeReport_samples(t *testing.T) {
+	tests := []struct {
+		format string
+		want   []string
+	}{
+		{format: "text", want: []string{"Slowest                : 3s, 2021-03-23T00:30:00Z, app@10.0.0.1, shop, line 120\n    SELECT * FROM orders WHERE id = 3\n",
+			"Sample #1              : 1.2s, line 12\n    SELECT * FROM orders WHERE id = 1\n"}},
+		{format: "json", want: []string{`"slowest": {`, `"time": "2021-03-23T00:30:00Z",`, `"line": 120`, `"line": 12`}},
+		{format: "markdown", want: []string{"```sql\n-- slowest: 3s, 2021-03-23T00:30:00Z, app@10.0.0.1, shop, line 120\nSELECT * FROM orders WHERE id = 3\n" +
+			"-- sample: 1.2s, line 12\nSELECT * FROM orders\nWHERE id = 1\n```\n"}},
+		{format: "html", want: []string{"<summary>Slowest query: 3s, 2021-03-23T00:30:00Z, app@10.0.0.1, shop, line 120</summary>",
+			"<p>1.2s, line 12</p>\n<pre>SELECT * FROM orders\nWHERE id = 1</pre>"}},
+		{format: "pt", want: []string{"# Slowest: 3s, 2021-03-23T00:30:00Z, app@10.0.0.1, shop, line 120\nSELECT * FROM orders WHERE id = 3\\G\n" +
+			"# Sample #1: 1.2s, line 12\nSELECT * FROM orders\nWHERE id = 1\\G\n"}},
+		{format: "csv", want: []string{",slowest_line,sample1_query,sample1_time,sample1_query_time,sample1_user,sample1_host,sample1_schema,sample1_line\n",
+			",120,\"SELECT * FROM orders\nWHERE id = 1\",,1.2,,,,12\n"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			var b bytes.Buffer
+			if err := writeReport(&b, tt.format, testReport()); err != nil {
+				t.Fatalf("writeReport() error = %v", err)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(b.String(), want) {
+					t.Errorf("writeReport() does not contain %q:\n%s", want, b.String())
+				}
+			}
+		})
+	}
+}
+
+func Test_writeReport_series(t *testing.T) {
+	start := time.Date(2021, 3, 23, 0, 0, 0, 0, time.UTC)
+	r := testReport()
+	r.bucket = time.Minute
+	r.buckets = map[int64]digest.Bucket{
+		start.Unix():                      {Calls: 2, CumQueryTime: 2, Sketch: testSketch(1, 1)},
+		start.Add(2 * time.Minute).Unix(): {Calls: 3, CumQueryTime: 6, Sketch: testSketch(2, 2, 2)},
+	}
+	r.stats[0].Buckets = map[int64]digest.Bucket{
+		start.Unix():                      {Calls: 1, CumQueryTime: 1.5, Sketch: testSketch(1.5)},
+		start.Add(2 * time.Minute).Unix(): {Calls: 3, CumQueryTime: 6, Sketch: testSketch(2, 2, 2)},
+	}
+
+	tests := []struct {
+		format string
+		want   []string
+	}{
+		{format: "text", want: []string{"From 2021-03-23T00:00:00Z to 2021-03-23T00:03:00Z\n",
+			"Calls per 1m           : ▆ █ (max 3)\n", "Query time per 1m      : ▃ █ (max 6s)\n",
+			"p95 per 1m             : ▄ █ (max 2s)\n", "Query time per 1m      : ▂ █ (max 6s)\n",
+			"Calls per 1m           : ▃ █ (max 3)\n"}},
+		{format: "json", want: []string{`"bucket": 60,`, `"series_percentile": "p95",`,
+			`{
+      "start": "2021-03-23T00:01:00Z",
+      "calls": 0,
+      "query_time": 0,
+      "percentile": 0
+    },`,
+			`"series": [
+        {
+          "start": "2021-03-23T00:00:00Z",
+          "calls": 1,
+          "query_time": 1.5,`}},
+		{format: "csv", want: []string{"rank,hash,fingerprint,", "\n1,4a1cb28f,"}},
+		{format: "csv-series", want: []string{"rank,hash,start,calls,cum_query_time,p95_time\n" +
+			"0,,2021-03-23T00:00:00Z,2,2,1\n0,,2021-03-23T00:01:00Z,0,0,0\n0,,2021-03-23T00:02:00Z,3,6,2\n" +
+			"1,4a1cb28f,2021-03-23T00:00:00Z,1,1.5,1.5\n1,4a1cb28f,2021-03-23T00:01:00Z,0,0,0\n1,4a1cb28f,2021-03-23T00:02:00Z,3,6,2\n"}},
+		{format: "tsv-series", want: []string{"rank\thash\tstart\tcalls\tcum_query_time\tp95_time\n0\t\t2021-03-23T00:00:00Z\t2\t2\t1\n"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			var b bytes.Buffer
+			if err := writeReport(&b, tt.format, r); err != nil {
+				t.Fatalf("writeReport() error = %v", err)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(b.String(), want) {
+					t.Errorf("writeReport() does not contain %q:\n%s", want, b.String())
+				}
+			}
+		})
+	}
+}
+
+func Test_writeJSON(t *testing.T) {
+	var b bytes.Buffer
+	if err := writeJSON(&b, testReport()); err != nil {
+		t.Fatalf("writeJSON() error = %v", err)
+	}
+	if strings.Contains(b.String(), "QueryTimes") || strings.Contains(b.String(), "query_times") {
+		t.Errorf("writeJSON() holds the query times:\n%s", b.String())
+	}
+
+	var doc jsonReport
+	if err := json.Unmarshal(b.Bytes(), &doc); err != nil {
+		t.Fatalf("cannot decode document: %s", err)
+	}
+	if doc.Version != jsonVersion {
+		t.Errorf("writeJSON() version = %d, want %d", doc.Version, jsonVersion)
+	}
+	if doc.Server.RealDuration != 3600 || doc.Server.DigestDuration != 1.5 {
+		t.Errorf("writeJSON() durations = %v/%v, want 1.5/3600", doc.Server.DigestDuration, doc.Server.RealDuration)
+	}
+	if doc.Totals.Calls != 5 || doc.Totals.Entries != 2 {
+		t.Errorf("writeJSON() totals = %+v, want 5 calls and 2 entries", doc.Totals)
+	}
+	if len(doc.Load) != 5 || doc.Load["ddl"].Calls != 0 || doc.Load["read"].Calls != 4 {
+		t.Errorf("writeJSON() load = %+v, want every class", doc.Load)
+	}
+	// -top does not apply
+	if len(doc.Entries) != 2 {
+		t.Fatalf("writeJSON() wrote %d entries, want 2", len(doc.Entries))
+	}
+	e := doc.Entries[0]
+	if e.Hash != "4a1cb28f" || e.QueryTime.Percentiles["p95"] != 3 || e.RowsExamined.Sum != 4000 {
+		t.Errorf("writeJSON() first entry = %+v", e)
+	}
+}
+
+func Test_writeJSON_oneQuery(t *testing.T) {
+	agg, _ := digest.NewAggregator("fingerprint")
+	agg.Add(query.Query{Time: time.Date(2021, 3, 23, 11, 31, 57, 0, time.UTC), QueryTime: 1.5, Query: "SELECT 1"})
+	// a single query spans no time
+	r := report{
+		totals:      agg.Totals(),
+		stats:       digest.Compute(agg.Entries(), 0, []float64{50, 95}),
+		groupBy:     "fingerprint",
+		top:         10,
+		percentiles: []float64{50, 95},
+	}
+
+	var b bytes.Buffer
+	if err := writeJSON(&b, r); err != nil {
+		t.Fatalf("writeJSON() error = %v", err)
+	}
+	var doc jsonReport
+	if err := json.Unmarshal(b.Bytes(), &doc); err != nil {
+		t.Fatalf("cannot decode document: %s", err)
+	}
+	if len(doc.Entries) != 1 || doc.Entries[0].Concurrency != 0 {
+		t.Errorf("writeJSON() entries = %+v, want one with no concurrency", doc.Entries)
+	}
+}
+
+func Test_writeCSV(t *testing.T) {
+	tests := []struct {
+		name  string
+		comma rune
+		top   int
+		want  [][]string
+	}{
+		{name: "top", comma: ',', top: 1, want: [][]string{
+			csvHeader([]float64{50, 95}, nil, 1),
+			{"1", "4a1cb28f", "select * from orders where id = ?", "", "", "", "shop", "4",
+				"7", "1", "3", "1.75", "1.5", "3", "0.8", "0.008", "40", "4000", "2048", "0", "0", "0.19",
+				"SELECT * FROM orders WHERE id = 3", "2021-03-23T00:30:00Z", "3", "app", "10.0.0.1", "shop", "120",
+				"SELECT * FROM orders\nWHERE id = 1", "", "1.2", "", "", "", "12"},
+		}},
+		{name: "tsv with every entry", comma: '\t', top: 10, want: [][]string{
+			csvHeader([]float64{50, 95}, nil, 1),
+			{"1", "4a1cb28f", "select * from orders where id = ?", "", "", "", "shop", "4",
+				"7", "1", "3", "1.75", "1.5", "3", "0.8", "0.008", "40", "4000", "2048", "0", "0", "0.19",
+				"SELECT * FROM orders WHERE id = 3", "2021-03-23T00:30:00Z", "3", "app", "10.0.0.1", "shop", "120",
+				"SELECT * FROM orders\nWHERE id = 1", "", "1.2", "", "", "", "12"},
+			{"2", "9e0d7c11", "delete from carts where updated_at < ?", "", "", "", "shop", "1",
+				"0.5", "0.5", "0.5", "0.5", "0.5", "0.5", "0", "0.002", "10", "1000", "1024", "0", "0", "0.01",
+				"", "", "", "", "", "", "", "", "", "", "", "", "", ""},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := testReport()
+			r.top = tt.top
+			var b bytes.Buffer
+			if err := writeCSV(&b, r, tt.comma); err != nil {
+				t.Fatalf("writeCSV() error = %v", err)
+			}
+
+			cr := csv.NewReader(&b)
+			cr.Comma = tt.comma
+			got, err := cr.ReadAll()
+			if err != nil {
+				t.Fatalf("cannot read output: %s", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("writeCSV() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_writeHTML(t *testing.T) {
+	r := testReport()
+	r.top = 2
+	r.stats[1].Slowest = digest.Sample{Query: "DELETE FROM carts WHERE updated_at < '2021-03-01'", QueryTime: 0.5, Schema: "shop", Line: 7}
+	r.timeline = map[int64]digest.Load{
+		1616457600: {Calls: 3, CumQueryTime: 5},
+		1616457660: {Calls: 2, CumQueryTime: 2.5},
+	}
+
+	var b bytes.Buffer
+	if err := writeHTML(&b, r); err != nil {
+		t.Fatalf("writeHTML() error = %v", err)
+	}
+	for _, want := range []string{
+		"<td>/usr/sbin/mysqld</td>",
+		`<table id="entries">`,
+		`<td class="num" data-value="7">7s</td>`,
+		"<title>2021-03-23 00:00: 5s</title>",
+		"<summary>Slowest query: 500ms, shop, line 7</summary>",
+		"<pre>DELETE FROM carts WHERE updated_at &lt; &#39;2021-03-01&#39;</pre>",
+		"<title>1s to 1.78s: 3 calls</title>",
+	} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("writeHTML() does not contain %q", want)
+		}
+	}
+	if strings.Contains(b.String(), "ZgotmplZ") {
+		t.Errorf("writeHTML() has unsafe content:\n%s", b.String())
+	}
+}
+
+func Test_histogramChart(t *testing.T) {
+	tests := []struct {
+		name  string
+		times []float64
+		bars  int
+		first string
+		last  string
+		max   string
+	}{
+		{name: "empty"},
+		{name: "single bin", times: []float64{0.2, 0.2, 0.25}, bars: 1, first: "178ms", last: "316ms", max: "3 calls"},
+		{name: "two decades", times: []float64{0.0011, 0.011, 0.11, 0.11}, bars: 9, first: "1ms", last: "178ms", max: "2 calls"},
+		{name: "zero time", times: []float64{0}, bars: 1, first: "1µs", last: "1.78µs", max: "1 calls"},
+		{name: "bounded", times: []float64{1.1e-6, 1.1e4}, bars: maxHistogramBins, first: "1.78µs", last: "4h56m40s", max: "1 calls"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := histogramChart(testSketch(tt.times...))
+			if len(c.Bars) != tt.bars || c.First != tt.first || c.Last != tt.last || c.Max != tt.max {
+				t.Errorf("histogramChart() = %d bars from %s to %s, max %s, want %d bars from %s to %s, max %s",
+					len(c.Bars), c.First, c.Last, c.Max, tt.bars, tt.first, tt.last, tt.max)
+			}
+		})
+	}
+}
+
+func Test_timelineChart(t *testing.T) {
+	tests := []struct {
+		name     string
+		timeline map[int64]digest.Load
+		bars     int
+		max      string
+	}{
+		{name: "empty"},
+		{name: "minutes", timeline: map[int64]digest.Load{0: {CumQueryTime: 1}, 120: {CumQueryTime: 3}}, bars: 3, max: "3s per 1m0s"},
+		{name: "grouped minutes", timeline: map[int64]digest.Load{0: {CumQueryTime: 1}, 60: {CumQueryTime: 3}, 60 * 239: {CumQueryTime: 1}}, bars: 120, max: "4s per 2m0s"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := timelineChart(tt.timeline)
+			if len(c.Bars) != tt.bars || c.Max != tt.max {
+				t.Errorf("timelineChart() = %d bars, max %s, want %d bars, max %s", len(c.Bars), c.Max, tt.bars, tt.max)
+			}
+		})
+	}
+}
@@ -0,0 +1,351 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/devops-works/slowql/digest"
+	"github.com/devops-works/slowql/query/structure"
+	"github.com/devops-works/slowql/sketch"
+)
+
+// ptBuckets are the labels of the query time distribution of pt-query-digest,
+// from 1µs to 10s and more
+var ptBuckets = []string{"1us", "10us", "100us", "1ms", "10ms", "100ms", "1s", "10s+"}
+
+const (
+	// ptBarWidth is the width of the longest bar of the query time
+	// distribution
+	ptBarWidth = 64
+	// ptTitleWidth is the width of the titles, padded with underscores
+	ptTitleWidth = 78
+)
+
+// writePT writes the report in the format of pt-query-digest: the overall
+// stats, the profile of the top entries, and a section for each of them. The
+// attributes that are only aggregated as sums show their total and average
+func writePT(w io.Writer, r report) error {
+	ew := &errWriter{w: w}
+
+	// overall
+	unique := len(r.stats)
+	seconds := r.meta.RealDuration.Seconds()
+	ew.printf("\n%s\n", ptTitle(fmt.Sprintf("# Overall: %s total, %d unique, %s QPS, %sx concurrency",
+		ptShorten(float64(r.totals.Calls)), unique,
+		ptRate(float64(r.totals.Calls), seconds), ptRate(r.totals.CumQueryTime, seconds))))
+	if first, last, ok := timelineRange(r.timeline); ok {
+		ew.printf("# Time range: %s to %s\n", first.Format("2006-01-02T15:04:05"), last.Format("2006-01-02T15:04:05"))
+	}
+	minTime, maxTime := math.Inf(1), 0.0
+	for _, s := range r.stats {
+		minTime = math.Min(minTime, s.MinTime)
+		maxTime = math.Max(maxTime, s.MaxTime)
+	}
+	if len(r.stats) == 0 {
+		minTime = 0
+	}
+	ew.printf("# %-12s %7s %7s %7s %7s %7s %7s %7s\n", "Attribute", "total", "min", "max", "avg", "95%", "stddev", "median")
+	ew.printf("# %s %s %s %s %s %s %s %s\n", ptLine(12), ptLine(7), ptLine(7), ptLine(7), ptLine(7), ptLine(7), ptLine(7), ptLine(7))
+	calls := float64(r.totals.Calls)
+	ew.printf("# %-12s %7s %7s %7s %7s\n", "Exec time", ptTime(r.totals.CumQueryTime),
+		ptTime(minTime), ptTime(maxTime), ptTime(ptAvg(r.totals.CumQueryTime, calls)))
+	ew.printf("# %-12s %7s %7s %7s %7s\n", "Lock time", ptTime(r.totals.CumLockTime), "", "", ptTime(ptAvg(r.totals.CumLockTime, calls)))
+	for _, a := range []struct {
+		name string
+		sum  int
+	}{
+		{"Rows sent", r.totals.CumRowsSent},
+		{"Rows examine", r.totals.CumRowsExamined},
+		{"Bytes sent", r.totals.CumBytesSent},
+	} {
+		ew.printf("# %-12s %7s %7s %7s %7s\n", a.name, ptShorten(float64(a.sum)), "", "", ptShorten(ptAvg(float64(a.sum), calls)))
+	}
+
+	// profile
+	top := r.stats
+	if len(top) > r.top {
+		top = top[:r.top]
+	}
+	ew.printf("\n# Profile\n")
+	ew.printf("# %4s %-18s %14s %5s %6s %5s %s\n", "Rank", "Query ID", "Response time", "Calls", "R/Call", "V/M", "Item")
+	ew.printf("# %s %s %s %s %s %s %s\n", ptLine(4), ptLine(18), ptLine(14), ptLine(5), ptLine(6), ptLine(5), ptLine(11))
+	for i, s := range top {
+		ew.printf("# %4d %-18s %7.4f %5.1f%% %5d %6.4f %5.2f %s\n", i+1, ptQueryID(s), s.CumQueryTime,
+			ptPercent(s.CumQueryTime, r.totals.CumQueryTime), s.Calls, s.MeanTime, ptVariance(s), ptItem(s))
+	}
+	if rest := r.stats[len(top):]; len(rest) > 0 {
+		var cum float64
+		var calls int
+		for _, s := range rest {
+			cum += s.CumQueryTime
+			calls += s.Calls
+		}
+		ew.printf("# %4s %-18s %7.4f %5.1f%% %5d %6.4f %5s <%d ITEMS>\n", "MISC", "0xMISC", cum,
+			ptPercent(cum, r.totals.CumQueryTime), calls, ptAvg(cum, float64(calls)), "0.0", len(rest))
+	}
+
+	// detail of each entry
+	for i, s := range top {
+		ew.printf("\n%s\n", ptTitle(fmt.Sprintf("# Query %d: %s QPS, %sx concurrency, ID %s", i+1,
+			ptRate(float64(s.Calls), seconds), ptRate(s.CumQueryTime, seconds), ptQueryID(s))))
+		ew.printf("# Scores: V/M = %.2f\n", ptVariance(s))
+		ew.printf("# %-12s %3s %7s %7s %7s %7s %7s %7s %7s\n", "Attribute", "pct", "total", "min", "max", "avg", "95%", "stddev", "median")
+		ew.printf("# %s %s %s %s %s %s %s %s %s\n", ptLine(12), ptLine(3), ptLine(7), ptLine(7), ptLine(7), ptLine(7), ptLine(7), ptLine(7), ptLine(7))
+		ew.printf("# %-12s %3.0f %7d\n", "Count", ptPercent(float64(s.Calls), calls), s.Calls)
+		ew.printf("# %-12s %3.0f %7s %7s %7s %7s %7s %7s %7s\n", "Exec time", ptPercent(s.CumQueryTime, r.totals.CumQueryTime),
+			ptTime(s.CumQueryTime), ptTime(s.MinTime), ptTime(s.MaxTime), ptTime(s.MeanTime),
+			ptTime(ptQuantile(s, 0.95)), ptTime(s.StddevTime), ptTime(ptQuantile(s, 0.5)))
+		for _, a := range []struct {
+			name, metric string
+			sum, total   float64
+			format       func(float64) string
+		}{
+			{"Lock time", "lock_time", s.CumLockTime, r.totals.CumLockTime, ptTime},
+			{"Rows sent", "rows_sent", float64(s.CumRowsSent), float64(r.totals.CumRowsSent), ptShorten},
+			{"Rows examine", "rows_examined", float64(s.CumRowsExamined), float64(r.totals.CumRowsExamined), ptShorten},
+			{"Bytes sent", "bytes_sent", float64(s.CumBytesSent), float64(r.totals.CumBytesSent), ptShorten},
+		} {
+			avg := a.format(ptAvg(a.sum, float64(s.Calls)))
+			sk, ok := s.Sketches[a.metric]
+			if !ok {
+				ew.printf("# %-12s %3.0f %7s %7s %7s %7s\n", a.name, ptPercent(a.sum, a.total), a.format(a.sum), "", "", avg)
+				continue
+			}
+			ew.printf("# %-12s %3.0f %7s %7s %7s %7s %7s %7s %7s\n", a.name, ptPercent(a.sum, a.total),
+				a.format(a.sum), a.format(sk.Min()), a.format(sk.Max()), avg,
+				a.format(sk.Quantile(0.95)), a.format(sk.StdDev()), a.format(sk.Quantile(0.5)))
+		}
+		if s.Schema != "" {
+			ew.printf("# Databases    %s\n", s.Schema)
+		}
+		if hosts := ptShares(s.Host, s.Hosts, s.Calls); hosts != "" {
+			ew.printf("# Hosts        %s\n", hosts)
+		}
+		if users := ptShares(s.User, s.Users, s.Calls); users != "" {
+			ew.printf("# Users        %s\n", users)
+		}
+
+		ew.printf("# Query_time distribution\n")
+		counts := ptDistribution(s.Sketch)
+		max := 0
+		for _, c := range counts {
+			if c > max {
+				max = c
+			}
+		}
+		for b, label := range ptBuckets {
+			bar := ""
+			if counts[b] > 0 {
+				bar = strings.Repeat("#", int(math.Max(1, float64(counts[b]*ptBarWidth/max))))
+			}
+			ew.printf("%s\n", strings.TrimRight(fmt.Sprintf("# %6s  %s", label, bar), " "))
+		}
+
+		st := structure.Extract(s.Fingerprint)
+		if len(st.Tables) > 0 {
+			ew.printf("# Tables\n")
+			for _, t := range st.Tables {
+				schema := t.Schema
+				if schema == "" {
+					schema = s.Schema
+				}
+				if schema != "" {
+					ew.printf("#    SHOW TABLE STATUS FROM `%s` LIKE '%s'\\G\n", schema, t.Name)
+					ew.printf("#    SHOW CREATE TABLE `%s`.`%s`\\G\n", schema, t.Name)
+				} else {
+					ew.printf("#    SHOW TABLE STATUS LIKE '%s'\\G\n", t.Name)
+					ew.printf("#    SHOW CREATE TABLE `%s`\\G\n", t.Name)
+				}
+			}
+		}
+
+		switch {
+		case s.Slowest.Query != "":
+			ew.printf("# Slowest: %s\n", formatSample(s.Slowest))
+			ew.printf("%s\\G\n", strings.TrimRight(s.Slowest.Query, ";"))
+			for i, sample := range s.Samples {
+				ew.printf("# Sample #%d: %s\n", i+1, formatSample(sample))
+				ew.printf("%s\\G\n", strings.TrimRight(sample.Query, ";"))
+			}
+		case s.Fingerprint != "":
+			ew.printf("%s\\G\n", s.Fingerprint)
+		default:
+			ew.printf("# Table: %s\n", s.Table)
+		}
+	}
+	return ew.err
+}
+
+// ptQueryID returns the ID of an entry as pt-query-digest does: the last 16
+// hexadecimal digits of its hash, in upper case
+func ptQueryID(s digest.Entry) string {
+	id := s.Hash
+	if len(id) > 16 {
+		id = id[len(id)-16:]
+	}
+	return "0x" + strings.ToUpper(id)
+}
+
+// ptShares returns the value of a string attribute of an entry, as
+// pt-query-digest does: the value the entry is grouped by, or its main values
+// with their calls and share of calls, such as "app (3/75%), cron (1/25%)"
+func ptShares(grouped string, loads map[string]digest.Load, calls int) string {
+	if grouped != "" {
+		return grouped
+	}
+	top := digest.Top(loads, topShares)
+	if len(top) == 1 && len(loads) == 1 {
+		return top[0].Name
+	}
+	var shares []string
+	for _, s := range top {
+		shares = append(shares, fmt.Sprintf("%s (%d/%.0f%%)", s.Name, s.Calls, ptPercent(float64(s.Calls), float64(calls))))
+	}
+	if len(loads) > topShares {
+		shares = append(shares, fmt.Sprintf("... %d more", len(loads)-topShares))
+	}
+	return strings.Join(shares, ", ")
+}
+
+// ptItem returns the short description of an entry, such as SELECT orders
+func ptItem(s digest.Entry) string {
+	if s.Table != "" {
+		return s.Table
+	}
+	st := structure.Extract(s.Fingerprint)
+	item := strings.ToUpper(st.Type.String())
+	switch st.Type {
+	case structure.Unknown, structure.Other:
+		fields := strings.Fields(s.Fingerprint)
+		if len(fields) == 0 {
+			return ""
+		}
+		item = strings.ToUpper(fields[0])
+	}
+	for _, t := range st.Tables {
+		item += " " + t.String()
+	}
+	return item
+}
+
+// ptVariance returns the variance-to-mean ratio of the query time of an entry
+func ptVariance(s digest.Entry) float64 {
+	if s.MeanTime == 0 {
+		return 0
+	}
+	return s.StddevTime * s.StddevTime / s.MeanTime
+}
+
+// ptQuantile returns the q quantile of the query time of an entry, or zero
+// without distribution
+func ptQuantile(s digest.Entry, q float64) float64 {
+	if s.Sketch == nil {
+		return 0
+	}
+	return s.Sketch.Quantile(q)
+}
+
+// ptDistribution returns the number of queries of each bucket of ptBuckets
+func ptDistribution(sk *sketch.Sketch) []int {
+	counts := make([]int, len(ptBuckets))
+	if sk == nil {
+		return counts
+	}
+	sk.Buckets(func(lower, upper float64, count uint64) {
+		b := 0
+		if lower > 0 {
+			// the bucket is narrower than the accuracy, so its middle
+			// stands for all its values
+			b = int(math.Floor(math.Log10(math.Sqrt(lower*upper)))) + 6
+		}
+		if b < 0 {
+			b = 0
+		} else if b >= len(counts) {
+			b = len(counts) - 1
+		}
+		counts[b] += int(count)
+	})
+	return counts
+}
+
+// ptTime formats a time in seconds as pt-query-digest does: 12us, 340ms, 2s
+func ptTime(t float64) string {
+	switch {
+	case t == 0:
+		return "0"
+	case t < 1e-3:
+		return fmt.Sprintf("%.0fus", t*1e6)
+	case t < 1:
+		return fmt.Sprintf("%.0fms", t*1e3)
+	}
+	return fmt.Sprintf("%.0fs", t)
+}
+
+// ptShorten formats a number with a unit suffix when it is large, such as
+// 4.00k
+func ptShorten(n float64) string {
+	units := []string{"", "k", "M", "G", "T"}
+	u := 0
+	for n >= 1000 && u < len(units)-1 {
+		n /= 1000
+		u++
+	}
+	if u == 0 {
+		return fmt.Sprintf("%.0f", n)
+	}
+	return fmt.Sprintf("%.2f%s", n, units[u])
+}
+
+// ptRate returns n per second, formatted with two decimals
+func ptRate(n, seconds float64) string {
+	if seconds <= 0 {
+		return "0"
+	}
+	return fmt.Sprintf("%.2f", n/seconds)
+}
+
+// ptAvg returns the average of a sum, or zero without calls
+func ptAvg(sum, calls float64) float64 {
+	if calls == 0 {
+		return 0
+	}
+	return sum / calls
+}
+
+// ptPercent returns the percentage of a part of a total
+func ptPercent(part, total float64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return 100 * part / total
+}
+
+// ptTitle pads a title with underscores, as pt-query-digest does
+func ptTitle(s string) string {
+	if pad := ptTitleWidth - len(s) - 1; pad > 0 {
+		return s + " " + strings.Repeat("_", pad)
+	}
+	return s
+}
+
+// ptLine returns a line of n equal signs
+func ptLine(n int) string {
+	return strings.Repeat("=", n)
+}
+
+// timelineRange returns the first and last minutes of a timeline
+func timelineRange(timeline map[int64]digest.Load) (time.Time, time.Time, bool) {
+	if len(timeline) == 0 {
+		return time.Time{}, time.Time{}, false
+	}
+	var minutes []int64
+	for m := range timeline {
+		minutes = append(minutes, m)
+	}
+	sort.Slice(minutes, func(i, j int) bool { return minutes[i] < minutes[j] })
+	return time.Unix(minutes[0], 0).UTC(), time.Unix(minutes[len(minutes)-1], 0).UTC(), true
+}
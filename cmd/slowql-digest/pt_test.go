@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/devops-works/slowql/digest"
+)
+
+func Test_writePT(t *testing.T) {
+	r := testReport()
+	r.stats[0].Slowest = digest.Sample{Query: "SELECT * FROM orders WHERE id = 42;", QueryTime: 3, User: "app", Host: "10.0.0.1", Line: 42}
+	r.timeline = map[int64]digest.Load{1616457600: {}, 1616461200: {}}
+
+	var b bytes.Buffer
+	if err := writePT(&b, r); err != nil {
+		t.Fatalf("writePT() error = %v", err)
+	}
+	for _, want := range []string{
+		"# Overall: 5 total, 2 unique, 0.00 QPS, 0.00x concurrency ___",
+		"# Time range: 2021-03-23T00:00:00 to 2021-03-23T01:00:00",
+		"# Exec time         8s   500ms      3s      2s\n",
+		"# Rows examine   5.00k                   1.00k\n",
+		"#    1 0x4A1CB28F          7.0000  93.3%     4 1.7500  0.37 SELECT orders\n",
+		"# MISC 0xMISC              0.5000   6.7%     1 0.5000   0.0 <1 ITEMS>\n",
+		"# Query 1: 0.00 QPS, 0.00x concurrency, ID 0x4A1CB28F ___",
+		"# Scores: V/M = 0.37\n",
+		"# Count         80       4\n",
+		"# Exec time     93      7s      1s      3s      2s      2s   800ms      2s\n",
+		"# Lock time     80     8ms     1ms     3ms     2ms     2ms   707us     2ms\n",
+		"# Rows examine  80   4.00k     500   1.50k   1.00k   1.00k     354   1.00k\n",
+		"# Databases    shop\n",
+		"#  100ms\n#     1s  " + strings.Repeat("#", ptBarWidth) + "\n#   10s+\n",
+		"#    SHOW CREATE TABLE `shop`.`orders`\\G\n",
+		"# Slowest: 3s, app@10.0.0.1, line 42\nSELECT * FROM orders WHERE id = 42\\G\n",
+	} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("writePT() does not contain %q:\n%s", want, b.String())
+		}
+	}
+	if strings.Contains(b.String(), "# Query 2") {
+		t.Errorf("writePT() shows more than the top entries:\n%s", b.String())
+	}
+}
+
+func Test_ptItem(t *testing.T) {
+	tests := []struct {
+		name string
+		s    digest.Entry
+		want string
+	}{
+		{name: "select", s: digest.Entry{Fingerprint: "select * from orders o join shop.users u on u.id = o.user_id"}, want: "SELECT orders shop.users"},
+		{name: "no table", s: digest.Entry{Fingerprint: "set names ?"}, want: "SET"},
+		{name: "other", s: digest.Entry{Fingerprint: "flush tables"}, want: "FLUSH"},
+		{name: "table", s: digest.Entry{Table: "shop.orders"}, want: "shop.orders"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ptItem(tt.s); got != tt.want {
+				t.Errorf("ptItem() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_ptDistribution(t *testing.T) {
+	got := ptDistribution(testSketch(0, 0.0000005, 0.000002, 0.0005, 0.003, 0.05, 0.5, 1.5, 9.9, 11, 3600))
+	want := []int{3, 0, 1, 1, 1, 1, 2, 2}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ptDistribution() = %v, want %v", got, want)
+	}
+}
+
+func Test_ptFormat(t *testing.T) {
+	tests := []struct {
+		name string
+		got  string
+		want string
+	}{
+		{name: "zero time", got: ptTime(0), want: "0"},
+		{name: "microseconds", got: ptTime(0.000035), want: "35us"},
+		{name: "milliseconds", got: ptTime(0.0123), want: "12ms"},
+		{name: "seconds", got: ptTime(1125.4), want: "1125s"},
+		{name: "small number", got: ptShorten(999), want: "999"},
+		{name: "thousands", got: ptShorten(4000), want: "4.00k"},
+		{name: "millions", got: ptShorten(2500000), want: "2.50M"},
+		{name: "query id", got: ptQueryID(digest.Entry{Hash: "3858f62230ac3c915f300c664312c63f"}), want: "0x5F300C664312C63F"},
+		{name: "title", got: ptTitle("# Query 1"), want: "# Query 1 " + strings.Repeat("_", ptTitleWidth-10)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.got != tt.want {
+				t.Errorf("got %q, want %q", tt.got, tt.want)
+			}
+		})
+	}
+}
@@ -1,131 +1,179 @@
 package main
 
 import (
-	"crypto/md5"
 	"fmt"
-	"regexp"
-	"sort"
+	"math"
+	"strconv"
 	"strings"
 	"time"
 
-	"github.com/montanaflynn/stats"
+	"github.com/devops-works/slowql/digest"
 )
 
-var regexeps []replacements
+// topShares is the number of users and hosts shown in the breakdown of an
+// entry
+const topShares = 3
 
-// replacements holds list of regexps we'll apply to queries for normalization
-type replacements struct {
-	Rexp *regexp.Regexp
-	Repl string
+// metricTitles are the titles of the metrics in the reports
+var metricTitles = map[string]string{
+	"lock_time":     "Lock time",
+	"rows_sent":     "Rows sent",
+	"rows_examined": "Rows examined",
+	"bytes_sent":    "Bytes sent",
 }
 
-// returns MD5 hash of the query
-func hash(q string) string {
-	data := []byte(q)
-	return fmt.Sprintf("%x", md5.Sum(data))
+// fsecsToDuration converts float seconds to time.Duration
+// Since we have float64 seconds durations
+// We first convert to µs (* 1e6) then to duration
+func fsecsToDuration(d float64) time.Duration {
+	return time.Duration(d*1e6) * time.Microsecond
 }
 
-// returns fingerprint of the query, which a generalised version of it acquired
-// thanks to regexes
-func fingerprint(q string) string {
-	fingerprint := strings.ToLower(q)
-	for _, r := range regexeps {
-		fingerprint = r.Rexp.ReplaceAllString(fingerprint, r.Repl)
+// formatMetric formats a value of a metric: a duration for the lock time, a
+// number otherwise
+func formatMetric(metric string, v float64) string {
+	if metric == "lock_time" {
+		return fsecsToDuration(v).String()
 	}
-	return fingerprint
+	return strconv.FormatFloat(math.Round(v*100)/100, 'f', -1, 64)
 }
 
-func init() {
-	// Regexps initialization
-	// Create regexps entries for query normalization
-	//
-	// From pt-query-digest man page (package QueryRewriter section)
-	//
-	// 1·   Group all SELECT queries from mysqldump together, even if they are against different tables.
-	//      The same applies to all queries from pt-table-checksum.
-	// 2·   Shorten multi-value INSERT statements to a single VALUES() list.
-	// 3·   Strip comments.
-	// 4·   Abstract the databases in USE statements, so all USE statements are grouped together.
-	// 5·   Replace all literals, such as quoted strings.  For efficiency, the code that replaces literal numbers is
-	//      somewhat non-selective, and might replace some things as numbers when they really are not.
-	//      Hexadecimal literals are also replaced.  NULL is treated as a literal.  Numbers embedded in identifiers are
-	//	    also replaced, so tables named similarly will be fingerprinted to the same values
-	//      (e.g. users_2009 and users_2010 will fingerprint identically).
-	// 6·   Collapse all whitespace into a single space.
-	// 7·   Lowercase the entire query.
-	// 8·   Replace all literals inside of IN() and VALUES() lists with a single placeholder, regardless of cardinality.
-	// 9·   Collapse multiple identical UNION queries into a single one.
-	regexeps = []replacements{
-		// 1·   Group all SELECT queries from mysqldump together
-		// ... not implemented ...
-		// 3·   Strip comments.
-		{regexp.MustCompile(`(.*)/\*.*\*/(.*)`), "$1$2"},
-		{regexp.MustCompile(`(.*) --.*`), "$1"},
-		// 2·   Shorten multi-value INSERT statements to a single VALUES() list.
-		{regexp.MustCompile(`^(insert .*) values.*`), "$1 values (?)"},
-		// 4·   Abstract the databases in USE statements
-		// ... not implemented ... since I don't really get it
-		// 5·   Sort of...
-		{regexp.MustCompile(`\s*([!><=]{1,2})\s*'[^']+'`), " $1 ?"},
-		{regexp.MustCompile(`\s*([!><=]{1,2})\s*\x60[^\x60]+\x60`), " $1 ?"},
-		{regexp.MustCompile(`\s*([!><=]{1,2})\s*[\.a-zA-Z0-9_-]+`), " $1 ?"},
-		{regexp.MustCompile(`\s*(not)?\s+like\s+'[^']+'`), " not like ?"},
-		// {regexp.MustCompile(`\s*(not)?\s+like\s+\x60[^\x60]+\x60`), " not like ?"}, // Not sure this one (LIKE `somestuff`) is necessary
-		// 6·   Collapse all whitespace into a single space.
-		{regexp.MustCompile(`[\s]{2,}`), " "},
-		{regexp.MustCompile(`\s$`), ""}, // trim space at end
-		// 7·   Lowercase the entire query.
-		// ... implemented elsewhere ...
-		// 8·   Replace all literals inside of IN() and VALUES() lists with a single placeholder
-		// IN (...), VALUES, OFFSET
-		{regexp.MustCompile(`in\s+\([^\)]+\)`), "in (?)"},
-		// {regexp.MustCompile(`values\s+\([^\)]+\)`), "values (?)"},
-		{regexp.MustCompile(`offset\s+\d+`), "offset ?"},
-		// 9·   Collapse multiple identical UNION queries into a single one.
-		// ... not implemented ...
-
+// groupTitle returns what the entries are, after the first dimension they are
+// grouped by: Query, Table, User, Host or Schema
+func groupTitle(groupBy string) string {
+	switch strings.Split(groupBy, ",")[0] {
+	case "table":
+		return "Table"
+	case "user":
+		return "User"
+	case "host":
+		return "Host"
+	case "schema":
+		return "Schema"
 	}
+	return "Query"
 }
 
-// fsecsToDuration converts float seconds to time.Duration
-// Since we have float64 seconds durations
-// We first convert to µs (* 1e6) then to duration
-func fsecsToDuration(d float64) time.Duration {
-	return time.Duration(d*1e6) * time.Microsecond
+// entryName returns the name of an entry: its table or its fingerprint,
+// followed by the user and host it is grouped by. Entries grouped by schema
+// alone are named after it
+func entryName(s digest.Entry) string {
+	var keys []string
+	if s.User != "" {
+		keys = append(keys, "user "+s.User)
+	}
+	if s.Host != "" {
+		keys = append(keys, "host "+s.Host)
+	}
+	name := s.Fingerprint
+	if s.Table != "" {
+		name = s.Table
+	}
+	switch {
+	case name == "" && len(keys) == 0:
+		return s.Schema
+	case name == "":
+		return strings.Join(keys, ", ")
+	case len(keys) > 0:
+		return name + " (" + strings.Join(keys, ", ") + ")"
+	}
+	return name
 }
 
-func computeStats(res []statistics, realDuration time.Duration) ([]statistics, error) {
-	var err error
-	ffactor := 100.0 * float64(time.Second) / float64(realDuration)
-	for i := 0; i < len(res); i++ {
-
-		// Mean time
-		res[i].MeanTime = res[i].CumQueryTime / float64(res[i].Calls)
-
-		// Compute percentiles
-		var arr []float64
-		arr = append(arr, res[i].QueryTimes...)
-		sort.Slice(arr, func(i, j int) bool {
-			return arr[i] < arr[j]
-		})
-		res[i].P95Time, err = stats.Percentile(arr, 95)
-		if err != nil {
-			return nil, err
-		}
-		res[i].P50Time, err = stats.Percentile(arr, 50)
-		if err != nil {
-			return nil, err
+// formatShares formats the main users or hosts of an entry, with their share
+// of its query time, such as "app 75.00% (3 calls), cron 25.00% (1 calls)"
+func formatShares(loads map[string]digest.Load, cumQueryTime float64) string {
+	var shares []string
+	for _, s := range digest.Top(loads, topShares) {
+		share := 0.0
+		if cumQueryTime > 0 {
+			share = 100 * s.CumQueryTime / cumQueryTime
 		}
+		shares = append(shares, fmt.Sprintf("%s %2.2f%% (%d calls)", s.Name, share, s.Calls))
+	}
+	if len(loads) > topShares {
+		shares = append(shares, fmt.Sprintf("%d more", len(loads)-topShares))
+	}
+	return strings.Join(shares, ", ")
+}
 
-		// compute stddev
-		res[i].StddevTime, err = stats.StandardDeviation(arr)
-		if err != nil {
-			return nil, err
+// formatSample tells where a sampled query comes from, such as "1.2s,
+// 2021-03-23T11:31:57Z, app@10.0.0.12, shop, line 42". Unknown attributes are
+// left out
+func formatSample(s digest.Sample) string {
+	parts := []string{fsecsToDuration(s.QueryTime).String()}
+	if !s.Time.IsZero() {
+		parts = append(parts, s.Time.Format(time.RFC3339Nano))
+	}
+	if s.User != "" || s.Host != "" {
+		parts = append(parts, s.User+"@"+s.Host)
+	}
+	if s.Schema != "" {
+		parts = append(parts, s.Schema)
+	}
+	if s.Line > 0 {
+		parts = append(parts, "line "+strconv.Itoa(s.Line))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// sparkBars are the bars of the sparklines, from the lowest to the highest
+var sparkBars = []rune("▁▂▃▄▅▆▇█")
+
+// sparkline draws values as bars scaled to their maximum. Zeros are blank, so
+// that the intervals without queries stand out
+func sparkline(values []float64) string {
+	max := maxFloat(values)
+	line := make([]rune, len(values))
+	for i, v := range values {
+		line[i] = ' '
+		if v > 0 {
+			// any value above zero has at least the lowest bar
+			line[i] = sparkBars[int(math.Ceil(v/max*float64(len(sparkBars))))-1]
 		}
+	}
+	return string(line)
+}
 
-		// compute concurrency
-		res[i].Concurrency = res[i].CumQueryTime * ffactor
+// highestPercentile returns the percentile of the query time followed over
+// time and compared by diff: the highest of percentiles, or 95 if there is
+// none
+func highestPercentile(percentiles []float64) float64 {
+	if len(percentiles) == 0 {
+		return 95
 	}
+	max := percentiles[0]
+	for _, p := range percentiles[1:] {
+		max = math.Max(max, p)
+	}
+	return max
+}
 
-	return res, nil
+// reportSeries returns the points of buckets over the span of the whole
+// report, so that the series of the entries line up with the global one
+func reportSeries(r report, buckets map[int64]digest.Bucket) []digest.Point {
+	first, last := digest.Span(r.buckets)
+	return digest.Series(buckets, first, last, r.bucket, highestPercentile(r.percentiles))
+}
+
+// formatBucket formats the width of the intervals of the series without its
+// trailing zero units, such as 1m or 1h30m
+func formatBucket(d time.Duration) string {
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = s[:len(s)-2]
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = s[:len(s)-2]
+	}
+	return s
+}
+
+// maxFloat returns the highest of values, or 0 if there is none
+func maxFloat(values []float64) float64 {
+	var max float64
+	for _, v := range values {
+		max = math.Max(max, v)
+	}
+	return max
 }
@@ -3,6 +3,8 @@ package main
 import (
 	"testing"
 	"time"
+
+	"github.com/devops-works/slowql/digest"
 )
 
 func Test_fsecsToDuration(t *testing.T) {
@@ -24,19 +26,62 @@ func Test_fsecsToDuration(t *testing.T) {
 	}
 }
 
-func Test_hash(t *testing.T) {
+func Test_sparkline(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []float64
+		want   string
+	}{
+		{name: "scaled to the maximum", values: []float64{1, 2, 4, 8}, want: "▁▂▄█"},
+		{name: "blank without queries", values: []float64{3, 0, 6}, want: "▄ █"},
+		{name: "lowest bar above zero", values: []float64{0.001, 100}, want: "▁█"},
+		{name: "empty", values: nil, want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sparkline(tt.values); got != tt.want {
+				t.Errorf("sparkline() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_formatBucket(t *testing.T) {
+	tests := []struct {
+		d    time.Duration
+		want string
+	}{
+		{d: 30 * time.Second, want: "30s"},
+		{d: time.Minute, want: "1m"},
+		{d: 90 * time.Second, want: "1m30s"},
+		{d: time.Hour, want: "1h"},
+		{d: 90 * time.Minute, want: "1h30m"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			if got := formatBucket(tt.d); got != tt.want {
+				t.Errorf("formatBucket() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_entryName(t *testing.T) {
 	tests := []struct {
 		name string
-		q    string
+		s    digest.Entry
 		want string
 	}{
-		{name: "foobar", q: "foobar", want: "3858f62230ac3c915f300c664312c63f"},
-		{name: "some long string", q: "some long string", want: "2fb66bbfb88cdf9e07a3f1d1dfad71ab"},
+		{name: "fingerprint", s: digest.Entry{Fingerprint: "select ?", Schema: "shop"}, want: "select ?"},
+		{name: "table", s: digest.Entry{Table: "shop.orders", Schema: "shop"}, want: "shop.orders"},
+		{name: "fingerprint and user", s: digest.Entry{Fingerprint: "select ?", User: "app"}, want: "select ? (user app)"},
+		{name: "user and host", s: digest.Entry{User: "app", Host: "10.0.0.1"}, want: "user app, host 10.0.0.1"},
+		{name: "schema", s: digest.Entry{Schema: "shop"}, want: "shop"},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := hash(tt.q); got != tt.want {
-				t.Errorf("hash() = %v, want %v", got, tt.want)
+			if got := entryName(tt.s); got != tt.want {
+				t.Errorf("entryName() = %v, want %v", got, tt.want)
 			}
 		})
 	}
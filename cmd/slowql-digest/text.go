@@ -0,0 +1,219 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/devops-works/slowql/digest"
+	"github.com/devops-works/slowql/query/structure"
+	ar "github.com/logrusorgru/aurora"
+)
+
+// writeText writes the report as a colourised text, for terminals
+func writeText(w io.Writer, r report) error {
+	howTo := "increasing"
+	if r.dec {
+		howTo = "decreasing"
+	}
+
+	// show server's meta
+	fmt.Fprintf(w, `
+=-= Server meta =-=
+	
+Binary              : %s
+Port                : %d
+Socket              : %s
+Version             : %s
+Version short       : %s
+Version description : %s
+
+Digest duration     : %s
+Real duration       : %s
+
+Bytes handled       : %d
+	`,
+		r.meta.Binary,
+		r.meta.Port,
+		r.meta.Socket,
+		r.meta.Version,
+		r.meta.VersionShort,
+		r.meta.VersionDescription,
+		r.meta.Duration,
+		r.meta.RealDuration,
+		r.meta.Bytes)
+
+	// show the load of each class of queries. Caches created before queries
+	// were classified have none
+	if len(r.load) > 0 {
+		fmt.Fprintf(w, "\n=-= Load split =-=\n\n")
+		var total float64
+		for _, l := range r.load {
+			total += l.CumQueryTime
+		}
+		for _, c := range structure.Classes {
+			l := r.load[c.String()]
+			share := 0.0
+			if total > 0 {
+				share = 100 * l.CumQueryTime / total
+			}
+			fmt.Fprintf(w, "%-11s : %d calls, %s (%2.2f%% of query time)\n", c, l.Calls, fsecsToDuration(l.CumQueryTime), share)
+		}
+	}
+
+	// show the calls, query time and its percentile of each interval, with
+	// -bucket
+	pName := digest.PercentileName(highestPercentile(r.percentiles))
+	series := func(points []digest.Point) string {
+		calls, cum, pct := make([]float64, len(points)), make([]float64, len(points)), make([]float64, len(points))
+		var maxCalls int
+		for i, p := range points {
+			calls[i], cum[i], pct[i] = float64(p.Calls), p.CumQueryTime, p.Percentile
+			if p.Calls > maxCalls {
+				maxCalls = p.Calls
+			}
+		}
+		per := " per " + formatBucket(r.bucket)
+		return fmt.Sprintf("%-22s : %s (max %d)\n%-22s : %s (max %s)\n%-22s : %s (max %s)\n",
+			"Calls"+per, sparkline(calls), maxCalls,
+			"Query time"+per, sparkline(cum), fsecsToDuration(maxFloat(cum)),
+			pName+per, sparkline(pct), fsecsToDuration(maxFloat(pct)))
+	}
+	if r.bucket > 0 && len(r.buckets) > 0 {
+		first, last := digest.Span(r.buckets)
+		fmt.Fprintf(w, "\n=-= Time series =-=\n\n")
+		fmt.Fprintf(w, "From %s to %s\n", first.Format(time.RFC3339), last.Add(r.bucket).Format(time.RFC3339))
+		fmt.Fprint(w, series(reportSeries(r, r.buckets)))
+	}
+
+	// show queries stats
+	count := r.top
+	fmt.Fprintf(w, "\n=-= Queries stats =-=\n")
+	fmt.Fprintf(w, "\nSorted by: %s, %s\n", ar.Bold(r.order), ar.Bold(howTo))
+	fmt.Fprintf(w, "Showing top %d queries\n", ar.Bold(count))
+	var names []string
+	for _, p := range r.percentiles {
+		names = append(names, digest.PercentileName(p))
+	}
+	percentiles := func(s digest.Entry) string {
+		var values []string
+		for _, name := range names {
+			values = append(values, fsecsToDuration(s.Percentiles[name]).String())
+		}
+		return strings.Join(values, "/")
+	}
+	// distributions returns a line for each metric whose distribution is
+	// shown
+	distributions := func(s digest.Entry) string {
+		var lines string
+		for _, m := range r.metrics {
+			d := s.Distributions[m]
+			var values []string
+			for _, name := range names {
+				values = append(values, formatMetric(m, d.Percentiles[name]))
+			}
+			lines += fmt.Sprintf("%-22s : %s/%s/%s min/max/mean, %s %s\n", metricTitles[m],
+				formatMetric(m, d.Min), formatMetric(m, d.Max), formatMetric(m, d.Mean),
+				strings.Join(values, "/"), strings.Join(names, "/"))
+		}
+		return lines
+	}
+	// samples returns the slowest query and the sampled ones, each on a
+	// line below where it comes from. Caches created before queries were
+	// sampled have none
+	samples := func(s digest.Entry) string {
+		if s.Slowest.Query == "" {
+			return ""
+		}
+		lines := fmt.Sprintf("Slowest                : %s\n    %s\n", formatSample(s.Slowest), oneLine(s.Slowest.Query))
+		for i, sample := range s.Samples {
+			lines += fmt.Sprintf("%-22s : %s\n    %s\n", fmt.Sprintf("Sample #%d", i+1), formatSample(sample), oneLine(sample.Query))
+		}
+		return lines
+	}
+	// entrySeries returns the series of an entry, with -bucket
+	entrySeries := func(s digest.Entry) string {
+		if r.bucket <= 0 {
+			return ""
+		}
+		return series(reportSeries(r, s.Buckets))
+	}
+	for i := 0; i < len(r.stats); i++ {
+		if count == 0 {
+			break
+		}
+
+		title := groupTitle(r.groupBy) + " #"
+		id := identity(r.stats[i])
+
+		fmt.Fprintf(w, `
+%s%d
+Calls                  : %d
+%sSchema                 : %s
+Min/Max/Mean time      : %s/%s/%s
+%-22s : %s
+Concurrency            : %2.4f%%
+Standard deviation     : %s
+Cum Query Time         : %s
+Cum Lock Time          : %s
+Cum Bytes sent         : %d
+Cum Rows Examined/Sent : %d/%d
+Cum Killed             : %d
+%s			`,
+			ar.Bold(ar.Underline(title)),
+			ar.Bold(ar.Underline(i+1)),
+			r.stats[i].Calls,
+			id,
+			r.stats[i].Schema,
+			fsecsToDuration(r.stats[i].MinTime),
+			fsecsToDuration(r.stats[i].MaxTime),
+			fsecsToDuration(r.stats[i].MeanTime),
+			strings.Join(names, "/"),
+			percentiles(r.stats[i]),
+			r.stats[i].Concurrency,
+			fsecsToDuration(r.stats[i].StddevTime),
+			fsecsToDuration(r.stats[i].CumQueryTime),
+			fsecsToDuration(r.stats[i].CumLockTime),
+			r.stats[i].CumBytesSent,
+			r.stats[i].CumRowsExamined,
+			r.stats[i].CumRowsSent,
+			r.stats[i].CumKilled,
+			distributions(r.stats[i])+entrySeries(r.stats[i])+samples(r.stats[i]),
+		)
+
+		count--
+	}
+	_, err := fmt.Fprintln(w)
+	return err
+}
+
+// identity returns the lines identifying an entry, with the values of the
+// dimensions it is grouped by, followed by its main users and hosts
+func identity(s digest.Entry) string {
+	var lines string
+	if s.Fingerprint != "" {
+		lines += fmt.Sprintf("Hash                   : %s\nFingerprint            : %s\n", s.Hash, s.Fingerprint)
+	}
+	if s.Table != "" {
+		lines += fmt.Sprintf("Table                  : %s\n", s.Table)
+	}
+	if s.User != "" {
+		lines += fmt.Sprintf("User                   : %s\n", s.User)
+	}
+	if s.Host != "" {
+		lines += fmt.Sprintf("Host                   : %s\n", s.Host)
+	}
+	if len(s.Users) > 0 {
+		lines += fmt.Sprintf("Users                  : %s\n", formatShares(s.Users, s.CumQueryTime))
+	}
+	if len(s.Hosts) > 0 {
+		lines += fmt.Sprintf("Hosts                  : %s\n", formatShares(s.Hosts, s.CumQueryTime))
+	}
+	return lines
+}
+
+// oneLine returns a query on a single line, its blanks being collapsed
+func oneLine(q string) string {
+	return strings.Join(strings.Fields(q), " ")
+}
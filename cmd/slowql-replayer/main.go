@@ -16,6 +16,7 @@ import (
 	"github.com/devops-works/slowql"
 	"github.com/devops-works/slowql/cmd/slowql-replayer/pprof"
 	"github.com/devops-works/slowql/query"
+	"github.com/devops-works/slowql/query/structure"
 	ar "github.com/logrusorgru/aurora"
 	"github.com/sirupsen/logrus"
 	"golang.org/x/term"
@@ -38,6 +39,10 @@ type options struct {
 	noDryRun   bool
 	showErrors bool
 	hidePB     bool
+	readOnly   bool
+	filter     string
+	since      string
+	until      string
 }
 
 type database struct {
@@ -49,12 +54,17 @@ type database struct {
 	wrks        int
 	speedFactor float64
 	showErrors  bool
+	readOnly    bool
+	filter      *slowql.Filter
+	since       time.Time
+	until       time.Time
 }
 
 type results struct {
 	kind         string
 	dryRun       bool
 	queries      int
+	skipped      int
 	errors       int
 	duration     time.Duration
 	realDuration time.Duration
@@ -82,6 +92,10 @@ func main() {
 	flag.BoolVar(&opt.noDryRun, "no-dry-run", false, "Replay the requests on the database for real")
 	flag.BoolVar(&opt.showErrors, "show-errors", false, "Show SQL errors when they occur")
 	flag.BoolVar(&opt.hidePB, "no-progress", false, "Hide progress bar while replaying")
+	flag.BoolVar(&opt.readOnly, "read-only", false, "Only replay read-only and session statements, so that a replica can be used")
+	flag.StringVar(&opt.filter, "filter", "", "Only replay the queries matching this expression, e.g. 'schema == \"shop\" && user =~ \"^app_\"'")
+	flag.StringVar(&opt.since, "since", "", "Only replay the queries logged at or after this time, e.g. 2021-03-23T11:00:00Z or -2h")
+	flag.StringVar(&opt.until, "until", "", "Only replay the queries logged at or before this time, e.g. 2021-03-23T12:00:00Z or -1h")
 	flag.Parse()
 
 	if errs := opt.parse(); len(errs) > 0 {
@@ -99,12 +113,18 @@ func main() {
 	defer db.drv.Close()
 	db.logger.Debug("database object successfully created")
 
-	f, err := os.Open(opt.file)
+	fd, err := os.Open(opt.file)
 	if err != nil {
 		logrus.Fatalf("cannot open slow query log file: %s", err)
 	}
+	defer fd.Close()
 	db.logger.Debugf("file %s successfully opened", opt.file)
 
+	f, err := db.seek(fd)
+	if err != nil {
+		logrus.Fatalf("cannot seek to %s: %s", db.since, err)
+	}
+
 	if opt.pprof != "" {
 		pprofServer, err := pprof.New(opt.pprof)
 		if err != nil {
@@ -115,7 +135,7 @@ func main() {
 	}
 
 	db.logger.Info("getting real execution time")
-	num, realExec, err := getReferences(db.kind, opt.file)
+	num, realExec, err := db.getReferences(opt.file)
 	if err != nil {
 		db.logger.Fatalf("cannot get references from log file: %s", err)
 	}
@@ -126,6 +146,9 @@ func main() {
 	} else {
 		db.logger.Warn("replaying with dry run")
 	}
+	if opt.readOnly {
+		db.logger.Info("read-only mode: writes, DDL and transaction control statements will be skipped")
+	}
 
 	db.logger.Infof("replay started on %s", time.Now().Format("Mon Jan 2 15:04:05"))
 	db.logger.Infof("estimated time of end: %s", time.Now().
@@ -192,10 +215,38 @@ func (o options) createDB() (*database, error) {
 		db.kind = slowql.MariaDB
 	case "pxc":
 		db.kind = slowql.PXC
+	case "tidb":
+		db.kind = slowql.TiDB
+	case "general":
+		db.kind = slowql.General
 	default:
 		return nil, errors.New("unknown kind " + o.kind)
 	}
 
+	// the filter is checked before connecting to the database
+	if o.filter != "" {
+		db.filter, err = slowql.NewFilter(o.filter)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse filter: %s", err)
+		}
+	}
+
+	// relative times are relative to the start of the program
+	now := time.Now()
+	if o.since != "" {
+		if db.since, err = slowql.ParseTime(o.since, now); err != nil {
+			return nil, fmt.Errorf("cannot parse since: %s", err)
+		}
+	}
+	if o.until != "" {
+		if db.until, err = slowql.ParseTime(o.until, now); err != nil {
+			return nil, fmt.Errorf("cannot parse until: %s", err)
+		}
+	}
+	if !db.since.IsZero() && !db.until.IsZero() && db.until.Before(db.since) {
+		return nil, errors.New("until cannot be before since")
+	}
+
 	db.datasource = fmt.Sprintf("%s:%s@tcp(%s)/%s", o.user, o.pass, o.host, o.database)
 	db.drv, err = sql.Open("mysql", db.datasource)
 	if err != nil {
@@ -247,6 +298,15 @@ func (o options) createDB() (*database, error) {
 	db.showErrors = o.showErrors
 	db.logger.Debugf("show errors: %v", db.showErrors)
 
+	db.readOnly = o.readOnly
+	db.logger.Debugf("read-only: %v", db.readOnly)
+	if db.filter != nil {
+		db.logger.Debugf("filter: %s", db.filter)
+	}
+	if !db.since.IsZero() || !db.until.IsZero() {
+		db.logger.Debugf("time window: [%s, %s]", db.since, db.until)
+	}
+
 	return &db, nil
 }
 
@@ -254,7 +314,9 @@ func (o options) createDB() (*database, error) {
 func (db *database) replay(f io.Reader, totQ int, hidePB bool) (results, error) {
 	var r results
 
-	p := slowql.NewParser(db.kind, f)
+	// logs exported from AWS CloudWatch are unwrapped, the other ones are
+	// read unchanged
+	p := slowql.NewParser(db.kind, slowql.NewCloudWatchReader(db.kind, f))
 
 	jobs := make(chan job, 65535)
 	errors := make(chan error, 16384)
@@ -289,12 +351,19 @@ func (db *database) replay(f io.Reader, totQ int, hidePB bool) (results, error)
 	start := time.Now()
 	for {
 		q := p.GetNext()
-		if q == (query.Query{}) {
+		if q.IsZero() {
 			break
 		}
 		db.logger.Tracef("query: %s", q.Query)
 
-		r.queries++
+		// queries outside of the time window are not part of the replay
+		in, past := slowql.InWindow(q.Time, db.since, db.until)
+		if past {
+			break
+		}
+		if !in {
+			continue
+		}
 
 		// we need a reference time
 		if firstPass {
@@ -302,6 +371,14 @@ func (db *database) replay(f io.Reader, totQ int, hidePB bool) (results, error)
 			reference = q.Time
 		}
 
+		if !db.keep(q) {
+			db.logger.Trace("skipping query")
+			r.skipped++
+			continue
+		}
+
+		r.queries++
+
 		var j job
 		delta := q.Time.Sub(reference)
 		j.idle = start.Add(time.Duration(float64(delta) / db.speedFactor))
@@ -366,6 +443,7 @@ Database
 
 Statistics
   ├─ Queries:                %d
+  ├─ Skipped:                %d
   ├─ Errors:                 %d
   ├─ Queries success rate:   %s
   ├─ Speed factor:           %.4f
@@ -386,6 +464,7 @@ Statistics
 		ar.Bold(o.host),
 		// statistics
 		ar.Bold(r.queries),
+		ar.Bold(r.skipped),
 		ar.Bold(r.errors),
 		ar.Bold(prcSuccess),
 		ar.Bold(o.factor),
@@ -446,33 +525,76 @@ func (r *results) errorsCollector(errors chan error, showErrors bool) {
 }
 
 // getReferences returns the reference log duration and the number of queries
-func getReferences(k slowql.Kind, f string) (int, time.Duration, error) {
+// to replay, which are the ones of the time window kept by keep
+func (db *database) getReferences(f string) (int, time.Duration, error) {
 	var queriesCounter int
 
 	fd, err := os.Open(f)
 	if err != nil {
 		return -1, 0, err
 	}
+	defer fd.Close()
 
-	p := slowql.NewParser(k, fd)
+	r, err := db.seek(fd)
+	if err != nil {
+		return -1, 0, err
+	}
+	p := slowql.NewParser(db.kind, slowql.NewCloudWatchReader(db.kind, r))
 
 	var q query.Query
 	firstPass := true
 	var reference, lastTime time.Time
 	for {
 		q = p.GetNext()
-		if (q == query.Query{}) {
+		if q.IsZero() {
 			break
 		}
+		in, past := slowql.InWindow(q.Time, db.since, db.until)
+		if past {
+			break
+		}
+		if !in {
+			continue
+		}
 
 		if firstPass {
 			firstPass = false
 			reference = q.Time
 		}
-		queriesCounter++
+		if db.keep(q) {
+			queriesCounter++
+		}
 		lastTime = q.Time
 	}
-	fd.Close()
 	duration := lastTime.Sub(reference)
 	return queriesCounter, duration, nil
 }
+
+// keep tells if a query has to be replayed, according to the read-only mode
+// and the filter
+func (db *database) keep(q query.Query) bool {
+	if db.readOnly && !isReadOnly(q) {
+		return false
+	}
+	return db.filter == nil || db.filter.Match(q)
+}
+
+// seek skips the queries logged before the time window, without reading them
+// when the log can be searched
+func (db *database) seek(fd *os.File) (io.Reader, error) {
+	if db.since.IsZero() {
+		return fd, nil
+	}
+	return slowql.Seek(db.kind, fd, db.since)
+}
+
+// isReadOnly tells if a query can be replayed on a replica: reads and session
+// state statements are safe, while writes, DDL and transaction control
+// statements are not
+func isReadOnly(q query.Query) bool {
+	switch q.Class() {
+	case structure.ReadOnly, structure.SessionState:
+		return true
+	}
+	return false
+}
@@ -4,6 +4,9 @@ import (
 	"errors"
 	"reflect"
 	"testing"
+
+	"github.com/devops-works/slowql"
+	"github.com/devops-works/slowql/query"
 )
 
 func Test_options_parse(t *testing.T) {
@@ -77,3 +80,53 @@ func Test_options_parse(t *testing.T) {
 		})
 	}
 }
+
+func Test_isReadOnly(t *testing.T) {
+	tests := []struct {
+		name string
+		q    string
+		want bool
+	}{
+		{name: "select", q: "SELECT * FROM t", want: true},
+		{name: "set names", q: "SET NAMES utf8mb4", want: true},
+		{name: "use", q: "USE shop", want: true},
+		{name: "select for update", q: "SELECT * FROM t FOR UPDATE", want: false},
+		{name: "insert", q: "INSERT INTO t VALUES (1)", want: false},
+		{name: "ddl", q: "DROP TABLE t", want: false},
+		{name: "commit", q: "COMMIT", want: false},
+		{name: "set global", q: "SET GLOBAL read_only = 0", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isReadOnly(query.Query{Query: tt.q}); got != tt.want {
+				t.Errorf("isReadOnly() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_database_keep(t *testing.T) {
+	f, err := slowql.NewFilter(`schema == "shop"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		db   database
+		q    query.Query
+		want bool
+	}{
+		{name: "no restriction", db: database{}, q: query.Query{Query: "DELETE FROM t"}, want: true},
+		{name: "read-only write", db: database{readOnly: true}, q: query.Query{Query: "DELETE FROM t"}, want: false},
+		{name: "filter match", db: database{filter: f}, q: query.Query{Schema: "shop", Query: "DELETE FROM t"}, want: true},
+		{name: "filter mismatch", db: database{filter: f}, q: query.Query{Schema: "crm", Query: "SELECT 1"}, want: false},
+		{name: "both", db: database{readOnly: true, filter: f}, q: query.Query{Schema: "shop", Query: "SELECT 1"}, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.db.keep(tt.q); got != tt.want {
+				t.Errorf("database.keep() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
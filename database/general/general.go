@@ -0,0 +1,176 @@
+package general
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/devops-works/slowql/query"
+	"github.com/devops-works/slowql/server"
+)
+
+// Database holds parser structure
+type Database struct {
+	WaitingList chan query.Query
+	ServerMeta  chan server.Server
+	record      *regexp.Regexp
+	connect     *regexp.Regexp
+	threads     map[int]thread
+	lastTime    time.Time
+	srv         server.Server
+}
+
+// thread holds what is known about a connection, since the general log only
+// gives it when the connection is established or when the schema changes
+type thread struct {
+	user   string
+	host   string
+	schema string
+}
+
+// RecordRegexp matches the first line of a general log record: the time (which
+// MariaDB omits when it is the same as the previous record's), the thread ID,
+// the command and its argument
+var RecordRegexp = regexp.MustCompile(`^([^\t]*)\t+\s*(\d+) ([A-Z][A-Za-z ]*?)(?:\t(.*))?$`)
+
+// New instance of parser
+func New(qc chan query.Query) *Database {
+	p := Database{
+		WaitingList: qc,
+		record:      RecordRegexp,
+		connect:     regexp.MustCompile(`^(\S+)@(\S+)(?: as \S+)? on (\S*)`),
+		threads:     make(map[int]thread),
+	}
+
+	return &p
+}
+
+// ParseBlocks reads a record block and adds the query it contains into a
+// channel. Only Query and Execute commands are sent, the other ones are used to
+// keep track of the connections' user, host and schema
+func (db *Database) ParseBlocks(rawBlocs chan query.Block) {
+	for {
+		select {
+		case bloc := <-rawBlocs:
+			// a nil bloc means that the scanner is done, the empty query tells
+			// the reader that there is nothing left
+			if bloc.Lines == nil {
+				db.WaitingList <- query.Query{}
+				continue
+			}
+			if q, ok := db.parseQuery(bloc.Lines); ok {
+				q.Line = bloc.Line
+				db.WaitingList <- q
+			}
+		}
+	}
+}
+
+// parseQuery parses a record made of its first line and the continuation lines
+// of a multi-line argument. It returns false if the record is not a query
+func (db *Database) parseQuery(block []string) (query.Query, bool) {
+	var q query.Query
+	if len(block) == 0 {
+		return q, false
+	}
+
+	matches := db.record.FindStringSubmatch(block[0])
+	if matches == nil {
+		return q, false
+	}
+
+	if date := strings.TrimSpace(matches[1]); date != "" {
+		db.lastTime = parseTime(date)
+	}
+
+	id, err := strconv.Atoi(matches[2])
+	if err != nil {
+		logrus.Errorf("id: error converting %s to int: %s", matches[2], err)
+	}
+
+	// the lines are kept as is, so that multi-line strings and comments are
+	// not altered
+	argument := strings.Join(append([]string{matches[4]}, block[1:]...), "\n")
+
+	switch matches[3] {
+	case "Connect":
+		var t thread
+		if m := db.connect.FindStringSubmatch(argument); m != nil {
+			t.user, t.host, t.schema = m[1], m[2], m[3]
+		}
+		db.threads[id] = t
+		return q, false
+
+	case "Init DB":
+		t := db.threads[id]
+		t.schema = strings.TrimSpace(argument)
+		db.threads[id] = t
+		return q, false
+
+	case "Quit":
+		delete(db.threads, id)
+		return q, false
+
+	case "Query", "Execute":
+		t := db.threads[id]
+		q.Time = db.lastTime
+		q.ID = id
+		q.User = t.user
+		q.Host = t.host
+		q.Schema = t.schema
+		q.Query = argument
+		return q, true
+	}
+
+	return q, false
+}
+
+// parseTime parses the time of a record, which is RFC3339 for MySQL 5.7 and
+// above, and YYMMDD HH:MM:SS for older versions and MariaDB
+func parseTime(date string) time.Time {
+	t, err := time.Parse(time.RFC3339, date)
+	if err == nil {
+		return t
+	}
+
+	t, err = time.Parse("060102 15:04:05", date)
+	if err != nil {
+		logrus.Errorf("time: error converting %s to time: %s", date, err)
+	}
+	return t
+}
+
+// ParseServerMeta parses server meta information
+func (db *Database) ParseServerMeta(lines chan []string) {
+	header := <-lines
+	versions := header[0]
+	net := header[1]
+
+	// Parse server information
+	versionre := regexp.MustCompile(`^([^,]+),\s+Version:\s+([0-9\.]+)([A-Za-z0-9-]+)\s+\((.*)\)\. started`)
+	matches := versionre.FindStringSubmatch(versions)
+
+	if len(matches) != 5 {
+		db.srv.Binary = "unable to parse line"
+		db.srv.VersionShort = db.srv.Binary
+		db.srv.Version = db.srv.Binary
+		db.srv.VersionDescription = db.srv.Binary
+		db.srv.Port = 0
+		db.srv.Socket = db.srv.Binary
+	} else {
+		db.srv.Binary = matches[1]
+		db.srv.VersionShort = matches[2]
+		db.srv.Version = db.srv.VersionShort + matches[3]
+		db.srv.VersionDescription = matches[4]
+		db.srv.Port, _ = strconv.Atoi(strings.Split(net, " ")[2])
+		db.srv.Socket = strings.TrimLeft(strings.Split(net, ":")[2], " ")
+	}
+}
+
+// GetServerMeta returns server meta information
+func (db *Database) GetServerMeta() server.Server {
+	return db.srv
+}
@@ -0,0 +1,142 @@
+package general
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/devops-works/slowql/query"
+	"github.com/devops-works/slowql/server"
+)
+
+func TestDatabase_parseQuery(t *testing.T) {
+	mysqlTime, _ := time.Parse(time.RFC3339, "2021-03-23T14:38:32.489447Z")
+	mariadbTime, _ := time.Parse("060102 15:04:05", "210323 11:31:57")
+
+	tests := []struct {
+		name     string
+		blocs    [][]string
+		refQuery query.Query
+	}{
+		{
+			name: "mysql",
+			blocs: [][]string{
+				{"2021-03-23T14:38:30.000000Z\t    9 Connect\troot@172.18.0.1 on shop using TCP/IP"},
+				{"2021-03-23T14:38:32.489447Z\t    9 Query\tSELECT 1"},
+			},
+			refQuery: query.Query{
+				Time:   mysqlTime,
+				ID:     9,
+				User:   "root",
+				Host:   "172.18.0.1",
+				Schema: "shop",
+				Query:  "SELECT 1",
+			},
+		},
+		{
+			name: "mysql init db",
+			blocs: [][]string{
+				{"2021-03-23T14:38:30.000000Z\t    9 Connect\troot@localhost on  using Socket"},
+				{"2021-03-23T14:38:31.000000Z\t    9 Init DB\tshop"},
+				{"2021-03-23T14:38:32.489447Z\t    9 Query\tSELECT col1", "FROM table1"},
+			},
+			refQuery: query.Query{
+				Time:   mysqlTime,
+				ID:     9,
+				User:   "root",
+				Host:   "localhost",
+				Schema: "shop",
+				Query:  "SELECT col1\nFROM table1",
+			},
+		},
+		{
+			name: "multi-line comment and string",
+			blocs: [][]string{
+				{"210323 11:31:57	     12 Query	SELECT 1 -- first", "FROM t WHERE s = 'a", "b'"},
+			},
+			refQuery: query.Query{
+				Time:  mariadbTime,
+				ID:    12,
+				Query: "SELECT 1 -- first\nFROM t WHERE s = 'a\nb'",
+			},
+		},
+		{
+			name: "mariadb without time",
+			blocs: [][]string{
+				{"210323 11:31:57\t     12 Connect\thugo@172.18.0.3 as anonymous on shop"},
+				{"\t\t     12 Query\tSELECT 1"},
+			},
+			refQuery: query.Query{
+				Time:   mariadbTime,
+				ID:     12,
+				User:   "hugo",
+				Host:   "172.18.0.3",
+				Schema: "shop",
+				Query:  "SELECT 1",
+			},
+		},
+		{
+			name: "unknown thread",
+			blocs: [][]string{
+				{"210323 11:31:57\t     12 Query\tSELECT 1"},
+			},
+			refQuery: query.Query{
+				Time:  mariadbTime,
+				ID:    12,
+				Query: "SELECT 1",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := New(nil)
+			var q query.Query
+			for _, bloc := range tt.blocs {
+				q, _ = db.parseQuery(bloc)
+			}
+			if !reflect.DeepEqual(q, tt.refQuery) {
+				t.Errorf("got = %v, want %v", q, tt.refQuery)
+			}
+		})
+	}
+}
+
+func TestDatabase_ParseBlocks(t *testing.T) {
+	rawBlocs := make(chan query.Block, 10)
+	qc := make(chan query.Query)
+	db := New(qc)
+
+	rawBlocs <- query.Block{Lines: []string{"2021-03-23T14:38:30.000000Z\t    9 Connect\troot@172.18.0.1 on shop using TCP/IP"}}
+	rawBlocs <- query.Block{Line: 5, Lines: []string{"2021-03-23T14:38:32.489447Z\t    9 Query\tSELECT 1"}}
+	rawBlocs <- query.Block{Lines: []string{"2021-03-23T14:38:33.000000Z\t    9 Quit\t"}}
+	close(rawBlocs)
+	go db.ParseBlocks(rawBlocs)
+
+	if q := <-db.WaitingList; q.Query != "SELECT 1" || q.User != "root" || q.Line != 5 {
+		t.Errorf("got = %v, want the query", q)
+	}
+	if q := <-db.WaitingList; !q.IsZero() {
+		t.Errorf("got = %v, want the zero query", q)
+	}
+}
+
+func TestDatabase_ParseServerMeta(t *testing.T) {
+	lines := make(chan []string, 1)
+	lines <- []string{"/usr/sbin/mysqld, Version: 8.0.23 (MySQL Community Server - GPL). started with:",
+		"Tcp port: 3306  Unix socket: /var/run/mysqld/mysqld.sock",
+		"Time                 Id Command    Argument"}
+	refSrv := server.Server{
+		Binary:             "/usr/sbin/mysqld",
+		Port:               3306,
+		Socket:             "/var/run/mysqld/mysqld.sock",
+		Version:            "8.0.23",
+		VersionShort:       "8.0.2",
+		VersionDescription: "MySQL Community Server - GPL",
+	}
+
+	db := New(nil)
+	db.ParseServerMeta(lines)
+	if db.srv != refSrv {
+		t.Errorf("got = %v, want = %v", db.srv, refSrv)
+	}
+}
@@ -1,6 +1,7 @@
 package mariadb
 
 import (
+	"reflect"
 	"testing"
 	"time"
 
@@ -81,7 +82,7 @@ func TestDatabase_parseMariaDBHeader(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			q := query.Query{}
 			db.parseMariaDBHeader(tt.args.line, &q)
-			if q != tt.refQuery {
+			if !reflect.DeepEqual(q, tt.refQuery) {
 				t.Errorf("got = %v, want %v", q, tt.refQuery)
 			}
 		})
@@ -199,14 +200,14 @@ func TestDatabase_ParseBlocs(t *testing.T) {
 		},
 	}
 	for _, tt := range tests {
-		rawBlocs := make(chan []string, 10)
+		rawBlocs := make(chan query.Block, 10)
 		qc := make(chan query.Query)
 		db := New(qc)
 		t.Run(tt.name, func(t *testing.T) {
-			rawBlocs <- tt.bloc
+			rawBlocs <- query.Block{Lines: tt.bloc}
 			go db.ParseBlocks(rawBlocs)
 			q := <-db.WaitingList
-			if q != tt.refQuery {
+			if !reflect.DeepEqual(q, tt.refQuery) {
 				t.Errorf("got = %v, want = %v", q, tt.refQuery)
 			}
 		})
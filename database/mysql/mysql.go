@@ -17,6 +17,8 @@ type Database struct {
 	ServerMeta       chan server.Server
 	stringInBrackets *regexp.Regexp
 	srv              server.Server
+	// lastTime is the time of the last query that had one
+	lastTime time.Time
 }
 
 // New instance of mysql database
@@ -30,11 +32,21 @@ func New(qc chan query.Query) *Database {
 }
 
 // ParseBlocks parses query blocks
-func (db *Database) ParseBlocks(rawBlocs chan []string) {
+func (db *Database) ParseBlocks(rawBlocs chan query.Block) {
 	for {
 		select {
 		case bloc := <-rawBlocs:
-			db.WaitingList <- db.parseQuery(bloc)
+			q := db.parseQuery(bloc.Lines)
+			q.Line = bloc.Line
+			// "# Time:" is only logged when the second changes, so the
+			// queries without it were logged at the time of the previous
+			// one. Empty blocks mark the end of the log, and stay zero
+			if q.Time.IsZero() && len(bloc.Lines) > 0 {
+				q.Time = db.lastTime
+			} else if !q.Time.IsZero() {
+				db.lastTime = q.Time
+			}
+			db.WaitingList <- q
 		}
 	}
 }
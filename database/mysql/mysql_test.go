@@ -1,6 +1,7 @@
 package mysql
 
 import (
+	"reflect"
 	"testing"
 	"time"
 
@@ -82,7 +83,7 @@ func TestDatabase_parseMySQLHeader(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			q := query.Query{}
 			db.parseMySQLHeader(tt.args.line, &q)
-			if q != tt.refQuery {
+			if !reflect.DeepEqual(q, tt.refQuery) {
 				t.Errorf("got = %v, want %v", q, tt.refQuery)
 			}
 		})
@@ -172,14 +173,14 @@ func TestDatabase_ParseBlocks(t *testing.T) {
 		},
 	}
 	for _, tt := range tests {
-		rawBlocs := make(chan []string, 10)
+		rawBlocs := make(chan query.Block, 10)
 		qc := make(chan query.Query)
 		db := New(qc)
 		t.Run(tt.name, func(t *testing.T) {
-			rawBlocs <- tt.bloc
+			rawBlocs <- query.Block{Lines: tt.bloc}
 			go db.ParseBlocks(rawBlocs)
 			q := <-db.WaitingList
-			if q != tt.refQuery {
+			if !reflect.DeepEqual(q, tt.refQuery) {
 				t.Errorf("got = %v, want = %v", q, tt.refQuery)
 			}
 		})
@@ -0,0 +1,257 @@
+package postgresql
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/devops-works/slowql/query"
+	"github.com/devops-works/slowql/query/lexer"
+	"github.com/devops-works/slowql/server"
+)
+
+// Database holds parser structure
+type Database struct {
+	WaitingList chan query.Query
+	ServerMeta  chan server.Server
+	duration    *regexp.Regexp
+	timestamp   *regexp.Regexp
+	pid         *regexp.Regexp
+	userAtDB    *regexp.Regexp
+	remote      *regexp.Regexp
+	keyValue    *regexp.Regexp
+	srv         server.Server
+}
+
+// timeLayouts are the layouts used by log_line_prefix's %t and %m escapes.
+// Fractional seconds are accepted by time.Parse even if the layout does not
+// contain them
+var timeLayouts = []string{
+	"2006-01-02 15:04:05 MST",
+	"2006-01-02 15:04:05 -07",
+}
+
+// New instance of parser
+func New(qc chan query.Query) *Database {
+	p := Database{
+		WaitingList: qc,
+		duration:    regexp.MustCompile(`LOG:  duration: ([0-9.]+) ms  (statement|execute [^:]+|parse [^:]+|bind [^:]+): (.*)$`),
+		timestamp:   regexp.MustCompile(`^(\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}(?:\.\d+)? (?:[A-Za-z]+|[+-]\d{2}))`),
+		pid:         regexp.MustCompile(`\[(\d+)\]`),
+		userAtDB:    regexp.MustCompile(`(?:^|[\s:])([\w.-]+)@([\w.-]+)(?:[\s:]|$)`),
+		remote:      regexp.MustCompile(`([\w.-]+)\(\d+\)`),
+		keyValue:    regexp.MustCompile(`\b(user|db|app|application|client|host)=([^,\s]*)`),
+	}
+
+	return &p
+}
+
+// ParseBlocks reads a log record block and adds the query it contains into a
+// channel. Records that are not statement durations are ignored
+func (db *Database) ParseBlocks(rawBlocs chan query.Block) {
+	for {
+		select {
+		case bloc := <-rawBlocs:
+			// a nil bloc means that the scanner is done, the empty query tells
+			// the reader that there is nothing left
+			if bloc.Lines == nil {
+				db.WaitingList <- query.Query{}
+				continue
+			}
+			if q, ok := db.parseQuery(bloc.Lines); ok {
+				q.Line = bloc.Line
+				db.WaitingList <- q
+			}
+		}
+	}
+}
+
+// parseQuery parses a log record made of its first line, the continuation
+// lines of a multi-line statement and the optional DETAIL record. It returns
+// false if the record does not hold a statement duration
+func (db *Database) parseQuery(block []string) (query.Query, bool) {
+	var q query.Query
+	if len(block) == 0 {
+		return q, false
+	}
+
+	matches := db.duration.FindStringSubmatch(block[0])
+	if matches == nil {
+		return q, false
+	}
+
+	// with the extended query protocol, the duration of each step is logged.
+	// Only the execution is kept, otherwise the statement would be counted
+	// three times
+	kind := matches[2]
+	if strings.HasPrefix(kind, "parse ") || strings.HasPrefix(kind, "bind ") {
+		return q, false
+	}
+
+	ms, err := strconv.ParseFloat(matches[1], 64)
+	if err != nil {
+		logrus.Errorf("duration: error converting %s to float: %s", matches[1], err)
+	}
+	q.QueryTime = ms / 1000
+
+	db.parsePrefix(block[0][:strings.Index(block[0], "LOG:  duration:")], &q)
+
+	q.Query = matches[3]
+	q.Dialect = lexer.ANSI
+	var params map[string]string
+	for _, line := range block[1:] {
+		if strings.HasPrefix(line, "\t") {
+			q.Query = q.Query + "\n" + strings.TrimPrefix(line, "\t")
+			continue
+		}
+
+		if idx := strings.Index(line, "DETAIL:  parameters: "); idx >= 0 {
+			params = parseParameters(line[idx+len("DETAIL:  parameters: "):])
+		}
+	}
+
+	if params != nil {
+		q.Query = bindParameters(q.Query, params)
+	}
+
+	return q, true
+}
+
+// parsePrefix extracts what it can from the log_line_prefix. Since the prefix
+// is free-form, only the most common escapes are recognised: the timestamp
+// (%t, %m) at the beginning of the line, the process ID (%p) between square
+// brackets, %u@%d, the remote host and port (%r) and key=value pairs for the
+// user, database, application and client host
+func (db *Database) parsePrefix(prefix string, q *query.Query) {
+	var err error
+
+	if m := db.timestamp.FindStringSubmatch(prefix); m != nil {
+		for _, layout := range timeLayouts {
+			q.Time, err = time.Parse(layout, m[1])
+			if err == nil {
+				break
+			}
+		}
+		if err != nil {
+			logrus.Errorf("time: error converting %s to time: %s", m[1], err)
+		}
+		prefix = prefix[len(m[1]):]
+	}
+
+	if m := db.pid.FindStringSubmatch(prefix); m != nil {
+		q.ID, err = strconv.Atoi(m[1])
+		if err != nil {
+			logrus.Errorf("pid: error converting %s to int: %s", m[1], err)
+		}
+	}
+
+	if m := db.userAtDB.FindStringSubmatch(prefix); m != nil {
+		q.User = m[1]
+		q.Schema = m[2]
+	}
+
+	if m := db.remote.FindStringSubmatch(prefix); m != nil {
+		q.Host = m[1]
+	}
+
+	for _, m := range db.keyValue.FindAllStringSubmatch(prefix, -1) {
+		switch m[1] {
+		case "user":
+			q.User = m[2]
+		case "db":
+			q.Schema = m[2]
+		case "client", "host":
+			// %r adds the port between parenthesis
+			if idx := strings.Index(m[2], "("); idx >= 0 {
+				m[2] = m[2][:idx]
+			}
+			q.Host = m[2]
+		case "app", "application":
+			if q.Extra == nil {
+				q.Extra = make(map[string]string)
+			}
+			q.Extra["application"] = m[2]
+		}
+	}
+}
+
+// parseParameters parses the DETAIL line of an extended query protocol
+// execution, such as: $1 = '42', $2 = NULL, $3 = 'foo, bar'. Quotes inside
+// values are escaped by doubling them
+func parseParameters(line string) map[string]string {
+	params := make(map[string]string)
+	for len(line) > 0 {
+		eq := strings.Index(line, " = ")
+		if eq < 0 {
+			break
+		}
+		name := strings.TrimSpace(line[:eq])
+		line = line[eq+3:]
+
+		var value string
+		if strings.HasPrefix(line, "'") {
+			end := 1
+			for end < len(line) {
+				if line[end] == '\'' {
+					if end+1 < len(line) && line[end+1] == '\'' {
+						end += 2
+						continue
+					}
+					break
+				}
+				end++
+			}
+			if end >= len(line) {
+				end = len(line) - 1
+			}
+			value = line[:end+1]
+			line = line[end+1:]
+		} else {
+			end := strings.Index(line, ", $")
+			if end < 0 {
+				end = len(line)
+			}
+			value = line[:end]
+			line = line[end:]
+		}
+		params[name] = value
+		line = strings.TrimPrefix(line, ", ")
+	}
+	return params
+}
+
+// bindParameters replaces the $n placeholders of a statement with their values
+func bindParameters(stmt string, params map[string]string) string {
+	var b strings.Builder
+	for i := 0; i < len(stmt); i++ {
+		if stmt[i] != '$' {
+			b.WriteByte(stmt[i])
+			continue
+		}
+		j := i + 1
+		for j < len(stmt) && stmt[j] >= '0' && stmt[j] <= '9' {
+			j++
+		}
+		if value, ok := params[stmt[i:j]]; ok && j > i+1 {
+			b.WriteString(value)
+			i = j - 1
+			continue
+		}
+		b.WriteByte(stmt[i])
+	}
+	return b.String()
+}
+
+// ParseServerMeta reads slowquerylog metadata. PostgreSQL logs do not start
+// with a banner, so there is nothing to parse
+func (db *Database) ParseServerMeta(lines chan []string) {
+	<-lines
+}
+
+// GetServerMeta returns server meta information
+func (db *Database) GetServerMeta() server.Server {
+	return db.srv
+}
@@ -0,0 +1,164 @@
+package postgresql
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/devops-works/slowql/query"
+	"github.com/devops-works/slowql/query/lexer"
+)
+
+// parseTime is a helper function that allow us to cast a string into a time.Time
+// value in the tests
+func parseTime(t string) time.Time {
+	time, _ := time.Parse("2006-01-02 15:04:05 MST", t)
+	return time
+}
+
+func TestDatabase_parseQuery(t *testing.T) {
+	tests := []struct {
+		name     string
+		bloc     []string
+		refQuery query.Query
+		wantOk   bool
+	}{
+		{
+			name: "default prefix",
+			bloc: []string{
+				"2021-03-23 11:31:57.123 UTC [1234] LOG:  duration: 1503.123 ms  statement: SELECT pg_sleep(1.5);",
+			},
+			refQuery: query.Query{
+				Time:      parseTime("2021-03-23 11:31:57.123 UTC"),
+				ID:        1234,
+				QueryTime: 1.503123,
+				Query:     "SELECT pg_sleep(1.5);",
+				Dialect:   lexer.ANSI,
+			},
+			wantOk: true,
+		},
+		{
+			name: "key value prefix",
+			bloc: []string{
+				"2021-03-23 11:31:57 UTC [1234]: [3-1] user=app,db=shop,app=psql,client=10.0.0.1 LOG:  duration: 12.000 ms  statement: SELECT 1",
+			},
+			refQuery: query.Query{
+				Time:      parseTime("2021-03-23 11:31:57 UTC"),
+				ID:        1234,
+				QueryTime: 0.012,
+				User:      "app",
+				Schema:    "shop",
+				Host:      "10.0.0.1",
+				Query:     "SELECT 1",
+				Dialect:   lexer.ANSI,
+				Extra:     map[string]string{"application": "psql"},
+			},
+			wantOk: true,
+		},
+		{
+			name: "rds prefix",
+			bloc: []string{
+				"2021-03-23 11:31:57 UTC:10.0.0.1(5432):app@shop:[1234]:LOG:  duration: 1.000 ms  statement: SELECT 1",
+			},
+			refQuery: query.Query{
+				Time:      parseTime("2021-03-23 11:31:57 UTC"),
+				ID:        1234,
+				QueryTime: 0.001,
+				User:      "app",
+				Schema:    "shop",
+				Host:      "10.0.0.1",
+				Query:     "SELECT 1",
+				Dialect:   lexer.ANSI,
+			},
+			wantOk: true,
+		},
+		{
+			name: "multi-line statement",
+			bloc: []string{
+				"2021-03-23 11:31:57 UTC [1234] LOG:  duration: 1.000 ms  statement: SELECT col1",
+				"\tFROM table1",
+				"\tWHERE col2 = 3;",
+			},
+			refQuery: query.Query{
+				Time:      parseTime("2021-03-23 11:31:57 UTC"),
+				ID:        1234,
+				QueryTime: 0.001,
+				Query:     "SELECT col1\nFROM table1\nWHERE col2 = 3;",
+				Dialect:   lexer.ANSI,
+			},
+			wantOk: true,
+		},
+		{
+			name: "execute with parameters",
+			bloc: []string{
+				"2021-03-23 11:31:57 UTC [1234] LOG:  duration: 2.000 ms  execute <unnamed>: SELECT * FROM users WHERE id = $1 AND name = $2 AND team = $10",
+				"2021-03-23 11:31:57 UTC [1234] DETAIL:  parameters: $1 = '42', $2 = 'O''Brien, Pat', $10 = NULL",
+			},
+			refQuery: query.Query{
+				Time:      parseTime("2021-03-23 11:31:57 UTC"),
+				ID:        1234,
+				QueryTime: 0.002,
+				Query:     "SELECT * FROM users WHERE id = '42' AND name = 'O''Brien, Pat' AND team = NULL",
+				Dialect:   lexer.ANSI,
+			},
+			wantOk: true,
+		},
+		{
+			name: "bind step is ignored",
+			bloc: []string{
+				"2021-03-23 11:31:57 UTC [1234] LOG:  duration: 0.100 ms  bind <unnamed>: SELECT $1",
+				"2021-03-23 11:31:57 UTC [1234] DETAIL:  parameters: $1 = '42'",
+			},
+			wantOk: false,
+		},
+		{
+			name: "not a statement",
+			bloc: []string{
+				"2021-03-23 11:31:57 UTC [1234] LOG:  checkpoint starting: time",
+			},
+			wantOk: false,
+		},
+	}
+	for _, tt := range tests {
+		db := New(nil)
+		t.Run(tt.name, func(t *testing.T) {
+			q, ok := db.parseQuery(tt.bloc)
+			if ok != tt.wantOk {
+				t.Errorf("got ok = %v, want %v", ok, tt.wantOk)
+			}
+			if !reflect.DeepEqual(q, tt.refQuery) {
+				t.Errorf("got = %v, want %v", q, tt.refQuery)
+			}
+		})
+	}
+}
+
+func TestDatabase_ParseBlocks(t *testing.T) {
+	rawBlocs := make(chan query.Block, 10)
+	qc := make(chan query.Query)
+	db := New(qc)
+
+	rawBlocs <- query.Block{Lines: []string{"2021-03-23 11:31:57 UTC [1234] LOG:  checkpoint starting: time"}}
+	rawBlocs <- query.Block{Line: 2, Lines: []string{"2021-03-23 11:31:57 UTC [1234] LOG:  duration: 1.000 ms  statement: SELECT 1"}}
+	close(rawBlocs)
+	go db.ParseBlocks(rawBlocs)
+
+	if q := <-db.WaitingList; q.Query != "SELECT 1" || q.Line != 2 {
+		t.Errorf("got = %v, want the statement", q)
+	}
+	if q := <-db.WaitingList; !q.IsZero() {
+		t.Errorf("got = %v, want the zero query", q)
+	}
+}
+
+func BenchmarkParseBlocks(b *testing.B) {
+	blocks := []string{
+		"2021-03-23 11:31:57 UTC [1234]: [3-1] user=app,db=shop,app=psql,client=10.0.0.1 LOG:  duration: 12.000 ms  statement: SELECT col1 AS c1",
+		"\tFROM table1 AS t1;",
+	}
+	db := New(nil)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		db.parseQuery(blocks)
+	}
+}
@@ -0,0 +1,166 @@
+package tidb
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/devops-works/slowql/query"
+	"github.com/devops-works/slowql/server"
+)
+
+// Database holds parser structure
+type Database struct {
+	WaitingList      chan query.Query
+	ServerMeta       chan server.Server
+	stringInBrackets *regexp.Regexp
+	srv              server.Server
+}
+
+// wholeLine lists the headers whose value is the rest of the line, since it
+// can contain spaces or colons
+var wholeLine = map[string]bool{
+	"User@Host":   true,
+	"Stats":       true,
+	"Plan":        true,
+	"Binary_plan": true,
+	"Prev_stmt":   true,
+	"Index_names": true,
+	"Warnings":    true,
+}
+
+// New instance of parser
+func New(qc chan query.Query) *Database {
+	p := Database{
+		WaitingList:      qc,
+		stringInBrackets: regexp.MustCompile(`\[(.*?)\]`),
+	}
+
+	return &p
+}
+
+// ParseBlocks reads a query block and adds it into a channel
+func (db *Database) ParseBlocks(rawBlocs chan query.Block) {
+	for {
+		select {
+		case bloc := <-rawBlocs:
+			q := db.parseQuery(bloc.Lines)
+			q.Line = bloc.Line
+			db.WaitingList <- q
+		}
+	}
+}
+
+func (db *Database) parseQuery(block []string) query.Query {
+	var q query.Query
+	for _, line := range block {
+		if line[0] == '#' {
+			db.parseTiDBHeader(line, &q)
+			continue
+		}
+
+		// TiDB writes the current database before each statement, it is not
+		// part of the query and is already known from the DB header
+		if q.Query == "" && strings.HasPrefix(strings.ToLower(line), "use ") && strings.HasSuffix(line, ";") {
+			continue
+		}
+
+		if strings.HasSuffix(q.Query, ";") || q.Query == "" {
+			q.Query = q.Query + line
+		} else {
+			q.Query = q.Query + " " + line
+		}
+	}
+	return q
+}
+
+// parseTiDBHeader parses a header line, which holds one or several `Key: value`
+// pairs. Pairs that do not map onto a query.Query field are kept in Extra
+func (db *Database) parseTiDBHeader(line string, q *query.Query) {
+	parts := strings.Fields(strings.TrimPrefix(line, "#"))
+
+	for idx := 0; idx < len(parts); idx++ {
+		if !strings.HasSuffix(parts[idx], ":") {
+			continue
+		}
+		key := strings.TrimSuffix(parts[idx], ":")
+
+		// the value goes on until the next key
+		end := idx + 1
+		if wholeLine[key] {
+			end = len(parts)
+		} else {
+			for end < len(parts) && !strings.HasSuffix(parts[end], ":") {
+				end++
+			}
+		}
+		value := strings.Join(parts[idx+1:end], " ")
+		idx = end - 1
+
+		db.setAttribute(key, value, q)
+	}
+}
+
+func (db *Database) setAttribute(key, value string, q *query.Query) {
+	var err error
+
+	switch key {
+	case "Time":
+		q.Time, err = time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			logrus.Errorf("time: error converting %s to time: %s", value, err)
+		}
+
+	case "Query_time":
+		q.QueryTime, err = strconv.ParseFloat(value, 64)
+		if err != nil {
+			logrus.Errorf("query_time: error converting %s to time: %s", value, err)
+		}
+
+	case "Conn_ID":
+		q.ID, err = strconv.Atoi(value)
+		if err != nil {
+			logrus.Errorf("conn_id: error converting %s to int: %s", value, err)
+		}
+
+	case "Result_rows":
+		q.RowsSent, err = strconv.Atoi(value)
+		if err != nil {
+			logrus.Errorf("result_rows: error converting %s to int: %s", value, err)
+		}
+
+	case "DB":
+		q.Schema = value
+
+	case "User@Host":
+		items := db.stringInBrackets.FindAllString(value, -1)
+		// We remove first and last bytes of the strings because they are
+		// square brackets
+		if len(items) > 0 {
+			q.User = items[0][1 : len(items[0])-1]
+		}
+		if len(items) > 1 {
+			q.Host = items[1][1 : len(items[1])-1]
+		}
+
+	default:
+		if q.Extra == nil {
+			q.Extra = make(map[string]string)
+		}
+		q.Extra[key] = value
+	}
+}
+
+// ParseServerMeta reads slowquerylog metadata. TiDB slow logs do not start
+// with a banner, so there is nothing to parse
+func (db *Database) ParseServerMeta(lines chan []string) {
+	<-lines
+}
+
+// GetServerMeta returns server meta information
+func (db *Database) GetServerMeta() server.Server {
+	return db.srv
+}
@@ -0,0 +1,184 @@
+package tidb
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/devops-works/slowql/query"
+)
+
+// parseTime is a helper function that allow us to cast a string into a time.Time
+// value in the tests
+func parseTime(t string) time.Time {
+	time, _ := time.Parse(time.RFC3339Nano, t)
+	return time
+}
+
+func TestDatabase_parseTiDBHeader(t *testing.T) {
+	type args struct {
+		line string
+	}
+	tests := []struct {
+		name     string
+		args     args
+		refQuery query.Query
+	}{
+		{
+			name: "time",
+			args: args{
+				line: "# Time: 2019-08-14T09:26:59.487776265+08:00",
+			},
+			refQuery: query.Query{
+				Time: parseTime("2019-08-14T09:26:59.487776265+08:00"),
+			},
+		},
+		{
+			name: "user, host",
+			args: args{
+				line: "# User@Host: root[root] @ localhost [127.0.0.1]",
+			},
+			refQuery: query.Query{
+				User: "root",
+				Host: "127.0.0.1",
+			},
+		},
+		{
+			name: "conn id",
+			args: args{
+				line: "# Conn_ID: 3086",
+			},
+			refQuery: query.Query{
+				ID: 3086,
+			},
+		},
+		{
+			name: "query time",
+			args: args{
+				line: "# Query_time: 1.527627037",
+			},
+			refQuery: query.Query{
+				QueryTime: 1.527627037,
+			},
+		},
+		{
+			name: "schema",
+			args: args{
+				line: "# DB: test",
+			},
+			refQuery: query.Query{
+				Schema: "test",
+			},
+		},
+		{
+			name: "several attributes",
+			args: args{
+				line: "# Process_time: 0.07 Wait_time: 0.002 Backoff_time: 0.002 Request_count: 1 Cop_proc_addr: 172.16.5.87:20171",
+			},
+			refQuery: query.Query{
+				Extra: map[string]string{
+					"Process_time":  "0.07",
+					"Wait_time":     "0.002",
+					"Backoff_time":  "0.002",
+					"Request_count": "1",
+					"Cop_proc_addr": "172.16.5.87:20171",
+				},
+			},
+		},
+		{
+			name: "whole line",
+			args: args{
+				line: "# Stats: t:pseudo",
+			},
+			refQuery: query.Query{
+				Extra: map[string]string{"Stats": "t:pseudo"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		db := New(nil)
+		t.Run(tt.name, func(t *testing.T) {
+			q := query.Query{}
+			db.parseTiDBHeader(tt.args.line, &q)
+			if !reflect.DeepEqual(q, tt.refQuery) {
+				t.Errorf("got = %v, want %v", q, tt.refQuery)
+			}
+		})
+	}
+}
+
+func TestDatabase_ParseBlocks(t *testing.T) {
+	tests := []struct {
+		name     string
+		bloc     []string
+		refQuery query.Query
+	}{
+		{
+			name: "testing",
+			bloc: []string{
+				"# Time: 2019-08-14T09:26:59.487776265+08:00",
+				"# Txn_start_ts: 410450924122144769",
+				"# User@Host: root[root] @ localhost [127.0.0.1]",
+				"# Conn_ID: 3086",
+				"# Query_time: 1.527627037",
+				"# Cop_time: 0.072 Process_time: 0.07 Wait_time: 0.002 Total_keys: 131073",
+				"# DB: test",
+				"# Mem_max: 525211",
+				"# Result_rows: 12",
+				"# Succ: true",
+				"# Plan_digest: e5f9d9746c756438a13c75ba3eedf601eecf555cdb7ad327d7092bdd041a83e7",
+				"# Plan: tidb_decode_plan('ZJAwCTMyXzcJMAkyMAlkYXRhOlRhYmxlU2Nh')",
+				"use test;",
+				"select * from t",
+				"where a = 1;",
+			},
+			refQuery: query.Query{
+				Time:      parseTime("2019-08-14T09:26:59.487776265+08:00"),
+				User:      "root",
+				Host:      "127.0.0.1",
+				ID:        3086,
+				Schema:    "test",
+				QueryTime: 1.527627037,
+				RowsSent:  12,
+				Query:     "select * from t where a = 1;",
+				Extra: map[string]string{
+					"Txn_start_ts": "410450924122144769",
+					"Cop_time":     "0.072",
+					"Process_time": "0.07",
+					"Wait_time":    "0.002",
+					"Total_keys":   "131073",
+					"Mem_max":      "525211",
+					"Succ":         "true",
+					"Plan_digest":  "e5f9d9746c756438a13c75ba3eedf601eecf555cdb7ad327d7092bdd041a83e7",
+					"Plan":         "tidb_decode_plan('ZJAwCTMyXzcJMAkyMAlkYXRhOlRhYmxlU2Nh')",
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		rawBlocs := make(chan query.Block, 10)
+		qc := make(chan query.Query)
+		db := New(qc)
+		t.Run(tt.name, func(t *testing.T) {
+			rawBlocs <- query.Block{Lines: tt.bloc}
+			go db.ParseBlocks(rawBlocs)
+			q := <-db.WaitingList
+			if !reflect.DeepEqual(q, tt.refQuery) {
+				t.Errorf("got = %v, want = %v", q, tt.refQuery)
+			}
+		})
+	}
+}
+
+func BenchmarkParseBlocks(b *testing.B) {
+	blocks := []string{
+		"# Process_time: 0.07 Wait_time: 0.002 Backoff_time: 0.002 Request_count: 1",
+		`SELECT col1 AS c1`,
+		`FROM table1 AS t1;`,
+	}
+	db := New(nil)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		db.parseQuery(blocks)
+	}
+}
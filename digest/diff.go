@@ -0,0 +1,181 @@
+package digest
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// ChangeKind tells how an entry changed between two digests
+type ChangeKind int
+
+const (
+	// Regressed entries have a higher call rate, or a higher mean or
+	// percentile of the query time
+	Regressed ChangeKind = iota
+	// New entries are only in the second digest
+	New
+	// Improved entries have a lower call rate, or a lower mean or percentile
+	// of the query time, and did not regress otherwise
+	Improved
+	// Gone entries are only in the first digest
+	Gone
+)
+
+// ChangeKinds lists the kinds of changes, in the order Diff returns them
+var ChangeKinds = []ChangeKind{Regressed, New, Improved, Gone}
+
+func (k ChangeKind) String() string {
+	switch k {
+	case Regressed:
+		return "regressed"
+	case New:
+		return "new"
+	case Improved:
+		return "improved"
+	case Gone:
+		return "gone"
+	}
+	return "unknown"
+}
+
+// Change is an entry that changed between two digests. Before is the zero
+// Entry for new entries, and After for gone ones
+type Change struct {
+	Kind   ChangeKind
+	Before Entry
+	After  Entry
+	// Calls, Mean and Percentile are the relative changes of the call rate,
+	// the mean and the percentile of the query time, such as 0.5 for +50%.
+	// They are only set for regressed and improved entries, and are zero when
+	// the change is not significant
+	Calls      float64
+	Mean       float64
+	Percentile float64
+}
+
+// Entry returns the entry of the change in the second digest, or in the
+// first one for gone entries
+func (c Change) Entry() Entry {
+	if c.Kind == Gone {
+		return c.Before
+	}
+	return c.After
+}
+
+// DiffOptions tells Diff what a significant change is
+type DiffOptions struct {
+	// Percentile is the percentile of the query time that is compared, such
+	// as 95. Both digests must be computed with it
+	Percentile float64
+	// Threshold is the relative change above which an entry changed, such as
+	// 0.2 for 20%
+	Threshold float64
+	// Before and After are the durations of the logs of the digests, so that
+	// logs of different lengths are compared by call rate. Calls are compared
+	// as is when one of them is zero
+	Before, After time.Duration
+	// MinCalls is the number of calls below which a change of the call rate
+	// is not significant, unless the entry has that many calls in one of the
+	// digests
+	MinCalls int
+	// MinDelta is the change of the mean or percentile of the query time, in
+	// seconds, below which it is not significant
+	MinDelta float64
+}
+
+// Diff matches the entries of two digests by hash, and returns the new and
+// gone entries, and the ones whose call rate, mean or percentile of the query
+// time changed significantly. Both digests must be grouped the same way.
+// Changes are sorted by kind, then by query time, the most first
+func Diff(before, after []Entry, o DiffOptions) []Change {
+	name := PercentileName(o.Percentile)
+	// the calls of the logs of different lengths are compared per second
+	scale := 1.0
+	if o.Before > 0 && o.After > 0 {
+		scale = o.Before.Seconds() / o.After.Seconds()
+	}
+	prev := make(map[string]Entry, len(before))
+	for _, e := range before {
+		prev[e.Hash] = e
+	}
+
+	var changes []Change
+	for _, a := range after {
+		b, ok := prev[a.Hash]
+		if !ok {
+			changes = append(changes, Change{Kind: New, After: a})
+			continue
+		}
+		delete(prev, a.Hash)
+
+		c := Change{Before: b, After: a}
+		if b.Calls >= o.MinCalls || a.Calls >= o.MinCalls {
+			c.Calls = relative(float64(b.Calls), float64(a.Calls)*scale)
+		}
+		if math.Abs(a.MeanTime-b.MeanTime) >= o.MinDelta {
+			c.Mean = relative(b.MeanTime, a.MeanTime)
+		}
+		if math.Abs(a.Percentiles[name]-b.Percentiles[name]) >= o.MinDelta {
+			c.Percentile = relative(b.Percentiles[name], a.Percentiles[name])
+		}
+		deltas := []float64{c.Calls, c.Mean, c.Percentile}
+		switch {
+		case anyAbove(deltas, o.Threshold):
+			c.Kind = Regressed
+		case anyAbove(negate(deltas), o.Threshold):
+			c.Kind = Improved
+		default:
+			continue
+		}
+		changes = append(changes, c)
+	}
+	for _, b := range before {
+		if _, ok := prev[b.Hash]; ok {
+			changes = append(changes, Change{Kind: Gone, Before: b})
+		}
+	}
+
+	sort.SliceStable(changes, func(i, j int) bool {
+		if changes[i].Kind != changes[j].Kind {
+			return changes[i].Kind < changes[j].Kind
+		}
+		ei, ej := changes[i].Entry(), changes[j].Entry()
+		if ei.CumQueryTime != ej.CumQueryTime {
+			return ei.CumQueryTime > ej.CumQueryTime
+		}
+		return ei.Hash < ej.Hash
+	})
+	return changes
+}
+
+// relative returns the relative change from before to after. A change from
+// zero is infinite
+func relative(before, after float64) float64 {
+	switch {
+	case before == after:
+		return 0
+	case before == 0:
+		return math.Inf(1)
+	}
+	return (after - before) / before
+}
+
+// anyAbove tells if any of the values is above threshold
+func anyAbove(values []float64, threshold float64) bool {
+	for _, v := range values {
+		if v > threshold {
+			return true
+		}
+	}
+	return false
+}
+
+// negate returns the opposites of values
+func negate(values []float64) []float64 {
+	negated := make([]float64, len(values))
+	for i, v := range values {
+		negated[i] = -v
+	}
+	return negated
+}
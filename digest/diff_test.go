@@ -0,0 +1,144 @@
+package digest
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestDiff(t *testing.T) {
+	entry := func(hash string, calls int, mean, p95 float64) Entry {
+		return Entry{Hash: hash, Calls: calls, CumQueryTime: float64(calls) * mean, MeanTime: mean,
+			Percentiles: map[string]float64{"p95": p95}}
+	}
+	before := []Entry{
+		entry("same", 100, 1, 2),
+		entry("calls", 100, 1, 2),
+		entry("mean", 100, 1, 2),
+		entry("p95", 100, 1, 2),
+		entry("faster", 100, 1, 2),
+		entry("mixed", 100, 1, 2),
+		entry("below threshold", 100, 1, 2),
+		entry("gone", 10, 1, 2),
+		entry("tiny", 100, 0.000002, 0.000002),
+		entry("rare", 1, 1, 2),
+	}
+	after := []Entry{
+		entry("same", 100, 1, 2),
+		entry("calls", 150, 1, 2),
+		entry("mean", 100, 1.5, 2),
+		entry("p95", 100, 1, 4),
+		entry("faster", 100, 0.5, 1),
+		entry("mixed", 200, 0.5, 1),
+		entry("below threshold", 110, 1.1, 2.2),
+		entry("new", 10, 3, 4),
+		entry("tiny", 100, 0.000003, 0.000003),
+		entry("rare", 2, 1, 2),
+	}
+
+	changes := Diff(before, after, DiffOptions{Percentile: 95, Threshold: 0.2, MinCalls: 10, MinDelta: 0.001})
+	got := make(map[string]Change)
+	var order []string
+	for _, c := range changes {
+		got[c.Entry().Hash] = c
+		order = append(order, c.Entry().Hash)
+	}
+
+	tests := []struct {
+		hash    string
+		changed bool
+		kind    ChangeKind
+		delta   float64
+	}{
+		{hash: "same"},
+		{hash: "below threshold"},
+		{hash: "tiny"},
+		{hash: "rare"},
+		{hash: "calls", changed: true, kind: Regressed, delta: 0.5},
+		{hash: "mean", changed: true, kind: Regressed, delta: 0.5},
+		{hash: "p95", changed: true, kind: Regressed, delta: 1},
+		{hash: "mixed", changed: true, kind: Regressed, delta: 1},
+		{hash: "faster", changed: true, kind: Improved, delta: -0.5},
+		{hash: "new", changed: true, kind: New},
+		{hash: "gone", changed: true, kind: Gone},
+	}
+	for _, tt := range tests {
+		t.Run(tt.hash, func(t *testing.T) {
+			c, ok := got[tt.hash]
+			if ok != tt.changed {
+				t.Fatalf("Diff() has %s = %v, want %v", tt.hash, ok, tt.changed)
+			}
+			if !ok {
+				return
+			}
+			if c.Kind != tt.kind {
+				t.Errorf("Diff() %s kind = %s, want %s", tt.hash, c.Kind, tt.kind)
+			}
+			delta := math.Max(math.Max(c.Calls, c.Mean), c.Percentile)
+			if tt.delta < 0 {
+				delta = math.Min(math.Min(c.Calls, c.Mean), c.Percentile)
+			}
+			if math.Abs(delta-tt.delta) > 1e-9 {
+				t.Errorf("Diff() %s = %+v, want a change of %v", tt.hash, c, tt.delta)
+			}
+		})
+	}
+
+	// regressions come first, the most query time first, ties by hash
+	want := []string{"calls", "mean", "mixed", "p95", "new", "faster", "gone"}
+	for i := range want {
+		if i >= len(order) || order[i] != want[i] {
+			t.Fatalf("Diff() order = %v, want %v", order, want)
+		}
+	}
+}
+
+func TestDiff_rate(t *testing.T) {
+	entry := func(calls int) Entry {
+		return Entry{Hash: "q", Calls: calls, CumQueryTime: float64(calls), MeanTime: 1}
+	}
+	tests := []struct {
+		name          string
+		before, after time.Duration
+		calls         int
+		want          float64
+		changed       bool
+	}{
+		{name: "twice as long", before: time.Hour, after: 2 * time.Hour, calls: 200},
+		{name: "twice the rate", before: time.Hour, after: 2 * time.Hour, calls: 400, want: 1, changed: true},
+		{name: "unknown durations", calls: 200, want: 1, changed: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			changes := Diff([]Entry{entry(100)}, []Entry{entry(tt.calls)},
+				DiffOptions{Percentile: 95, Threshold: 0.2, Before: tt.before, After: tt.after})
+			if len(changes) == 1 != tt.changed {
+				t.Fatalf("Diff() = %+v, want changed %v", changes, tt.changed)
+			}
+			if tt.changed && math.Abs(changes[0].Calls-tt.want) > 1e-9 {
+				t.Errorf("Diff() calls = %v, want %v", changes[0].Calls, tt.want)
+			}
+		})
+	}
+}
+
+func Test_relative(t *testing.T) {
+	tests := []struct {
+		name          string
+		before, after float64
+		want          float64
+	}{
+		{name: "unchanged", before: 2, after: 2, want: 0},
+		{name: "doubled", before: 2, after: 4, want: 1},
+		{name: "halved", before: 2, after: 1, want: -0.5},
+		{name: "from zero", before: 0, after: 1, want: math.Inf(1)},
+		{name: "both zero", before: 0, after: 0, want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := relative(tt.before, tt.after); got != tt.want {
+				t.Errorf("relative() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,705 @@
+// Package digest aggregates slow queries, as slowql-digest does: queries are
+// grouped by fingerprint, table, user, host, schema or any combination of
+// them, and each entry has the sums of its metrics and their distributions.
+//
+// An Aggregator is not safe for concurrent use: give each goroutine its own
+// and merge them once done. Aggregators can also be saved as JSON, to merge
+// the aggregators of several hosts.
+//
+// Diff compares two digests, to find the entries that are new, gone, or
+// whose query time changed.
+package digest
+
+import (
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/devops-works/slowql/fingerprint"
+	"github.com/devops-works/slowql/query"
+	"github.com/devops-works/slowql/query/structure"
+	"github.com/devops-works/slowql/sketch"
+)
+
+// GroupBys lists the dimensions queries can be grouped by. Queries can be
+// grouped by several of them, such as fingerprint,user
+var GroupBys = []string{"fingerprint", "table", "user", "host", "schema"}
+
+// Metrics lists the attributes of the queries, besides the query time, whose
+// distribution is kept
+var Metrics = []string{"lock_time", "rows_sent", "rows_examined", "bytes_sent"}
+
+// DefaultSamples is the number of queries sampled in each entry, besides the
+// slowest one, unless set otherwise with SetSamples
+const DefaultSamples = 5
+
+// NoTable is the name of the entry holding the queries that reference no
+// table when grouping by table
+const NoTable = "(no table)"
+
+// Entry is the aggregation of the queries sharing the values of the grouped
+// dimensions: a fingerprint, a table, a user, a host, a schema or a
+// combination of them. The fields of the dimensions that are not grouped are
+// empty, except Schema, which then is the schema of the first query.
+// MeanTime, StddevTime, Concurrency, Percentiles and Distributions are only
+// set by Compute
+type Entry struct {
+	Hash            string
+	Fingerprint     string
+	Table           string
+	User            string
+	Host            string
+	Schema          string
+	Calls           int
+	CumErrored      int
+	CumKilled       int
+	CumQueryTime    float64
+	CumLockTime     float64
+	CumRowsSent     int
+	CumRowsExamined int
+	CumBytesSent    int
+	Concurrency     float64
+	MinTime         float64
+	MaxTime         float64
+	MeanTime        float64
+	StddevTime      float64
+	// Percentiles are the percentiles of the query time, by name (p50, p99.9)
+	Percentiles map[string]float64
+	// Sketch is the distribution of the query time
+	Sketch *sketch.Sketch
+	// Sketches are the distributions of the other metrics, by name
+	// (lock_time, rows_sent...)
+	Sketches map[string]*sketch.Sketch
+	// Distributions summarize the distributions of the other metrics
+	Distributions map[string]Distribution
+	// Slowest is the slowest query
+	Slowest Sample
+	// Samples are queries picked at random among the queries of the entry,
+	// each query having the same chance to be picked
+	Samples []Sample
+	// Users and Hosts are the load of each user and host, by name, when
+	// queries are not grouped by user or host. Use Top to get the main ones
+	Users map[string]Load
+	Hosts map[string]Load
+	// Buckets are the queries of each interval of time, by Unix time of its
+	// start, when the aggregator has a bucket width. Use Series to get them
+	// in order
+	Buckets map[int64]Bucket
+}
+
+// Sample is a query of an entry, as it was logged
+type Sample struct {
+	Query     string
+	Time      time.Time
+	QueryTime float64
+	User      string
+	Host      string
+	Schema    string
+	// Line is the line of the log the query starts at, counting from 1. It is
+	// 0 when unknown
+	Line int
+}
+
+// Load is the load of a class of queries (read, write...), or of a minute
+type Load struct {
+	Calls        int
+	CumQueryTime float64
+}
+
+// Totals are the totals of the aggregated queries. Unlike the sums of the
+// entries, they account for each query once when grouping by table
+type Totals struct {
+	Calls           int
+	CumQueryTime    float64
+	CumLockTime     float64
+	CumRowsSent     int
+	CumRowsExamined int
+	CumBytesSent    int
+	CumKilled       int
+}
+
+// Aggregator aggregates queries
+type Aggregator struct {
+	groupBy    string
+	dimensions []string
+	entries    map[string]Entry
+	load       map[string]Load
+	timeline   map[int64]Load
+	totals     Totals
+	// samples is the number of queries sampled in each entry
+	samples int
+	rand    *rand.Rand
+	// bucket is the width of the intervals of time queries are bucketed by,
+	// or 0 if they are not
+	bucket  time.Duration
+	buckets map[int64]Bucket
+	// first and last are the times of the first and last bucketed queries
+	first, last time.Time
+}
+
+// ParseGroupBy parses comma separated dimensions, such as user,fingerprint,
+// and returns them in the order of GroupBys
+func ParseGroupBy(groupBy string) ([]string, error) {
+	grouped := make(map[string]bool)
+	for _, d := range strings.Split(groupBy, ",") {
+		d = strings.TrimSpace(d)
+		if !stringInSlice(d, GroupBys) {
+			return nil, errors.New("unknown grouping: " + d)
+		}
+		grouped[d] = true
+	}
+
+	var dimensions []string
+	for _, d := range GroupBys {
+		if grouped[d] {
+			dimensions = append(dimensions, d)
+		}
+	}
+	return dimensions, nil
+}
+
+// NewAggregator returns an empty aggregator grouping queries by the comma
+// separated dimensions of groupBy, such as fingerprint or user,host
+func NewAggregator(groupBy string) (*Aggregator, error) {
+	dimensions, err := ParseGroupBy(groupBy)
+	if err != nil {
+		return nil, err
+	}
+	return &Aggregator{
+		groupBy:    strings.Join(dimensions, ","),
+		dimensions: dimensions,
+		entries:    make(map[string]Entry),
+		load:       make(map[string]Load),
+		timeline:   make(map[int64]Load),
+		samples:    DefaultSamples,
+		rand:       rand.New(rand.NewSource(time.Now().UnixNano())),
+	}, nil
+}
+
+// SetSamples sets the number of queries sampled in each entry, besides the
+// slowest one. It must be called before adding queries
+func (a *Aggregator) SetSamples(n int) {
+	a.samples = n
+}
+
+// SetBucket buckets the queries by intervals of width, for the whole log and
+// for each entry, so that their calls, query time and its distribution can
+// be followed over time. width is a whole number of seconds. It is doubled as
+// often as needed to keep the queries within MaxBuckets intervals. It must be
+// called before adding queries
+func (a *Aggregator) SetBucket(width time.Duration) {
+	a.bucket = width
+	a.buckets = make(map[int64]Bucket)
+}
+
+// Bucket returns the width of the intervals queries are bucketed by, or 0 if
+// they are not. It can be wider than the width given to SetBucket
+func (a *Aggregator) Bucket() time.Duration {
+	return a.bucket
+}
+
+// Buckets returns the queries of each interval of time, by Unix time of its
+// start, when the aggregator has a bucket width
+func (a *Aggregator) Buckets() map[int64]Bucket {
+	return a.buckets
+}
+
+// GroupBy returns how the aggregator groups queries, as comma separated
+// dimensions in the order of GroupBys
+func (a *Aggregator) GroupBy() string {
+	return a.groupBy
+}
+
+// groups tells if the aggregator groups queries by a dimension
+func (a *Aggregator) groups(dimension string) bool {
+	return stringInSlice(dimension, a.dimensions)
+}
+
+// Add accounts for a query
+func (a *Aggregator) Add(q query.Query) {
+	a.AddEntries(q, a.keyEntries(q), true)
+}
+
+// Key returns the entries a query is accounted for in, with the values of the
+// grouped dimensions and their Hash. It can be called concurrently, so that
+// queries are spread over several aggregators by hash, and each entry lives in
+// one of them
+func (a *Aggregator) Key(q query.Query) []Entry {
+	return a.keyEntries(q)
+}
+
+// AddEntries accounts for a query in entries, some of the ones returned by
+// Key. When whole is true, the query is accounted for in the totals, the load
+// and the buckets of the aggregator too, which must happen once per query
+func (a *Aggregator) AddEntries(q query.Query, entries []Entry, whole bool) {
+	if a.bucket > 0 && !q.Time.IsZero() {
+		a.extend(q.Time, q.Time)
+	}
+	for _, e := range entries {
+		a.add(e, q)
+	}
+	if !whole {
+		return
+	}
+
+	class := q.Class().String()
+	l := a.load[class]
+	l.Calls++
+	l.CumQueryTime += q.QueryTime
+	a.load[class] = l
+
+	// the timeline has the load of each minute
+	if !q.Time.IsZero() {
+		minute := q.Time.Truncate(time.Minute).Unix()
+		l := a.timeline[minute]
+		l.Calls++
+		l.CumQueryTime += q.QueryTime
+		a.timeline[minute] = l
+	}
+	addBucket(a.buckets, a.bucket, q)
+
+	a.totals.Calls++
+	a.totals.CumQueryTime += q.QueryTime
+	a.totals.CumLockTime += q.LockTime
+	a.totals.CumRowsSent += q.RowsSent
+	a.totals.CumRowsExamined += q.RowsExamined
+	a.totals.CumBytesSent += q.BytesSent
+	a.totals.CumKilled += q.Killed
+}
+
+// keyEntries returns the entries a query is accounted for in, with the values
+// of the grouped dimensions and their Hash. A query belongs to one entry,
+// except when grouping by table, where it belongs to an entry per table
+func (a *Aggregator) keyEntries(q query.Query) []Entry {
+	entries := []Entry{{Schema: q.Schema}}
+	if a.groups("table") {
+		entries = tableEntries(q)
+	}
+	var fp string
+	if a.groups("fingerprint") {
+		// an inaccurate fingerprint is still the best grouping available
+		fp, _ = q.Fingerprint()
+	}
+
+	for i := range entries {
+		var key []string
+		for _, d := range a.dimensions {
+			switch d {
+			case "fingerprint":
+				entries[i].Fingerprint = fp
+				key = append(key, fp)
+			case "table":
+				key = append(key, entries[i].Table)
+			case "user":
+				entries[i].User = q.User
+				key = append(key, q.User)
+			case "host":
+				entries[i].Host = q.Host
+				key = append(key, q.Host)
+			case "schema":
+				entries[i].Schema = q.Schema
+				key = append(key, q.Schema)
+			}
+		}
+		// a single dimension is hashed alone, so that the hash of a
+		// fingerprint is the same as in the other tools
+		entries[i].Hash = fingerprint.Hash(strings.Join(key, "\x00"))
+	}
+	return entries
+}
+
+// add accounts for the query in the entry identified by e.Hash, creating it
+// from e if needed
+func (a *Aggregator) add(e Entry, q query.Query) {
+	if cur, ok := a.entries[e.Hash]; ok {
+		// there is already results
+		cur.Calls++
+		cur.CumBytesSent += q.BytesSent
+		cur.CumKilled += q.Killed
+		cur.CumLockTime += q.LockTime
+		cur.CumRowsExamined += q.RowsExamined
+		cur.CumRowsSent += q.RowsSent
+		cur.CumQueryTime += q.QueryTime
+		cur.Sketch.Add(q.QueryTime)
+		for _, m := range Metrics {
+			cur.Sketches[m].Add(metricValue(q, m))
+		}
+		addShare(cur.Users, q.User, q)
+		addShare(cur.Hosts, q.Host, q)
+		addBucket(cur.Buckets, a.bucket, q)
+
+		// update max time, and keep the slowest query
+		if q.QueryTime > cur.MaxTime {
+			cur.MaxTime = q.QueryTime
+			cur.Slowest = newSample(q)
+		}
+
+		// reservoir sampling: the query replaces one of the samples with a
+		// probability of samples/calls
+		if len(cur.Samples) < a.samples {
+			cur.Samples = append(cur.Samples, newSample(q))
+		} else if j := a.rand.Intn(cur.Calls); j < a.samples {
+			cur.Samples[j] = newSample(q)
+		}
+
+		// update min time
+		if q.QueryTime < cur.MinTime {
+			cur.MinTime = q.QueryTime
+		}
+
+		// update the entry in the map
+		a.entries[e.Hash] = cur
+	} else {
+		// it is the first time this hash appears
+		e.Calls++
+		e.CumBytesSent = q.BytesSent
+		e.CumKilled = q.Killed
+		e.CumLockTime = q.LockTime
+		e.CumRowsExamined = q.RowsExamined
+		e.CumRowsSent = q.RowsSent
+		e.CumQueryTime = q.QueryTime
+		e.MinTime = q.QueryTime
+		e.MaxTime = q.QueryTime
+		e.MeanTime = q.QueryTime
+		e.Sketch = sketch.New()
+		e.Sketch.Add(q.QueryTime)
+		e.Sketches = make(map[string]*sketch.Sketch)
+		for _, m := range Metrics {
+			e.Sketches[m] = sketch.New()
+			e.Sketches[m].Add(metricValue(q, m))
+		}
+		e.Slowest = newSample(q)
+		if a.samples > 0 {
+			e.Samples = []Sample{e.Slowest}
+		}
+		if !a.groups("user") {
+			e.Users = make(map[string]Load)
+			addShare(e.Users, q.User, q)
+		}
+		if !a.groups("host") {
+			e.Hosts = make(map[string]Load)
+			addShare(e.Hosts, q.Host, q)
+		}
+		if a.bucket > 0 {
+			e.Buckets = make(map[int64]Bucket)
+			addBucket(e.Buckets, a.bucket, q)
+		}
+
+		// add the entry to the map
+		a.entries[e.Hash] = e
+	}
+}
+
+// Merge adds the queries of o to the aggregator. Both aggregators must group
+// queries the same way, and bucket them by the same width or by widths that
+// are multiples of each other. o must not be used afterwards, as they can
+// share sketches
+func (a *Aggregator) Merge(o *Aggregator) error {
+	if o.groupBy != a.groupBy {
+		return errors.New("cannot merge queries grouped by " + o.groupBy + " with queries grouped by " + a.groupBy)
+	}
+	if (a.bucket == 0) != (o.bucket == 0) || a.bucket > 0 && a.bucket%o.bucket != 0 && o.bucket%a.bucket != 0 {
+		return errors.New("cannot merge queries bucketed by " + o.bucket.String() + " with queries bucketed by " + a.bucket.String())
+	}
+	if o.bucket > a.bucket {
+		a.widen(o.bucket)
+	} else if o.bucket < a.bucket {
+		o.widen(a.bucket)
+	}
+
+	for hash, e := range o.entries {
+		cur, ok := a.entries[hash]
+		if !ok {
+			a.entries[hash] = e
+			continue
+		}
+		cur.Calls += e.Calls
+		cur.CumErrored += e.CumErrored
+		cur.CumBytesSent += e.CumBytesSent
+		cur.CumKilled += e.CumKilled
+		cur.CumLockTime += e.CumLockTime
+		cur.CumRowsExamined += e.CumRowsExamined
+		cur.CumRowsSent += e.CumRowsSent
+		cur.CumQueryTime += e.CumQueryTime
+		if err := cur.Sketch.Merge(e.Sketch); err != nil {
+			return err
+		}
+		for m, sk := range e.Sketches {
+			if err := cur.Sketches[m].Merge(sk); err != nil {
+				return err
+			}
+		}
+		if e.MaxTime > cur.MaxTime {
+			cur.MaxTime = e.MaxTime
+			cur.Slowest = e.Slowest
+		}
+		if e.MinTime < cur.MinTime {
+			cur.MinTime = e.MinTime
+		}
+		// the calls are summed above, the samples need the calls of each
+		cur.Samples = a.mergeSamples(cur.Samples, cur.Calls-e.Calls, e.Samples, e.Calls)
+		cur.Users = mergeLoads(cur.Users, e.Users)
+		cur.Hosts = mergeLoads(cur.Hosts, e.Hosts)
+		var err error
+		if cur.Buckets, err = mergeBuckets(cur.Buckets, e.Buckets); err != nil {
+			return err
+		}
+		a.entries[hash] = cur
+	}
+
+	a.load = mergeLoads(a.load, o.load)
+	for minute, l := range o.timeline {
+		cur := a.timeline[minute]
+		cur.Calls += l.Calls
+		cur.CumQueryTime += l.CumQueryTime
+		a.timeline[minute] = cur
+	}
+	var err error
+	if a.buckets, err = mergeBuckets(a.buckets, o.buckets); err != nil {
+		return err
+	}
+	if a.bucket > 0 && !o.first.IsZero() {
+		a.extend(o.first, o.last)
+	}
+
+	a.totals.Calls += o.totals.Calls
+	a.totals.CumQueryTime += o.totals.CumQueryTime
+	a.totals.CumLockTime += o.totals.CumLockTime
+	a.totals.CumRowsSent += o.totals.CumRowsSent
+	a.totals.CumRowsExamined += o.totals.CumRowsExamined
+	a.totals.CumBytesSent += o.totals.CumBytesSent
+	a.totals.CumKilled += o.totals.CumKilled
+	return nil
+}
+
+// Entries returns the entries, in no particular order. Use Compute to get
+// their means, percentiles and distributions, and Sort to sort them
+func (a *Aggregator) Entries() []Entry {
+	entries := make([]Entry, 0, len(a.entries))
+	for _, e := range a.entries {
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+// Load returns the load of each class of queries, by name
+func (a *Aggregator) Load() map[string]Load {
+	return a.load
+}
+
+// Timeline returns the load of each minute, by Unix time
+func (a *Aggregator) Timeline() map[int64]Load {
+	return a.timeline
+}
+
+// Totals returns the totals of the aggregated queries
+func (a *Aggregator) Totals() Totals {
+	return a.totals
+}
+
+// jsonAggregator is the JSON representation of an aggregator
+type jsonAggregator struct {
+	GroupBy  string          `json:"group_by"`
+	Entries  []Entry         `json:"entries"`
+	Load     map[string]Load `json:"load"`
+	Timeline map[int64]Load  `json:"timeline"`
+	Totals   Totals          `json:"totals"`
+	// Bucket is in nanoseconds, as time.Duration
+	Bucket  time.Duration    `json:"bucket,omitempty"`
+	Buckets map[int64]Bucket `json:"buckets,omitempty"`
+}
+
+// MarshalJSON implements json.Marshaler
+func (a *Aggregator) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonAggregator{
+		GroupBy:  a.groupBy,
+		Entries:  a.Entries(),
+		Load:     a.load,
+		Timeline: a.timeline,
+		Totals:   a.totals,
+		Bucket:   a.bucket,
+		Buckets:  a.buckets,
+	})
+}
+
+// UnmarshalJSON implements json.Unmarshaler
+func (a *Aggregator) UnmarshalJSON(data []byte) error {
+	var ja jsonAggregator
+	if err := json.Unmarshal(data, &ja); err != nil {
+		return err
+	}
+	n, err := NewAggregator(ja.GroupBy)
+	if err != nil {
+		return err
+	}
+	if ja.Bucket > 0 {
+		n.SetBucket(ja.Bucket)
+	}
+	for start, b := range ja.Buckets {
+		n.buckets[start] = b
+	}
+	n.first, n.last = Span(n.buckets)
+	for _, e := range ja.Entries {
+		if e.Sketch == nil || len(e.Sketches) != len(Metrics) {
+			return errors.New("entry " + e.Hash + " has no distributions")
+		}
+		n.entries[e.Hash] = e
+		if first, last := Span(e.Buckets); !first.IsZero() {
+			n.extend(first, last)
+		}
+	}
+	for class, l := range ja.Load {
+		n.load[class] = l
+	}
+	for minute, l := range ja.Timeline {
+		n.timeline[minute] = l
+	}
+	n.totals = ja.Totals
+	*a = *n
+	return nil
+}
+
+// newSample returns the sample of a query
+func newSample(q query.Query) Sample {
+	return Sample{
+		Query:     q.Query,
+		Time:      q.Time,
+		QueryTime: q.QueryTime,
+		User:      q.User,
+		Host:      q.Host,
+		Schema:    q.Schema,
+		Line:      q.Line,
+	}
+}
+
+// mergeSamples merges the samples x of n queries and y of m queries into the
+// samples of the n+m queries. Each sample is taken from x with a probability
+// of n/(n+m), the remaining counts being updated after each pick, so that
+// every query keeps the same chance to be picked
+func (a *Aggregator) mergeSamples(x []Sample, n int, y []Sample, m int) []Sample {
+	if len(x)+len(y) <= a.samples && len(x) == n && len(y) == m {
+		return append(x, y...)
+	}
+
+	// the samples are picked in a random order
+	x = append([]Sample(nil), x...)
+	y = append([]Sample(nil), y...)
+	a.rand.Shuffle(len(x), func(i, j int) { x[i], x[j] = x[j], x[i] })
+	a.rand.Shuffle(len(y), func(i, j int) { y[i], y[j] = y[j], y[i] })
+
+	merged := make([]Sample, 0, a.samples)
+	for len(merged) < a.samples && (len(x) > 0 || len(y) > 0) {
+		if len(y) == 0 || (len(x) > 0 && a.rand.Intn(n+m) < n) {
+			merged = append(merged, x[0])
+			x, n = x[1:], n-1
+		} else {
+			merged = append(merged, y[0])
+			y, m = y[1:], m-1
+		}
+	}
+	return merged
+}
+
+// Share is the load of a user or a host in an entry
+type Share struct {
+	Name string
+	Load
+}
+
+// Top returns the n users or hosts of loads with the most query time, the
+// most first. Ties are broken by name
+func Top(loads map[string]Load, n int) []Share {
+	shares := make([]Share, 0, len(loads))
+	for name, l := range loads {
+		shares = append(shares, Share{Name: name, Load: l})
+	}
+	sort.Slice(shares, func(i, j int) bool {
+		if shares[i].CumQueryTime != shares[j].CumQueryTime {
+			return shares[i].CumQueryTime > shares[j].CumQueryTime
+		}
+		return shares[i].Name < shares[j].Name
+	})
+	if len(shares) > n {
+		shares = shares[:n]
+	}
+	return shares
+}
+
+// addShare accounts for a query in the load of name, unless the breakdown
+// is not kept or the name is unknown
+func addShare(loads map[string]Load, name string, q query.Query) {
+	if loads == nil || name == "" {
+		return
+	}
+	l := loads[name]
+	l.Calls++
+	l.CumQueryTime += q.QueryTime
+	loads[name] = l
+}
+
+// mergeLoads adds the loads of src to dst, and returns dst. dst is created
+// if needed
+func mergeLoads(dst, src map[string]Load) map[string]Load {
+	if dst == nil && src != nil {
+		dst = make(map[string]Load)
+	}
+	for name, l := range src {
+		cur := dst[name]
+		cur.Calls += l.Calls
+		cur.CumQueryTime += l.CumQueryTime
+		dst[name] = cur
+	}
+	return dst
+}
+
+// tableEntries returns an entry for each table referenced by the query, so
+// that a query joining two tables is accounted for in both. The tables that
+// are not qualified by a schema belong to the query's schema. Queries without
+// tables are grouped together
+func tableEntries(q query.Query) []Entry {
+	var entries []Entry
+	seen := make(map[string]bool)
+	for _, t := range structure.Extract(q.Query).Tables {
+		if t.Schema == "" {
+			t.Schema = q.Schema
+		}
+		name := t.String()
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		entries = append(entries, Entry{Hash: fingerprint.Hash(name), Table: name, Schema: t.Schema})
+	}
+
+	if len(entries) == 0 {
+		entries = append(entries, Entry{Hash: fingerprint.Hash(NoTable), Table: NoTable})
+	}
+	return entries
+}
+
+// metricValue returns the value of a metric of a query
+func metricValue(q query.Query, metric string) float64 {
+	switch metric {
+	case "lock_time":
+		return q.LockTime
+	case "rows_sent":
+		return float64(q.RowsSent)
+	case "rows_examined":
+		return float64(q.RowsExamined)
+	case "bytes_sent":
+		return float64(q.BytesSent)
+	}
+	return 0
+}
+
+func stringInSlice(s string, sl []string) bool {
+	for _, v := range sl {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,344 @@
+package digest
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/devops-works/slowql/fingerprint"
+	"github.com/devops-works/slowql/query"
+)
+
+func Test_tableEntries(t *testing.T) {
+	tests := []struct {
+		name   string
+		q      query.Query
+		tables []string
+	}{
+		{name: "single table", q: query.Query{Schema: "shop", Query: "SELECT * FROM users WHERE id = 1"},
+			tables: []string{"shop.users"}},
+		{name: "join across schemas", q: query.Query{Schema: "shop", Query: "SELECT * FROM users u JOIN billing.invoices i ON i.user_id = u.id"},
+			tables: []string{"shop.users", "billing.invoices"}},
+		{name: "same table twice", q: query.Query{Schema: "shop", Query: "SELECT * FROM users JOIN shop.users AS u2 ON u2.id = users.parent_id"},
+			tables: []string{"shop.users"}},
+		{name: "no schema", q: query.Query{Query: "DELETE FROM sessions"},
+			tables: []string{"sessions"}},
+		{name: "no table", q: query.Query{Schema: "shop", Query: "SELECT 1"},
+			tables: []string{NoTable}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tableEntries(tt.q)
+			var tables []string
+			for _, s := range got {
+				tables = append(tables, s.Table)
+				if s.Hash != fingerprint.Hash(s.Table) {
+					t.Errorf("tableEntries() hash = %v, want %v", s.Hash, fingerprint.Hash(s.Table))
+				}
+			}
+			if !reflect.DeepEqual(tables, tt.tables) {
+				t.Errorf("tableEntries() = %v, want %v", tables, tt.tables)
+			}
+		})
+	}
+}
+
+// testQueries returns queries of two fingerprints, three users and two hosts,
+// over two minutes
+func testQueries() []query.Query {
+	start := time.Date(2021, 3, 23, 11, 0, 0, 0, time.UTC)
+	return []query.Query{
+		{Time: start, User: "app", Host: "10.0.0.1", Schema: "shop", Query: "SELECT * FROM orders WHERE id = 1", QueryTime: 1, LockTime: 0.001, RowsExamined: 10, RowsSent: 1},
+		{Time: start.Add(10 * time.Second), User: "app", Host: "10.0.0.2", Schema: "shop", Query: "SELECT * FROM orders WHERE id = 2", QueryTime: 3, LockTime: 0.002, RowsExamined: 30, RowsSent: 1},
+		{Time: start.Add(time.Minute), User: "cron", Host: "10.0.0.1", Schema: "shop", Query: "DELETE FROM carts WHERE id = 3", QueryTime: 0.5, RowsExamined: 1, Killed: 1},
+		{Time: start.Add(70 * time.Second), User: "batch", Host: "10.0.0.1", Schema: "shop", Query: "SELECT * FROM orders WHERE id = 4", QueryTime: 2, LockTime: 0.001, RowsExamined: 20, RowsSent: 1},
+	}
+}
+
+func TestAggregator_Add(t *testing.T) {
+	a, err := NewAggregator("fingerprint")
+	if err != nil {
+		t.Fatalf("NewAggregator() error = %v", err)
+	}
+	for _, q := range testQueries() {
+		a.Add(q)
+	}
+
+	entries := a.Entries()
+	if len(entries) != 2 {
+		t.Fatalf("Entries() = %d entries, want 2", len(entries))
+	}
+	hash := fingerprint.Hash("select * from orders where id = ?")
+	var e Entry
+	for _, e = range entries {
+		if e.Hash == hash {
+			break
+		}
+	}
+	if e.Calls != 3 || e.CumQueryTime != 6 || e.MinTime != 1 || e.MaxTime != 3 || e.CumRowsExamined != 60 {
+		t.Errorf("Entries() = %+v, want 3 calls of 6s from 1s to 3s, 60 rows examined", e)
+	}
+	if e.Slowest.Query != "SELECT * FROM orders WHERE id = 2" || e.Slowest.User != "app" || e.Slowest.Host != "10.0.0.2" {
+		t.Errorf("Entries() slowest = %+v, want the slowest query", e.Slowest)
+	}
+	if e.Sketch.Count() != 3 || e.Sketches["rows_examined"].Max() != 30 {
+		t.Errorf("Entries() distributions = %d values up to %v rows examined, want 3 up to 30",
+			e.Sketch.Count(), e.Sketches["rows_examined"].Max())
+	}
+	if got := a.Totals(); got.Calls != 4 || got.CumQueryTime != 6.5 || got.CumKilled != 1 {
+		t.Errorf("Totals() = %+v", got)
+	}
+	if got := a.Load(); got["read"].Calls != 3 || got["write"].Calls != 1 {
+		t.Errorf("Load() = %+v", got)
+	}
+	if got := a.Timeline(); len(got) != 2 || got[1616497260].Calls != 2 {
+		t.Errorf("Timeline() = %+v", got)
+	}
+
+	if _, err := NewAggregator("column"); err == nil {
+		t.Error("NewAggregator() of an unknown grouping succeeded")
+	}
+}
+
+func TestParseGroupBy(t *testing.T) {
+	tests := []struct {
+		name    string
+		groupBy string
+		want    []string
+		wantErr bool
+	}{
+		{name: "fingerprint", groupBy: "fingerprint", want: []string{"fingerprint"}},
+		{name: "combination", groupBy: "user, fingerprint,host", want: []string{"fingerprint", "user", "host"}},
+		{name: "duplicate", groupBy: "schema,schema", want: []string{"schema"}},
+		{name: "unknown", groupBy: "fingerprint,column", wantErr: true},
+		{name: "empty", groupBy: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseGroupBy(tt.groupBy)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseGroupBy() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseGroupBy() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAggregator_Add_dimensions(t *testing.T) {
+	tests := []struct {
+		name    string
+		groupBy string
+		// want are the calls of each entry, by name
+		want map[string]int
+		// wantUsers are the calls of each user of the entries, by entry name
+		wantUsers map[string]map[string]int
+	}{
+		{name: "user", groupBy: "user", want: map[string]int{"app": 2, "cron": 1, "batch": 1}},
+		{name: "host", groupBy: "host", want: map[string]int{"10.0.0.1": 3, "10.0.0.2": 1},
+			wantUsers: map[string]map[string]int{"10.0.0.1": {"app": 1, "cron": 1, "batch": 1}, "10.0.0.2": {"app": 1}}},
+		{name: "fingerprint and user", groupBy: "user,fingerprint",
+			want: map[string]int{"select * from orders where id = ?/app": 2, "select * from orders where id = ?/batch": 1,
+				"delete from carts where id = ?/cron": 1}},
+		{name: "table and host", groupBy: "table,host", want: map[string]int{"shop.orders/10.0.0.1": 2,
+			"shop.orders/10.0.0.2": 1, "shop.carts/10.0.0.1": 1}},
+		{name: "schema", groupBy: "schema", want: map[string]int{"shop": 4},
+			wantUsers: map[string]map[string]int{"shop": {"app": 2, "cron": 1, "batch": 1}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, err := NewAggregator(tt.groupBy)
+			if err != nil {
+				t.Fatalf("NewAggregator() error = %v", err)
+			}
+			for _, q := range testQueries() {
+				a.Add(q)
+			}
+
+			got := make(map[string]int)
+			users := make(map[string]map[string]int)
+			for _, e := range a.Entries() {
+				var key []string
+				for _, v := range []string{e.Fingerprint, e.Table, e.User, e.Host} {
+					if v != "" {
+						key = append(key, v)
+					}
+				}
+				name := strings.Join(key, "/")
+				if name == "" {
+					name = e.Schema
+				}
+				got[name] = e.Calls
+				if e.Users != nil {
+					users[name] = make(map[string]int)
+					for user, l := range e.Users {
+						users[name][user] = l.Calls
+					}
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Entries() = %v, want %v", got, tt.want)
+			}
+			if tt.wantUsers != nil && !reflect.DeepEqual(users, tt.wantUsers) {
+				t.Errorf("Entries() users = %v, want %v", users, tt.wantUsers)
+			}
+		})
+	}
+}
+
+func TestAggregator_samples(t *testing.T) {
+	// sampleCounts picks a sample of one query among ten, trials times, and
+	// returns how many times each query is picked. The queries are either
+	// added to one aggregator, or split between two merged aggregators
+	sampleCounts := func(trials int, split int) map[int]int {
+		counts := make(map[int]int)
+		for i := 0; i < trials; i++ {
+			a, _ := NewAggregator("fingerprint")
+			a.SetSamples(1)
+			b, _ := NewAggregator("fingerprint")
+			b.SetSamples(1)
+			for id := 0; id < 10; id++ {
+				q := query.Query{Query: fmt.Sprintf("SELECT * FROM t WHERE id = %d", id), QueryTime: 1, Line: id + 1}
+				if id < split {
+					b.Add(q)
+				} else {
+					a.Add(q)
+				}
+			}
+			if err := a.Merge(b); err != nil {
+				t.Fatalf("Merge() error = %v", err)
+			}
+			samples := a.Entries()[0].Samples
+			if len(samples) != 1 {
+				t.Fatalf("Samples = %d queries, want 1", len(samples))
+			}
+			counts[samples[0].Line-1]++
+		}
+		return counts
+	}
+
+	tests := []struct {
+		name  string
+		split int
+	}{
+		{name: "one aggregator", split: 0},
+		{name: "merged aggregators", split: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// each query should be picked 200 times, give or take 6 standard
+			// deviations
+			counts := sampleCounts(2000, tt.split)
+			for id := 0; id < 10; id++ {
+				if counts[id] < 120 || counts[id] > 280 {
+					t.Errorf("query %d sampled %d times out of 2000, want about 200", id, counts[id])
+				}
+			}
+		})
+	}
+
+	a, _ := NewAggregator("fingerprint")
+	for i := 0; i < 100; i++ {
+		a.Add(query.Query{Query: fmt.Sprintf("SELECT %d", i), QueryTime: float64(i % 7), Line: i + 1})
+	}
+	e := a.Entries()[0]
+	if len(e.Samples) != DefaultSamples || e.Slowest.QueryTime != 6 || e.Slowest.Line != 7 {
+		t.Errorf("Entries() = %d samples and %+v as slowest, want %d samples and the first query of 6s",
+			len(e.Samples), e.Slowest, DefaultSamples)
+	}
+}
+
+func TestTop(t *testing.T) {
+	loads := map[string]Load{
+		"app":   {Calls: 10, CumQueryTime: 2},
+		"cron":  {Calls: 1, CumQueryTime: 5},
+		"batch": {Calls: 3, CumQueryTime: 2},
+		"admin": {Calls: 1, CumQueryTime: 0.1},
+	}
+	var got []string
+	for _, s := range Top(loads, 3) {
+		got = append(got, s.Name)
+	}
+	if want := []string{"cron", "app", "batch"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Top() = %v, want %v", got, want)
+	}
+}
+
+func TestAggregator_Merge(t *testing.T) {
+	for _, groupBy := range GroupBys {
+		t.Run(groupBy, func(t *testing.T) {
+			all, _ := NewAggregator(groupBy)
+			even, _ := NewAggregator(groupBy)
+			odd, _ := NewAggregator(groupBy)
+			for i, q := range testQueries() {
+				all.Add(q)
+				if i%2 == 0 {
+					even.Add(q)
+				} else {
+					odd.Add(q)
+				}
+			}
+			if err := even.Merge(odd); err != nil {
+				t.Fatalf("Merge() error = %v", err)
+			}
+
+			if !reflect.DeepEqual(even.Totals(), all.Totals()) || !reflect.DeepEqual(even.Load(), all.Load()) ||
+				!reflect.DeepEqual(even.Timeline(), all.Timeline()) {
+				t.Errorf("Merge() = %+v, want %+v", even, all)
+			}
+			want := make(map[string]Entry)
+			for _, e := range all.Entries() {
+				want[e.Hash] = e
+			}
+			for _, e := range even.Entries() {
+				w := want[e.Hash]
+				if e.Calls != w.Calls || e.CumQueryTime != w.CumQueryTime || e.MinTime != w.MinTime ||
+					e.MaxTime != w.MaxTime || e.Slowest != w.Slowest || e.Sketch.Quantile(0.5) != w.Sketch.Quantile(0.5) ||
+					e.Sketches["lock_time"].Max() != w.Sketches["lock_time"].Max() {
+					t.Errorf("Merge() entry = %+v, want %+v", e, w)
+				}
+			}
+		})
+	}
+
+	fp, _ := NewAggregator("fingerprint")
+	table, _ := NewAggregator("table")
+	if err := fp.Merge(table); err == nil {
+		t.Error("Merge() of aggregators grouping differently succeeded")
+	}
+}
+
+func TestAggregator_JSON(t *testing.T) {
+	a, _ := NewAggregator("table")
+	for _, q := range testQueries() {
+		a.Add(q)
+	}
+	data, err := json.Marshal(a)
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	var got Aggregator
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if got.GroupBy() != "table" || !reflect.DeepEqual(got.Totals(), a.Totals()) ||
+		!reflect.DeepEqual(got.Timeline(), a.Timeline()) || len(got.Entries()) != len(a.Entries()) {
+		t.Errorf("Unmarshal() = %+v, want %+v", got, a)
+	}
+
+	// the aggregators of several hosts can be merged
+	if err := got.Merge(a); err != nil {
+		t.Errorf("Merge() error = %v", err)
+	}
+	if got.Totals().Calls != 2*a.Totals().Calls {
+		t.Errorf("Merge() totals = %+v", got.Totals())
+	}
+
+	if err := json.Unmarshal([]byte(`{"group_by": "table", "entries": [{"Hash": "a"}]}`), &got); err == nil {
+		t.Error("Unmarshal() of entries without distributions succeeded")
+	}
+}
@@ -0,0 +1,167 @@
+package digest
+
+import (
+	"time"
+
+	"github.com/devops-works/slowql/query"
+	"github.com/devops-works/slowql/sketch"
+)
+
+// MaxBuckets is the number of intervals past which the width of the buckets
+// is doubled, so that a narrow width over a long log does not take too much
+// memory, nor yield too long series
+const MaxBuckets = 500
+
+// Bucket is the aggregation of the queries logged in an interval of time
+type Bucket struct {
+	Calls        int
+	CumQueryTime float64
+	// Sketch is the distribution of the query time
+	Sketch *sketch.Sketch
+}
+
+// Point is an interval of a series, with the percentile of the query time
+// given to Series. Intervals without queries have a zero Calls
+type Point struct {
+	Start        time.Time
+	Calls        int
+	CumQueryTime float64
+	Percentile   float64
+}
+
+// Span returns the start of the first and last buckets, by Unix time. Both
+// are zero when there is no bucket
+func Span(buckets map[int64]Bucket) (first, last time.Time) {
+	for start := range buckets {
+		t := time.Unix(start, 0).UTC()
+		if first.IsZero() || t.Before(first) {
+			first = t
+		}
+		if last.IsZero() || t.After(last) {
+			last = t
+		}
+	}
+	return first, last
+}
+
+// Series returns a point for each interval of width from first to last,
+// whether it has queries or not, so that the series of several entries line
+// up. Use Span to get first and last
+func Series(buckets map[int64]Bucket, first, last time.Time, width time.Duration, percentile float64) []Point {
+	if width <= 0 || first.IsZero() {
+		return nil
+	}
+
+	var points []Point
+	for t := first; !t.After(last); t = t.Add(width) {
+		p := Point{Start: t}
+		if b, ok := buckets[t.Unix()]; ok {
+			p.Calls = b.Calls
+			p.CumQueryTime = b.CumQueryTime
+			if b.Sketch != nil {
+				p.Percentile = b.Sketch.Quantile(percentile / 100)
+			}
+		}
+		points = append(points, p)
+	}
+	return points
+}
+
+// addBucket accounts for a query in the bucket of its interval of width,
+// unless its time is unknown
+func addBucket(buckets map[int64]Bucket, width time.Duration, q query.Query) {
+	if buckets == nil || q.Time.IsZero() {
+		return
+	}
+	start := q.Time.Truncate(width).Unix()
+	b := buckets[start]
+	if b.Sketch == nil {
+		b.Sketch = sketch.New()
+	}
+	b.Calls++
+	b.CumQueryTime += q.QueryTime
+	b.Sketch.Add(q.QueryTime)
+	buckets[start] = b
+}
+
+// extend accounts for the queries logged from first to last in the span of
+// the aggregator, and widens the buckets if the span no longer fits in
+// MaxBuckets intervals
+func (a *Aggregator) extend(first, last time.Time) {
+	if a.first.IsZero() || first.Before(a.first) {
+		a.first = first
+	}
+	if last.After(a.last) {
+		a.last = last
+	}
+
+	width := a.bucket
+	for intervals(a.first, a.last, width) > MaxBuckets {
+		width *= 2
+	}
+	if width != a.bucket {
+		a.widen(width)
+	}
+}
+
+// widen buckets the queries by width, which is a multiple of the current
+// width
+func (a *Aggregator) widen(width time.Duration) {
+	a.bucket = width
+	a.buckets = rebucket(a.buckets, width)
+	for hash, e := range a.entries {
+		e.Buckets = rebucket(e.Buckets, width)
+		a.entries[hash] = e
+	}
+}
+
+// intervals returns the number of intervals of width from first to last
+func intervals(first, last time.Time, width time.Duration) int64 {
+	return (last.Truncate(width).Unix()-first.Truncate(width).Unix())/int64(width/time.Second) + 1
+}
+
+// rebucket returns the buckets by intervals of width, which is a multiple of
+// their width, so that each bucket falls in a single interval
+func rebucket(buckets map[int64]Bucket, width time.Duration) map[int64]Bucket {
+	if buckets == nil {
+		return nil
+	}
+	wider := make(map[int64]Bucket, len(buckets))
+	for start, b := range buckets {
+		start = time.Unix(start, 0).Truncate(width).Unix()
+		cur, ok := wider[start]
+		if !ok {
+			wider[start] = b
+			continue
+		}
+		cur.Calls += b.Calls
+		cur.CumQueryTime += b.CumQueryTime
+		// the sketches are all created by sketch.New, with the same accuracy
+		_ = cur.Sketch.Merge(b.Sketch)
+		wider[start] = cur
+	}
+	return wider
+}
+
+// mergeBuckets adds the buckets of src to dst, and returns dst. dst is
+// created if needed. src must not be used afterwards, as they can share
+// sketches
+func mergeBuckets(dst, src map[int64]Bucket) (map[int64]Bucket, error) {
+	if dst == nil && src != nil {
+		dst = make(map[int64]Bucket)
+	}
+	for start, b := range src {
+		cur, ok := dst[start]
+		if !ok {
+			dst[start] = b
+			continue
+		}
+		cur.Calls += b.Calls
+		cur.CumQueryTime += b.CumQueryTime
+		if err := cur.Sketch.Merge(b.Sketch); err != nil {
+			return nil, err
+		}
+		dst[start] = cur
+	}
+	return dst, nil
+}
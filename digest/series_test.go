@@ -0,0 +1,187 @@
+package digest
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/devops-works/slowql/query"
+)
+
+func TestAggregator_buckets(t *testing.T) {
+	start := time.Date(2021, 3, 23, 11, 0, 0, 0, time.UTC)
+	queries := testQueries()
+
+	// the queries are split between two aggregators, and merged, as the
+	// workers of slowql-digest do
+	a, _ := NewAggregator("fingerprint")
+	a.SetBucket(time.Minute)
+	o, _ := NewAggregator("fingerprint")
+	o.SetBucket(time.Minute)
+	for i, q := range queries {
+		if i%2 == 0 {
+			a.Add(q)
+		} else {
+			o.Add(q)
+		}
+	}
+	if err := a.Merge(o); err != nil {
+		t.Fatalf("Merge() error = %v", err)
+	}
+	// and saved, as caches are
+	data, err := json.Marshal(a)
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	var got Aggregator
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if got.Bucket() != time.Minute {
+		t.Errorf("Bucket() = %s, want 1m", got.Bucket())
+	}
+
+	first, last := Span(got.Buckets())
+	if !first.Equal(start) || !last.Equal(start.Add(time.Minute)) {
+		t.Errorf("Span() = %s, %s", first, last)
+	}
+
+	var orders Entry
+	for _, e := range got.Entries() {
+		if e.Fingerprint == "select * from orders where id = ?" {
+			orders = e
+		}
+	}
+
+	tests := []struct {
+		name    string
+		buckets map[int64]Bucket
+		want    []Point
+	}{
+		{name: "global", buckets: got.Buckets(), want: []Point{
+			{Start: start, Calls: 2, CumQueryTime: 4, Percentile: 1},
+			{Start: start.Add(time.Minute), Calls: 2, CumQueryTime: 2.5, Percentile: 0.5},
+		}},
+		{name: "entry", buckets: orders.Buckets, want: []Point{
+			{Start: start, Calls: 2, CumQueryTime: 4, Percentile: 1},
+			{Start: start.Add(time.Minute), Calls: 1, CumQueryTime: 2, Percentile: 2},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			points := Series(tt.buckets, first, last, time.Minute, 95)
+			if len(points) != len(tt.want) {
+				t.Fatalf("Series() = %+v, want %+v", points, tt.want)
+			}
+			for i, p := range points {
+				w := tt.want[i]
+				if !p.Start.Equal(w.Start) || p.Calls != w.Calls || p.CumQueryTime != w.CumQueryTime ||
+					math.Abs(p.Percentile-w.Percentile) > 0.01*w.Percentile {
+					t.Errorf("Series()[%d] = %+v, want %+v", i, p, w)
+				}
+			}
+		})
+	}
+
+	b, _ := NewAggregator("fingerprint")
+	if err := b.Merge(a); err == nil {
+		t.Error("Merge() of queries bucketed differently succeeded")
+	}
+}
+
+func TestAggregator_widen(t *testing.T) {
+	start := time.Date(2021, 3, 23, 11, 0, 0, 0, time.UTC)
+	n := 3 * MaxBuckets
+
+	// one aggregator gets the queries of the first half of the log only, so
+	// that they are widened differently before being merged
+	a, _ := NewAggregator("fingerprint")
+	a.SetBucket(time.Second)
+	o, _ := NewAggregator("fingerprint")
+	o.SetBucket(time.Second)
+	for i := 0; i < n; i++ {
+		q := query.Query{Time: start.Add(time.Duration(i) * time.Second), QueryTime: 1, Query: "SELECT 1"}
+		if i < n/2 && i%2 == 0 {
+			a.Add(q)
+		} else {
+			o.Add(q)
+		}
+	}
+	if a.Bucket() != 2*time.Second || o.Bucket() != 4*time.Second {
+		t.Fatalf("Bucket() = %s and %s, want 2s and 4s", a.Bucket(), o.Bucket())
+	}
+	if err := a.Merge(o); err != nil {
+		t.Fatalf("Merge() error = %v", err)
+	}
+	if a.Bucket() != 4*time.Second {
+		t.Errorf("Bucket() = %s, want 4s", a.Bucket())
+	}
+
+	tests := []struct {
+		name    string
+		buckets map[int64]Bucket
+	}{
+		{name: "global", buckets: a.Buckets()},
+		{name: "entry", buckets: a.Entries()[0].Buckets},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if len(tt.buckets) > MaxBuckets {
+				t.Errorf("%d buckets, want at most %d", len(tt.buckets), MaxBuckets)
+			}
+			calls := 0
+			for start, b := range tt.buckets {
+				if start%4 != 0 || uint64(b.Calls) != b.Sketch.Count() {
+					t.Errorf("bucket %d = %+v", start, b)
+				}
+				calls += b.Calls
+			}
+			if calls != n {
+				t.Errorf("buckets have %d calls, want %d", calls, n)
+			}
+		})
+	}
+
+	b, _ := NewAggregator("fingerprint")
+	b.SetBucket(3 * time.Second)
+	if err := b.Merge(a); err == nil {
+		t.Error("Merge() of queries bucketed by 3s and 4s succeeded")
+	}
+}
+
+func TestSeries(t *testing.T) {
+	start := time.Date(2021, 3, 23, 11, 0, 0, 0, time.UTC)
+	buckets := map[int64]Bucket{
+		start.Add(time.Minute).Unix():     {Calls: 1, CumQueryTime: 1, Sketch: testSketch(1)},
+		start.Add(3 * time.Minute).Unix(): {Calls: 2, CumQueryTime: 3, Sketch: testSketch(1, 2)},
+	}
+
+	tests := []struct {
+		name        string
+		first, last time.Time
+		width       time.Duration
+		want        []int
+	}{
+		{name: "gaps", first: start.Add(time.Minute), last: start.Add(3 * time.Minute), width: time.Minute, want: []int{1, 0, 2}},
+		{name: "wider span", first: start, last: start.Add(4 * time.Minute), width: time.Minute, want: []int{0, 1, 0, 2, 0}},
+		{name: "no bucket", width: time.Minute},
+		{name: "no width", first: start, last: start.Add(4 * time.Minute)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls []int
+			for _, p := range Series(buckets, tt.first, tt.last, tt.width, 95) {
+				calls = append(calls, p.Calls)
+			}
+			if len(calls) != len(tt.want) {
+				t.Fatalf("Series() calls = %v, want %v", calls, tt.want)
+			}
+			for i := range calls {
+				if calls[i] != tt.want[i] {
+					t.Errorf("Series() calls = %v, want %v", calls, tt.want)
+				}
+			}
+		})
+	}
+}
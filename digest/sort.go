@@ -0,0 +1,148 @@
+package digest
+
+import (
+	"errors"
+	"sort"
+	"strings"
+)
+
+// Orders lists how entries can be sorted. They can also be sorted by any of
+// the percentiles, such as p95, and by the minimum, maximum, mean or any of
+// the percentiles of the other metrics, such as rows_examined_p99
+var Orders = []string{"bytes_sent", "calls", "concurrency", "killed", "lock_time",
+	"max_time", "mean_time", "min_time", "query_time", "random", "rows_examined", "rows_sent"}
+
+// IsOrder tells if entries can be sorted by order, given the percentiles they
+// are computed with
+func IsOrder(order string, percentiles []float64) bool {
+	return stringInSlice(order, Orders) || isPercentile(order, percentiles) ||
+		isDistributionOrder(order, percentiles)
+}
+
+// Sort sorts computed entries by order, in increasing order unless dec is
+// set. Sorting by random leaves them as they are
+func Sort(entries []Entry, order string, dec bool) error {
+	switch order {
+	case "random":
+		break
+	case "calls":
+		sort.SliceStable(entries, func(i, j int) bool {
+			return entries[i].Calls < entries[j].Calls
+		})
+	case "bytes_sent":
+		sort.SliceStable(entries, func(i, j int) bool {
+			return entries[i].CumBytesSent < entries[j].CumBytesSent
+		})
+	case "query_time":
+		sort.SliceStable(entries, func(i, j int) bool {
+			return entries[i].CumQueryTime < entries[j].CumQueryTime
+		})
+	case "lock_time":
+		sort.SliceStable(entries, func(i, j int) bool {
+			return entries[i].CumLockTime < entries[j].CumLockTime
+		})
+	case "rows_sent":
+		sort.SliceStable(entries, func(i, j int) bool {
+			return entries[i].CumRowsSent < entries[j].CumRowsSent
+		})
+	case "rows_examined":
+		sort.SliceStable(entries, func(i, j int) bool {
+			return entries[i].CumRowsExamined < entries[j].CumRowsExamined
+		})
+	case "killed":
+		sort.SliceStable(entries, func(i, j int) bool {
+			return entries[i].CumKilled < entries[j].CumKilled
+		})
+	case "min_time":
+		sort.SliceStable(entries, func(i, j int) bool {
+			return entries[i].MinTime < entries[j].MinTime
+		})
+	case "max_time":
+		sort.SliceStable(entries, func(i, j int) bool {
+			return entries[i].MaxTime < entries[j].MaxTime
+		})
+	case "mean_time":
+		sort.SliceStable(entries, func(i, j int) bool {
+			return entries[i].MeanTime < entries[j].MeanTime
+		})
+	case "concurrency":
+		sort.SliceStable(entries, func(i, j int) bool {
+			return entries[i].Concurrency < entries[j].Concurrency
+		})
+	default:
+		if len(entries) > 0 {
+			if _, ok := orderValue(entries[0], order); !ok {
+				return errors.New("unknown order: " + order)
+			}
+		}
+		sort.SliceStable(entries, func(i, j int) bool {
+			vi, _ := orderValue(entries[i], order)
+			vj, _ := orderValue(entries[j], order)
+			return vi < vj
+		})
+	}
+
+	if dec {
+		for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+			entries[i], entries[j] = entries[j], entries[i]
+		}
+	}
+	return nil
+}
+
+// orderValue returns the value of an entry that is sorted by a percentile of
+// the query time, such as p95, or by a statistic of the distribution of
+// another metric, such as rows_examined_p99 or lock_time_max
+func orderValue(e Entry, order string) (float64, bool) {
+	name := strings.ToLower(order)
+	if v, ok := e.Percentiles[name]; ok {
+		return v, true
+	}
+	for _, m := range Metrics {
+		if !strings.HasPrefix(name, m+"_") {
+			continue
+		}
+		d, ok := e.Distributions[m]
+		if !ok {
+			return 0, false
+		}
+		switch stat := strings.TrimPrefix(name, m+"_"); stat {
+		case "min":
+			return d.Min, true
+		case "max":
+			return d.Max, true
+		case "mean":
+			return d.Mean, true
+		default:
+			v, ok := d.Percentiles[stat]
+			return v, ok
+		}
+	}
+	return 0, false
+}
+
+// isPercentile tells if name is the name of one of the percentiles
+func isPercentile(name string, percentiles []float64) bool {
+	for _, p := range percentiles {
+		if strings.EqualFold(name, PercentileName(p)) {
+			return true
+		}
+	}
+	return false
+}
+
+// isDistributionOrder tells if name is a statistic of the distribution of a
+// metric, such as rows_examined_p99 or lock_time_max
+func isDistributionOrder(name string, percentiles []float64) bool {
+	name = strings.ToLower(name)
+	for _, m := range Metrics {
+		if !strings.HasPrefix(name, m+"_") {
+			continue
+		}
+		stat := strings.TrimPrefix(name, m+"_")
+		if stringInSlice(stat, []string{"min", "max", "mean"}) || isPercentile(stat, percentiles) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,50 @@
+package digest
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSort(t *testing.T) {
+	tests := []struct {
+		name    string
+		order   string
+		dec     bool
+		want    []string
+		wantErr bool
+	}{
+		{name: "calls", order: "calls", want: []string{"b", "a", "c"}},
+		{name: "percentile", order: "p99", want: []string{"a", "c", "b"}},
+		{name: "percentile decreasing", order: "P99", dec: true, want: []string{"b", "c", "a"}},
+		{name: "unknown percentile", order: "p50", wantErr: true},
+		{name: "metric percentile", order: "rows_examined_p99", want: []string{"c", "a", "b"}},
+		{name: "metric max", order: "lock_time_max", dec: true, want: []string{"a", "b", "c"}},
+		{name: "metric without distribution", order: "bytes_sent_max", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := []Entry{
+				{Hash: "a", Calls: 2, Percentiles: map[string]float64{"p99": 0.1}, Distributions: map[string]Distribution{
+					"rows_examined": {Percentiles: map[string]float64{"p99": 20}}, "lock_time": {Max: 3}}},
+				{Hash: "b", Calls: 1, Percentiles: map[string]float64{"p99": 3}, Distributions: map[string]Distribution{
+					"rows_examined": {Percentiles: map[string]float64{"p99": 30}}, "lock_time": {Max: 2}}},
+				{Hash: "c", Calls: 3, Percentiles: map[string]float64{"p99": 2}, Distributions: map[string]Distribution{
+					"rows_examined": {Percentiles: map[string]float64{"p99": 10}}, "lock_time": {Max: 1}}},
+			}
+			err := Sort(s, tt.order, tt.dec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Sort() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			var hashes []string
+			for _, s := range s {
+				hashes = append(hashes, s.Hash)
+			}
+			if !reflect.DeepEqual(hashes, tt.want) {
+				t.Errorf("Sort() = %v, want %v", hashes, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package digest
+
+import (
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/devops-works/slowql/sketch"
+)
+
+// Distribution summarizes the distribution of a metric
+type Distribution struct {
+	Min  float64
+	Max  float64
+	Mean float64
+	// Percentiles are the percentiles of the metric, by name (p50, p99.9)
+	Percentiles map[string]float64
+}
+
+// PercentileName returns the name of a percentile, such as p99.9
+func PercentileName(p float64) string {
+	return "p" + strconv.FormatFloat(p, 'f', -1, 64)
+}
+
+// Compute computes the mean, the standard deviation, the percentiles of the
+// query time, the distributions of the other metrics and the concurrency of
+// each entry. realDuration is the time span of the queries, and percentiles
+// are between 0 and 100. The concurrency is 0 when the queries span no time,
+// such as a single query
+func Compute(entries []Entry, realDuration time.Duration, percentiles []float64) []Entry {
+	var ffactor float64
+	if realDuration > 0 {
+		ffactor = 100.0 * float64(time.Second) / float64(realDuration)
+	}
+	for i := 0; i < len(entries); i++ {
+
+		// Mean time
+		entries[i].MeanTime = entries[i].CumQueryTime / float64(entries[i].Calls)
+
+		// Compute percentiles and stddev from the distribution
+		entries[i].Percentiles = make(map[string]float64)
+		if entries[i].Sketch != nil {
+			for _, p := range percentiles {
+				entries[i].Percentiles[PercentileName(p)] = entries[i].Sketch.Quantile(p / 100)
+			}
+			entries[i].StddevTime = entries[i].Sketch.StdDev()
+		}
+		entries[i].Distributions = make(map[string]Distribution)
+		for m, sk := range entries[i].Sketches {
+			entries[i].Distributions[m] = summarize(m, sk, percentiles)
+		}
+
+		// compute concurrency
+		entries[i].Concurrency = entries[i].CumQueryTime * ffactor
+	}
+
+	return entries
+}
+
+// summarize returns the distribution of a metric from its sketch. The
+// percentiles of counts are rounded, as they are approximated
+func summarize(metric string, sk *sketch.Sketch, percentiles []float64) Distribution {
+	d := Distribution{
+		Min:         sk.Min(),
+		Max:         sk.Max(),
+		Mean:        sk.Mean(),
+		Percentiles: make(map[string]float64),
+	}
+	for _, p := range percentiles {
+		v := sk.Quantile(p / 100)
+		if metric != "lock_time" {
+			v = math.Round(v)
+		}
+		d.Percentiles[PercentileName(p)] = v
+	}
+	return d
+}
@@ -0,0 +1,57 @@
+package digest
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/devops-works/slowql/sketch"
+)
+
+func testSketch(values ...float64) *sketch.Sketch {
+	s := sketch.New()
+	for _, v := range values {
+		s.Add(v)
+	}
+	return s
+}
+
+func TestCompute(t *testing.T) {
+	res := []Entry{
+		{Calls: 4, CumQueryTime: 4, Sketch: testSketch(0.5, 0.5, 1, 2), Sketches: map[string]*sketch.Sketch{
+			"rows_examined": testSketch(10, 20, 20, 40),
+			"lock_time":     testSketch(0.001, 0.001, 0.001, 0.005),
+		}},
+		{Calls: 1, CumQueryTime: 1},
+	}
+	got := Compute(res, 10*time.Second, []float64{50, 99.9})
+
+	tests := []struct {
+		name string
+		got  float64
+		want float64
+	}{
+		{name: "mean", got: got[0].MeanTime, want: 1},
+		{name: "p50", got: got[0].Percentiles["p50"], want: 0.5},
+		{name: "p99.9", got: got[0].Percentiles["p99.9"], want: 1},
+		{name: "stddev", got: got[0].StddevTime, want: math.Sqrt(0.375)},
+		{name: "concurrency", got: got[0].Concurrency, want: 40},
+		{name: "no distribution", got: got[1].Percentiles["p50"], want: 0},
+		{name: "rows examined min", got: got[0].Distributions["rows_examined"].Min, want: 10},
+		{name: "rows examined mean", got: got[0].Distributions["rows_examined"].Mean, want: 22.5},
+		{name: "rows examined p50", got: got[0].Distributions["rows_examined"].Percentiles["p50"], want: 20},
+		{name: "lock time p99.9", got: got[0].Distributions["lock_time"].Percentiles["p99.9"], want: 0.001},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if math.Abs(tt.got-tt.want) > 0.01*tt.want {
+				t.Errorf("Compute() %s = %v, want %v", tt.name, tt.got, tt.want)
+			}
+		})
+	}
+
+	// queries logged at the same time span no time
+	if got := Compute([]Entry{{Calls: 1, CumQueryTime: 1}}, 0, nil); got[0].Concurrency != 0 {
+		t.Errorf("Compute() concurrency over no time = %v, want 0", got[0].Concurrency)
+	}
+}
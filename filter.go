@@ -0,0 +1,625 @@
+package slowql
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/devops-works/slowql/query"
+)
+
+// Filter is a boolean expression evaluated against queries, such as:
+//
+//	query_time > 1 && schema == "shop" && user =~ "^app_" && rows_examined > 100*rows_sent
+//
+// The fields are the ones of query.Query, in snake case: time, query_time,
+// lock_time, id, rows_sent, rows_examined, rows_affected, last_errno, killed,
+// bytes_sent, user, host, schema, query and qc_hit. The class of the query
+// (read, write, ddl, transaction or session) is available as class, and the
+// database specific attributes as extra.<name>.
+//
+// Numbers support the + - * / % operators, and durations such as 500ms are
+// converted to seconds. Strings are quoted with double or single quotes, and
+// =~ and !~ match them against regular expressions. Times are compared to
+// strings such as "2021-03-23 11:31:57" or "2021-03-23T11:31:57Z". Conditions
+// are combined with &&, || and !, and grouped with parentheses.
+type Filter struct {
+	expr  string
+	match func(*query.Query) bool
+}
+
+// NewFilter compiles a filter expression
+func NewFilter(expr string) (*Filter, error) {
+	tokens, err := scanFilter(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	p := filterParser{tokens: tokens}
+	op, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != filterEOF {
+		return nil, fmt.Errorf("unexpected %q at offset %d", t.value, t.pos)
+	}
+	if op.typ != boolType {
+		return nil, fmt.Errorf("filter must be a condition, not a %s", op.typ)
+	}
+
+	return &Filter{expr: expr, match: op.boolean}, nil
+}
+
+// Match tells if a query matches the filter
+func (f *Filter) Match(q query.Query) bool {
+	return f.match(&q)
+}
+
+// String returns the expression of the filter
+func (f *Filter) String() string {
+	return f.expr
+}
+
+type filterType int
+
+const (
+	numberType filterType = iota
+	stringType
+	boolType
+	timeType
+)
+
+func (t filterType) String() string {
+	switch t {
+	case numberType:
+		return "number"
+	case stringType:
+		return "string"
+	case boolType:
+		return "boolean"
+	}
+	return "time"
+}
+
+// operand is a compiled expression. Numbers and times are evaluated by num,
+// times being converted to seconds since the epoch
+type operand struct {
+	typ     filterType
+	num     func(*query.Query) float64
+	str     func(*query.Query) string
+	boolean func(*query.Query) bool
+	// literal holds the value of string constants, which are needed to compile
+	// regular expressions and to parse times once
+	literal *string
+	pos     int
+}
+
+// filterFields lists the fields that can be used in filters
+var filterFields = map[string]operand{
+	"time":          {typ: timeType, num: func(q *query.Query) float64 { return float64(q.Time.UnixNano()) / 1e9 }},
+	"query_time":    {typ: numberType, num: func(q *query.Query) float64 { return q.QueryTime }},
+	"lock_time":     {typ: numberType, num: func(q *query.Query) float64 { return q.LockTime }},
+	"id":            {typ: numberType, num: func(q *query.Query) float64 { return float64(q.ID) }},
+	"rows_sent":     {typ: numberType, num: func(q *query.Query) float64 { return float64(q.RowsSent) }},
+	"rows_examined": {typ: numberType, num: func(q *query.Query) float64 { return float64(q.RowsExamined) }},
+	"rows_affected": {typ: numberType, num: func(q *query.Query) float64 { return float64(q.RowsAffected) }},
+	"last_errno":    {typ: numberType, num: func(q *query.Query) float64 { return float64(q.LastErrNo) }},
+	"killed":        {typ: numberType, num: func(q *query.Query) float64 { return float64(q.Killed) }},
+	"bytes_sent":    {typ: numberType, num: func(q *query.Query) float64 { return float64(q.BytesSent) }},
+	"user":          {typ: stringType, str: func(q *query.Query) string { return q.User }},
+	"host":          {typ: stringType, str: func(q *query.Query) string { return q.Host }},
+	"schema":        {typ: stringType, str: func(q *query.Query) string { return q.Schema }},
+	"query":         {typ: stringType, str: func(q *query.Query) string { return q.Query }},
+	"class":         {typ: stringType, str: func(q *query.Query) string { return q.Class().String() }},
+	"qc_hit":        {typ: boolType, boolean: func(q *query.Query) bool { return q.QCHit }},
+}
+
+// FilterFields returns the names of the fields that can be used in filters
+func FilterFields() []string {
+	var fields []string
+	for f := range filterFields {
+		fields = append(fields, f)
+	}
+	sort.Strings(fields)
+	return append(fields, "extra.<name>")
+}
+
+// timeLayouts lists the layouts of the times that can be compared to the time
+// field. Times without time zone are in UTC
+var timeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+type filterTokenKind int
+
+const (
+	filterEOF filterTokenKind = iota
+	filterNumber
+	filterString
+	filterIdent
+	filterOperator
+)
+
+type filterToken struct {
+	kind  filterTokenKind
+	value string
+	// num is the value of numbers, in seconds for durations
+	num float64
+	pos int
+}
+
+// filterOperators lists the operators, longest first
+var filterOperators = []string{"&&", "||", "==", "!=", "<=", ">=", "=~", "!~", "<", ">", "!", "+", "-", "*", "/", "%", "(", ")"}
+
+// scanFilter splits a filter expression into tokens
+func scanFilter(expr string) ([]filterToken, error) {
+	var tokens []filterToken
+	pos := 0
+	for pos < len(expr) {
+		c := expr[pos]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			pos++
+
+		case c >= '0' && c <= '9' || c == '.':
+			end, duration := scanFilterNumber(expr, pos)
+			t := filterToken{kind: filterNumber, value: expr[pos:end], pos: pos}
+			var err error
+			if !duration {
+				t.num, err = strconv.ParseFloat(t.value, 64)
+			} else {
+				var d time.Duration
+				d, err = time.ParseDuration(t.value)
+				t.num = d.Seconds()
+			}
+			if err != nil {
+				return nil, fmt.Errorf("invalid number %q at offset %d", t.value, pos)
+			}
+			tokens = append(tokens, t)
+			pos = end
+
+		case c == '"' || c == '\'':
+			var b strings.Builder
+			end := pos + 1
+			for ; end < len(expr) && expr[end] != c; end++ {
+				// only quotes and backslashes are escaped, so that regular
+				// expressions such as "\d+" can be written as is
+				if expr[end] == '\\' && end+1 < len(expr) && (expr[end+1] == c || expr[end+1] == '\\') {
+					end++
+				}
+				b.WriteByte(expr[end])
+			}
+			if end >= len(expr) {
+				return nil, fmt.Errorf("unterminated string at offset %d", pos)
+			}
+			tokens = append(tokens, filterToken{kind: filterString, value: b.String(), pos: pos})
+			pos = end + 1
+
+		case isFilterIdentChar(c):
+			end := pos
+			for end < len(expr) && (isFilterIdentChar(expr[end]) || expr[end] == '.' || expr[end] >= '0' && expr[end] <= '9') {
+				end++
+			}
+			tokens = append(tokens, filterToken{kind: filterIdent, value: expr[pos:end], pos: pos})
+			pos = end
+
+		default:
+			found := false
+			for _, op := range filterOperators {
+				if strings.HasPrefix(expr[pos:], op) {
+					tokens = append(tokens, filterToken{kind: filterOperator, value: op, pos: pos})
+					pos += len(op)
+					found = true
+					break
+				}
+			}
+			if !found {
+				return nil, fmt.Errorf("unexpected %q at offset %d", c, pos)
+			}
+		}
+	}
+	return append(tokens, filterToken{kind: filterEOF, value: "end of filter", pos: len(expr)}), nil
+}
+
+// scanFilterNumber returns the end of the number starting at pos, and whether
+// it is a duration, such as 500ms or 1h30m, rather than a plain number such as
+// 1.5 or 1e3
+func scanFilterNumber(expr string, pos int) (int, bool) {
+	digits := func(end int) int {
+		for end < len(expr) && (expr[end] >= '0' && expr[end] <= '9' || expr[end] == '.') {
+			end++
+		}
+		return end
+	}
+	end := digits(pos)
+
+	// exponent, which has to be told apart from a unit
+	if e := end; e < len(expr) && (expr[e] == 'e' || expr[e] == 'E') {
+		e++
+		if e < len(expr) && (expr[e] == '+' || expr[e] == '-') {
+			e++
+		}
+		if e < len(expr) && expr[e] >= '0' && expr[e] <= '9' {
+			return digits(e), false
+		}
+	}
+
+	// units and digits alternate in durations
+	num := end
+	for {
+		unit := end
+		for unit < len(expr) && isFilterIdentChar(expr[unit]) {
+			unit++
+		}
+		if unit == end {
+			break
+		}
+		end = digits(unit)
+	}
+	return end, end != num
+}
+
+func isFilterIdentChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_' || c >= 0x80
+}
+
+// filterParser is a recursive descent parser, from the lowest precedence to
+// the highest: ||, &&, !, comparisons, + and -, * / and %, unary minus
+type filterParser struct {
+	tokens []filterToken
+	pos    int
+}
+
+func (p *filterParser) peek() filterToken {
+	return p.tokens[p.pos]
+}
+
+func (p *filterParser) next() filterToken {
+	t := p.tokens[p.pos]
+	if t.kind != filterEOF {
+		p.pos++
+	}
+	return t
+}
+
+// accept consumes the next token if it is one of the operators
+func (p *filterParser) accept(ops ...string) (filterToken, bool) {
+	t := p.peek()
+	if t.kind != filterOperator {
+		return t, false
+	}
+	for _, op := range ops {
+		if t.value == op {
+			p.pos++
+			return t, true
+		}
+	}
+	return t, false
+}
+
+func (p *filterParser) or() (operand, error) {
+	left, err := p.and()
+	if err != nil {
+		return left, err
+	}
+	for {
+		t, ok := p.accept("||")
+		if !ok {
+			return left, nil
+		}
+		right, err := p.and()
+		if err != nil {
+			return right, err
+		}
+		if err := expect(t, boolType, left, right); err != nil {
+			return left, err
+		}
+		l, r := left.boolean, right.boolean
+		left = operand{typ: boolType, pos: left.pos, boolean: func(q *query.Query) bool { return l(q) || r(q) }}
+	}
+}
+
+func (p *filterParser) and() (operand, error) {
+	left, err := p.not()
+	if err != nil {
+		return left, err
+	}
+	for {
+		t, ok := p.accept("&&")
+		if !ok {
+			return left, nil
+		}
+		right, err := p.not()
+		if err != nil {
+			return right, err
+		}
+		if err := expect(t, boolType, left, right); err != nil {
+			return left, err
+		}
+		l, r := left.boolean, right.boolean
+		left = operand{typ: boolType, pos: left.pos, boolean: func(q *query.Query) bool { return l(q) && r(q) }}
+	}
+}
+
+func (p *filterParser) not() (operand, error) {
+	t, ok := p.accept("!")
+	if !ok {
+		return p.comparison()
+	}
+	op, err := p.not()
+	if err != nil {
+		return op, err
+	}
+	if err := expect(t, boolType, op); err != nil {
+		return op, err
+	}
+	b := op.boolean
+	return operand{typ: boolType, pos: t.pos, boolean: func(q *query.Query) bool { return !b(q) }}, nil
+}
+
+func (p *filterParser) comparison() (operand, error) {
+	left, err := p.sum()
+	if err != nil {
+		return left, err
+	}
+	t, ok := p.accept("==", "!=", "<", "<=", ">", ">=", "=~", "!~")
+	if !ok {
+		return left, nil
+	}
+	right, err := p.sum()
+	if err != nil {
+		return right, err
+	}
+
+	if t.value == "=~" || t.value == "!~" {
+		return matchOperands(t, left, right)
+	}
+
+	// times are compared to strings
+	if left.typ == timeType && right.typ == stringType {
+		if right, err = toTime(right); err != nil {
+			return right, err
+		}
+	} else if left.typ == stringType && right.typ == timeType {
+		if left, err = toTime(left); err != nil {
+			return left, err
+		}
+	}
+	if left.typ != right.typ {
+		return left, fmt.Errorf("cannot compare %s and %s with %s at offset %d", left.typ, right.typ, t.value, t.pos)
+	}
+
+	res := operand{typ: boolType, pos: left.pos}
+	switch left.typ {
+	case numberType, timeType:
+		l, r := left.num, right.num
+		switch t.value {
+		case "==":
+			res.boolean = func(q *query.Query) bool { return l(q) == r(q) }
+		case "!=":
+			res.boolean = func(q *query.Query) bool { return l(q) != r(q) }
+		case "<":
+			res.boolean = func(q *query.Query) bool { return l(q) < r(q) }
+		case "<=":
+			res.boolean = func(q *query.Query) bool { return l(q) <= r(q) }
+		case ">":
+			res.boolean = func(q *query.Query) bool { return l(q) > r(q) }
+		case ">=":
+			res.boolean = func(q *query.Query) bool { return l(q) >= r(q) }
+		}
+	case stringType:
+		l, r := left.str, right.str
+		switch t.value {
+		case "==":
+			res.boolean = func(q *query.Query) bool { return l(q) == r(q) }
+		case "!=":
+			res.boolean = func(q *query.Query) bool { return l(q) != r(q) }
+		case "<":
+			res.boolean = func(q *query.Query) bool { return l(q) < r(q) }
+		case "<=":
+			res.boolean = func(q *query.Query) bool { return l(q) <= r(q) }
+		case ">":
+			res.boolean = func(q *query.Query) bool { return l(q) > r(q) }
+		case ">=":
+			res.boolean = func(q *query.Query) bool { return l(q) >= r(q) }
+		}
+	case boolType:
+		l, r := left.boolean, right.boolean
+		switch t.value {
+		case "==":
+			res.boolean = func(q *query.Query) bool { return l(q) == r(q) }
+		case "!=":
+			res.boolean = func(q *query.Query) bool { return l(q) != r(q) }
+		default:
+			return left, fmt.Errorf("cannot compare booleans with %s at offset %d", t.value, t.pos)
+		}
+	}
+	return res, nil
+}
+
+// matchOperands compiles the =~ and !~ operators, which need a regular
+// expression on the right
+func matchOperands(t filterToken, left, right operand) (operand, error) {
+	if err := expect(t, stringType, left, right); err != nil {
+		return left, err
+	}
+	if right.literal == nil {
+		return right, fmt.Errorf("%s needs a regular expression at offset %d", t.value, right.pos)
+	}
+	re, err := regexp.Compile(*right.literal)
+	if err != nil {
+		return right, fmt.Errorf("invalid regular expression at offset %d: %s", right.pos, err)
+	}
+
+	l := left.str
+	if t.value == "!~" {
+		return operand{typ: boolType, pos: left.pos, boolean: func(q *query.Query) bool { return !re.MatchString(l(q)) }}, nil
+	}
+	return operand{typ: boolType, pos: left.pos, boolean: func(q *query.Query) bool { return re.MatchString(l(q)) }}, nil
+}
+
+// toTime converts a string constant to a time
+func toTime(op operand) (operand, error) {
+	if op.literal == nil {
+		return op, fmt.Errorf("cannot compare a time to a string field at offset %d", op.pos)
+	}
+	for _, layout := range timeLayouts {
+		if t, err := time.Parse(layout, *op.literal); err == nil {
+			secs := float64(t.UnixNano()) / 1e9
+			return operand{typ: timeType, pos: op.pos, num: func(*query.Query) float64 { return secs }}, nil
+		}
+	}
+	return op, fmt.Errorf("invalid time %q at offset %d", *op.literal, op.pos)
+}
+
+func (p *filterParser) sum() (operand, error) {
+	left, err := p.product()
+	if err != nil {
+		return left, err
+	}
+	for {
+		t, ok := p.accept("+", "-")
+		if !ok {
+			return left, nil
+		}
+		right, err := p.product()
+		if err != nil {
+			return right, err
+		}
+		if left, err = arithmetic(t, left, right); err != nil {
+			return left, err
+		}
+	}
+}
+
+func (p *filterParser) product() (operand, error) {
+	left, err := p.unary()
+	if err != nil {
+		return left, err
+	}
+	for {
+		t, ok := p.accept("*", "/", "%")
+		if !ok {
+			return left, nil
+		}
+		right, err := p.unary()
+		if err != nil {
+			return right, err
+		}
+		if left, err = arithmetic(t, left, right); err != nil {
+			return left, err
+		}
+	}
+}
+
+// arithmetic compiles the arithmetic operators
+func arithmetic(t filterToken, left, right operand) (operand, error) {
+	if err := expect(t, numberType, left, right); err != nil {
+		return left, err
+	}
+
+	l, r := left.num, right.num
+	res := operand{typ: numberType, pos: left.pos}
+	switch t.value {
+	case "+":
+		res.num = func(q *query.Query) float64 { return l(q) + r(q) }
+	case "-":
+		res.num = func(q *query.Query) float64 { return l(q) - r(q) }
+	case "*":
+		res.num = func(q *query.Query) float64 { return l(q) * r(q) }
+	case "/":
+		res.num = func(q *query.Query) float64 { return l(q) / r(q) }
+	case "%":
+		res.num = func(q *query.Query) float64 {
+			d := int64(r(q))
+			if d == 0 {
+				return 0
+			}
+			return float64(int64(l(q)) % d)
+		}
+	}
+	return res, nil
+}
+
+func (p *filterParser) unary() (operand, error) {
+	t, ok := p.accept("-")
+	if !ok {
+		return p.primary()
+	}
+	op, err := p.unary()
+	if err != nil {
+		return op, err
+	}
+	if err := expect(t, numberType, op); err != nil {
+		return op, err
+	}
+	n := op.num
+	return operand{typ: numberType, pos: t.pos, num: func(q *query.Query) float64 { return -n(q) }}, nil
+}
+
+func (p *filterParser) primary() (operand, error) {
+	t := p.next()
+	switch t.kind {
+	case filterNumber:
+		n := t.num
+		return operand{typ: numberType, pos: t.pos, num: func(*query.Query) float64 { return n }}, nil
+
+	case filterString:
+		s := t.value
+		return operand{typ: stringType, pos: t.pos, literal: &s, str: func(*query.Query) string { return s }}, nil
+
+	case filterIdent:
+		switch t.value {
+		case "true", "false":
+			b := t.value == "true"
+			return operand{typ: boolType, pos: t.pos, boolean: func(*query.Query) bool { return b }}, nil
+		}
+		if strings.HasPrefix(t.value, "extra.") {
+			key := strings.TrimPrefix(t.value, "extra.")
+			return operand{typ: stringType, pos: t.pos, str: func(q *query.Query) string { return q.Extra[key] }}, nil
+		}
+		f, ok := filterFields[t.value]
+		if !ok {
+			return operand{}, fmt.Errorf("unknown field %q at offset %d, available fields are: %s",
+				t.value, t.pos, strings.Join(FilterFields(), ", "))
+		}
+		f.pos = t.pos
+		return f, nil
+
+	case filterOperator:
+		if t.value == "(" {
+			op, err := p.or()
+			if err != nil {
+				return op, err
+			}
+			if _, ok := p.accept(")"); !ok {
+				c := p.peek()
+				return op, fmt.Errorf("expected ) at offset %d, got %q", c.pos, c.value)
+			}
+			return op, nil
+		}
+	}
+
+	if t.kind == filterEOF {
+		return operand{}, errors.New("unexpected end of filter")
+	}
+	return operand{}, fmt.Errorf("unexpected %q at offset %d", t.value, t.pos)
+}
+
+// expect ensures that the operands of an operator have the right type
+func expect(t filterToken, typ filterType, ops ...operand) error {
+	for _, op := range ops {
+		if op.typ != typ {
+			return fmt.Errorf("%s expects a %s, got a %s at offset %d", t.value, typ, op.typ, op.pos)
+		}
+	}
+	return nil
+}
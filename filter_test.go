@@ -0,0 +1,109 @@
+package slowql
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/devops-works/slowql/query"
+)
+
+func TestFilter_Match(t *testing.T) {
+	q := query.Query{
+		Time:         time.Date(2021, 3, 23, 11, 31, 57, 0, time.UTC),
+		QueryTime:    1.5,
+		LockTime:     0.0002,
+		ID:           42,
+		RowsSent:     10,
+		RowsExamined: 5000,
+		BytesSent:    1024,
+		User:         "app_shop",
+		Host:         "10.0.0.12",
+		Schema:       "shop",
+		Query:        "SELECT * FROM orders WHERE status = 'pending'",
+		Extra:        map[string]string{"Plan_digest": "abc"},
+	}
+
+	tests := []struct {
+		name string
+		expr string
+		want bool
+	}{
+		{name: "request example", expr: `query_time > 1 && schema == "shop" && user =~ "^app_" && rows_examined > 100*rows_sent`, want: true},
+		{name: "number", expr: "query_time >= 1.5", want: true},
+		{name: "number false", expr: "query_time < 1", want: false},
+		{name: "duration", expr: "query_time > 500ms && lock_time < 1ms", want: true},
+		{name: "compound duration", expr: "query_time > 1m30s || query_time < 1s500ms", want: false},
+		{name: "exponent", expr: "rows_examined == 5e3 && lock_time == 2E-4 && bytes_sent < 1.1e+3", want: true},
+		{name: "arithmetic", expr: "rows_examined / rows_sent == 500 && id % 10 == 2 && -id < 0 && bytes_sent - 24 == 1000", want: true},
+		{name: "precedence", expr: "rows_sent + 2 * 5 == 20", want: true},
+		{name: "single quotes", expr: "host == '10.0.0.12'", want: true},
+		{name: "not equal", expr: `schema != "shop"`, want: false},
+		{name: "regexp", expr: `query =~ "(?i)from\\s+orders"`, want: true},
+		{name: "regexp with backslashes", expr: `host =~ "^10\.0\.\d+\.12$"`, want: true},
+		{name: "not match", expr: `user !~ "^app_"`, want: false},
+		{name: "or", expr: `schema == "crm" || killed == 0`, want: true},
+		{name: "not", expr: `!(schema == "crm")`, want: true},
+		{name: "parentheses", expr: `(schema == "crm" || schema == "shop") && !qc_hit`, want: true},
+		{name: "boolean", expr: "qc_hit == false", want: true},
+		{name: "time", expr: `time >= "2021-03-23" && time < "2021-03-23 12:00:00"`, want: true},
+		{name: "time before", expr: `time < "2021-03-23T11:31:57Z"`, want: false},
+		{name: "class", expr: `class == "read"`, want: true},
+		{name: "extra", expr: `extra.Plan_digest == "abc" && extra.Missing == ""`, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := NewFilter(tt.expr)
+			if err != nil {
+				t.Fatalf("NewFilter() error = %v", err)
+			}
+			if got := f.Match(q); got != tt.want {
+				t.Errorf("Filter.Match() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewFilter(t *testing.T) {
+	tests := []struct {
+		name    string
+		expr    string
+		wantErr string
+	}{
+		{name: "unknown field", expr: "query_tme > 1", wantErr: `unknown field "query_tme" at offset 0, available fields are: bytes_sent, class,`},
+		{name: "not a condition", expr: "query_time", wantErr: "filter must be a condition, not a number"},
+		{name: "type mismatch", expr: `query_time > "1"`, wantErr: "cannot compare number and string with > at offset 11"},
+		{name: "logical on numbers", expr: "query_time && 1", wantErr: "&& expects a boolean, got a number at offset 0"},
+		{name: "regexp needs a literal", expr: "user =~ host", wantErr: "=~ needs a regular expression at offset 8"},
+		{name: "invalid regexp", expr: `user =~ "("`, wantErr: "invalid regular expression at offset 8"},
+		{name: "invalid time", expr: `time > "yesterday"`, wantErr: `invalid time "yesterday" at offset 7`},
+		{name: "duration missing unit", expr: "query_time > 1h30", wantErr: `invalid number "1h30" at offset 13`},
+		{name: "unterminated string", expr: `user == "app`, wantErr: "unterminated string at offset 8"},
+		{name: "missing parenthesis", expr: "(query_time > 1", wantErr: `expected ) at offset 15, got "end of filter"`},
+		{name: "trailing tokens", expr: "query_time > 1 1", wantErr: `unexpected "1" at offset 15`},
+		{name: "unexpected character", expr: "query_time > 1 & lock_time > 1", wantErr: `unexpected '&' at offset 15`},
+		{name: "empty", expr: "", wantErr: "unexpected end of filter"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewFilter(tt.expr)
+			if err == nil {
+				t.Fatalf("NewFilter() error = nil, want %q", tt.wantErr)
+			}
+			if !strings.HasPrefix(err.Error(), tt.wantErr) {
+				t.Errorf("NewFilter() error = %q, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func BenchmarkFilter_Match(b *testing.B) {
+	f, err := NewFilter(`query_time > 1 && schema == "shop" && user =~ "^app_" && rows_examined > 100*rows_sent`)
+	if err != nil {
+		b.Fatal(err)
+	}
+	q := query.Query{QueryTime: 2, Schema: "shop", User: "app_shop", RowsExamined: 5000, RowsSent: 10}
+	for i := 0; i < b.N; i++ {
+		f.Match(q)
+	}
+}
@@ -0,0 +1,412 @@
+// Package fingerprint computes the fingerprint of SQL queries: a normalised
+// version of the query, where literals are replaced by placeholders, so that
+// queries that only differ by their values are grouped together.
+//
+// Fingerprints follow the pt-query-digest (QueryRewriter) rules:
+//
+//  1. Group all SELECT queries from mysqldump together, even if they are
+//     against different tables. The same applies to all queries from
+//     pt-table-checksum.
+//  2. Shorten multi-value INSERT statements to a single VALUES() list.
+//  3. Strip comments.
+//  4. Abstract the databases in USE statements, so all USE statements are
+//     grouped together.
+//  5. Replace all literals, such as quoted strings. Hexadecimal literals are
+//     also replaced. NULL is treated as a literal. Numbers embedded in
+//     identifiers are also replaced, so tables named similarly will be
+//     fingerprinted to the same values (e.g. users_2009 and users_2010 will
+//     fingerprint identically).
+//  6. Collapse all whitespace into a single space.
+//  7. Lowercase the entire query.
+//  8. Replace all literals inside of IN() and VALUES() lists with a single
+//     placeholder, regardless of cardinality.
+//  9. Collapse multiple identical UNION queries into a single one.
+//  10. Replace LIMIT offset, count and LIMIT count OFFSET offset with a single
+//     placeholder.
+//  11. Strip ASC from ORDER BY, since it is the default order.
+//
+// Unlike pt-query-digest, which relies on regular expressions, the query is
+// tokenized first, so escaped quotes, nested comments, IN lists containing
+// parentheses, negative and hexadecimal numbers and double-quoted strings are
+// handled properly.
+package fingerprint
+
+import (
+	"crypto/md5"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/devops-works/slowql/query/lexer"
+)
+
+// token is a significant token of the fingerprint, that is to say neither a
+// whitespace nor a comment
+type token struct {
+	lexer.Token
+	// space is true if the token was preceded by whitespace or a comment
+	space bool
+}
+
+// mysqldump matches the SELECT queries that mysqldump uses to dump tables
+var mysqldump = regexp.MustCompile("^SELECT /\\*!40001 SQL_NO_CACHE \\*/ \\* FROM `")
+
+// perconaToolkit matches the comment that pt-table-checksum adds to its
+// queries, such as /*db.tbl:1/3*/
+var perconaToolkit = regexp.MustCompile(`/\*\w+\.\w+:[0-9]/[0-9]\*/`)
+
+// expressionStarts lists the keywords after which a sign is unary, such as in
+// SELECT -1 or WHERE a = 1 AND -2 < b
+var expressionStarts = map[string]bool{
+	"select": true, "where": true, "and": true, "or": true, "not": true,
+	"on": true, "set": true, "values": true, "value": true, "then": true,
+	"else": true, "when": true, "by": true, "limit": true, "offset": true,
+	"in": true, "is": true, "like": true, "between": true, "case": true,
+	"having": true, "return": true, "interval": true,
+}
+
+// Fingerprint returns the fingerprint of a query. If the query is malformed,
+// such as a query truncated in the middle of a string, the returned
+// fingerprint is still usable and the error tells what is wrong
+func Fingerprint(q string) (string, error) {
+	return FingerprintDialect(q, lexer.MySQL)
+}
+
+// FingerprintDialect returns the fingerprint of a query of the given dialect,
+// so that the double-quoted identifiers of PostgreSQL are kept
+func FingerprintDialect(q string, d lexer.Dialect) (string, error) {
+	// the tools are recognised by their comments, so this is done before
+	// tokenizing
+	if mysqldump.MatchString(q) {
+		return "mysqldump", nil
+	}
+	if perconaToolkit.MatchString(q) {
+		return "percona-toolkit", nil
+	}
+
+	raw, err := lexer.TokenizeDialect(q, d)
+
+	tokens := significant(raw)
+	if len(tokens) == 2 && tokens[0].Value == "use" {
+		return "use ?", err
+	}
+
+	tokens = shortenInsert(tokens)
+	tokens = replaceLiterals(tokens)
+	tokens = collapseLists(tokens)
+	tokens = collapseUnions(tokens)
+	tokens = collapseLimits(tokens)
+	tokens = stripAsc(tokens)
+
+	return join(tokens), err
+}
+
+// Hash returns the MD5 hash of a fingerprint, as an hexadecimal string
+func Hash(fingerprint string) string {
+	data := []byte(fingerprint)
+	return fmt.Sprintf("%x", md5.Sum(data))
+}
+
+// significant drops whitespace and comments, and lowercases the tokens
+func significant(raw []lexer.Token) []token {
+	var tokens []token
+	space := false
+	for _, t := range raw {
+		if t.Kind == lexer.Whitespace || t.Kind == lexer.Comment {
+			space = true
+			continue
+		}
+		if t.Kind != lexer.String {
+			t.Value = strings.ToLower(t.Value)
+		}
+		tokens = append(tokens, token{Token: t, space: space})
+		space = false
+	}
+
+	// the trailing semicolons are not part of the statement
+	for len(tokens) > 0 && tokens[len(tokens)-1].Value == ";" {
+		tokens = tokens[:len(tokens)-1]
+	}
+	return tokens
+}
+
+// shortenInsert keeps only the first VALUES() list of multi-value INSERT and
+// REPLACE statements
+func shortenInsert(tokens []token) []token {
+	if len(tokens) == 0 || (tokens[0].Value != "insert" && tokens[0].Value != "replace") {
+		return tokens
+	}
+
+	for i, t := range tokens {
+		if t.Kind != lexer.Word || (t.Value != "values" && t.Value != "value") {
+			continue
+		}
+		if i+1 >= len(tokens) || tokens[i+1].Value != "(" {
+			return tokens
+		}
+
+		// skip the following lists
+		end := closing(tokens, i+1)
+		next := end + 1
+		for next+1 < len(tokens) && tokens[next].Value == "," && tokens[next+1].Value == "(" {
+			next = closing(tokens, next+1) + 1
+		}
+		if next == end+1 {
+			return tokens
+		}
+		return append(tokens[:end+1:end+1], tokens[next:]...)
+	}
+	return tokens
+}
+
+// replaceLiterals replaces strings, numbers, booleans, NULL and placeholders
+// such as $1 by ?, and the numbers embedded in identifiers
+func replaceLiterals(tokens []token) []token {
+	var res []token
+	for i := 0; i < len(tokens); i++ {
+		t := tokens[i]
+		switch {
+		case t.Kind == lexer.String || t.Kind == lexer.Number || t.Kind == lexer.Placeholder:
+			t.Value = "?"
+
+		case t.Kind == lexer.Word && (t.Value == "null" || t.Value == "true" || t.Value == "false"):
+			t.Value = "?"
+
+		case t.Kind == lexer.Word || t.Kind == lexer.QuotedIdentifier:
+			t.Value = replaceDigits(t.Value)
+
+		case isSign(t) && i+1 < len(tokens) && tokens[i+1].Kind == lexer.Number && !tokens[i+1].space && isUnary(res):
+			// the sign belongs to the number
+			t.Value = "?"
+			t.Kind = lexer.Number
+			i++
+		}
+		res = append(res, t)
+	}
+	return res
+}
+
+// collapseLists replaces IN() and VALUES() lists made only of literals by a
+// single placeholder. Several VALUES() lists are collapsed together
+func collapseLists(tokens []token) []token {
+	var res []token
+	for i := 0; i < len(tokens); i++ {
+		t := tokens[i]
+		res = append(res, t)
+		if t.Kind != lexer.Word || i+1 >= len(tokens) || tokens[i+1].Value != "(" {
+			continue
+		}
+		if t.Value != "in" && t.Value != "values" && t.Value != "value" {
+			continue
+		}
+
+		end := closing(tokens, i+1)
+		if !onlyLiterals(tokens[i+2 : end]) {
+			continue
+		}
+		if t.Value != "in" {
+			for end+2 < len(tokens) && tokens[end+1].Value == "," && tokens[end+2].Value == "(" {
+				next := closing(tokens, end+2)
+				if !onlyLiterals(tokens[end+3 : next]) {
+					break
+				}
+				end = next
+			}
+		}
+		res = append(res, token{Token: lexer.Token{Kind: lexer.Placeholder, Value: "(?+)"}})
+		i = end
+	}
+	return res
+}
+
+// collapseUnions replaces identical queries joined by UNION with a single one,
+// followed by a /*repeat union*/ comment. Subqueries are collapsed too
+func collapseUnions(tokens []token) []token {
+	var collapsed []token
+	for i := 0; i < len(tokens); i++ {
+		collapsed = append(collapsed, tokens[i])
+		if tokens[i].Value != "(" {
+			continue
+		}
+		end := closing(tokens, i)
+		if tokens[end].Value != ")" {
+			continue
+		}
+		collapsed = append(collapsed, collapseUnions(tokens[i+1:end])...)
+		collapsed = append(collapsed, tokens[end])
+		i = end
+	}
+	tokens = collapsed
+
+	// split the query at the top-level UNION keywords
+	var parts [][]token
+	var unions [][]token
+	depth, start := 0, 0
+	for i := 0; i < len(tokens); i++ {
+		switch {
+		case tokens[i].Value == "(":
+			depth++
+		case tokens[i].Value == ")":
+			depth--
+		case depth == 0 && tokens[i].Kind == lexer.Word && tokens[i].Value == "union":
+			end := i + 1
+			if end < len(tokens) && (tokens[end].Value == "all" || tokens[end].Value == "distinct") {
+				end++
+			}
+			parts = append(parts, tokens[start:i])
+			unions = append(unions, tokens[i:end])
+			start = end
+			i = end - 1
+		}
+	}
+	if len(unions) == 0 {
+		return tokens
+	}
+	parts = append(parts, tokens[start:])
+
+	var res []token
+	for i := 0; i < len(parts); i++ {
+		if i > 0 {
+			res = append(res, unions[i-1]...)
+		}
+		res = append(res, parts[i]...)
+
+		// skip the identical queries that follow
+		var last []token
+		for i+1 < len(parts) && join(parts[i+1]) == join(parts[i]) {
+			last = unions[i]
+			i++
+		}
+		if last != nil {
+			repeat := "/*repeat"
+			for _, t := range last {
+				repeat += " " + t.Value
+			}
+			res = append(res, token{Token: lexer.Token{Kind: lexer.Comment, Value: repeat + "*/"}, space: true})
+		}
+	}
+	return res
+}
+
+// collapseLimits replaces the offset and the count of LIMIT with a single
+// placeholder, as in LIMIT 10, 20 or LIMIT 20 OFFSET 10
+func collapseLimits(tokens []token) []token {
+	var res []token
+	for i := 0; i < len(tokens); i++ {
+		res = append(res, tokens[i])
+		if tokens[i].Kind != lexer.Word || tokens[i].Value != "limit" {
+			continue
+		}
+		if i+3 < len(tokens) && tokens[i+1].Value == "?" && tokens[i+3].Value == "?" &&
+			(tokens[i+2].Value == "," || tokens[i+2].Kind == lexer.Word && tokens[i+2].Value == "offset") {
+			res = append(res, tokens[i+1])
+			i += 3
+		}
+	}
+	return res
+}
+
+// stripAsc drops the ASC keywords that follow ORDER BY
+func stripAsc(tokens []token) []token {
+	var res []token
+	ordered := false
+	for i, t := range tokens {
+		if t.Kind == lexer.Word && t.Value == "by" && i > 0 && tokens[i-1].Value == "order" {
+			ordered = true
+		}
+		if ordered && t.Kind == lexer.Word && t.Value == "asc" {
+			continue
+		}
+		res = append(res, t)
+	}
+	return res
+}
+
+// onlyLiterals tells if the tokens are literals, separated by commas and
+// parentheses for row constructors
+func onlyLiterals(tokens []token) bool {
+	if len(tokens) == 0 {
+		return false
+	}
+	for _, t := range tokens {
+		switch {
+		case t.Value == "?" || t.Value == "," || t.Value == "(" || t.Value == ")":
+		case t.Kind == lexer.Placeholder:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// closing returns the index of the parenthesis closing the one at open, or
+// the index of the last token if it is not closed
+func closing(tokens []token, open int) int {
+	depth := 0
+	for i := open; i < len(tokens); i++ {
+		switch tokens[i].Value {
+		case "(":
+			depth++
+		case ")":
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return len(tokens) - 1
+}
+
+// replaceDigits replaces each run of digits in an identifier by ?
+func replaceDigits(s string) string {
+	if !strings.ContainsAny(s, "0123456789") {
+		return s
+	}
+
+	var b strings.Builder
+	inDigits := false
+	for i := 0; i < len(s); i++ {
+		if s[i] >= '0' && s[i] <= '9' {
+			if !inDigits {
+				b.WriteByte('?')
+			}
+			inDigits = true
+			continue
+		}
+		inDigits = false
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+func isSign(t token) bool {
+	return t.Kind == lexer.Operator && (t.Value == "-" || t.Value == "+")
+}
+
+// isUnary tells if a sign following the tokens is unary
+func isUnary(previous []token) bool {
+	if len(previous) == 0 {
+		return true
+	}
+	last := previous[len(previous)-1]
+	switch last.Kind {
+	case lexer.Operator:
+		return last.Value != ")"
+	case lexer.Word:
+		return expressionStarts[last.Value]
+	}
+	return false
+}
+
+// join builds the fingerprint, with a single space where there were
+// whitespace or comments
+func join(tokens []token) string {
+	var b strings.Builder
+	for i, t := range tokens {
+		if t.space && i > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(t.Value)
+	}
+	return b.String()
+}
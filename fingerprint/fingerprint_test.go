@@ -0,0 +1,154 @@
+package fingerprint
+
+import (
+	"testing"
+
+	"github.com/devops-works/slowql/query/lexer"
+)
+
+func TestFingerprint(t *testing.T) {
+	tests := []struct {
+		name    string
+		q       string
+		want    string
+		wantErr bool
+	}{
+		{name: "simple", q: "SELECT * FROM users WHERE id = 42",
+			want: "select * from users where id = ?"},
+		{name: "no spaces around operator", q: "SELECT * FROM users WHERE id=42;",
+			want: "select * from users where id=?"},
+		{name: "escaped quotes", q: `SELECT * FROM t WHERE name = 'O\'Brien' AND city = 'it''s'`,
+			want: "select * from t where name = ? and city = ?"},
+		{name: "double-quoted strings", q: `SELECT * FROM t WHERE a = "foo" AND b = "say ""hi"""`,
+			want: "select * from t where a = ? and b = ?"},
+		{name: "comments", q: "SELECT /* hint */ a FROM t -- trailing",
+			want: "select a from t"},
+		{name: "several comments", q: "SELECT /* a */ b /* c */ FROM t # d",
+			want: "select b from t"},
+		{name: "nested comments", q: "SELECT a /* x /* y */ z */ FROM t",
+			want: "select a from t"},
+		{name: "in list", q: "SELECT * FROM t WHERE id IN (1, 2, 3)",
+			want: "select * from t where id in(?+)"},
+		{name: "in list of rows", q: "SELECT * FROM t WHERE (a, b) IN ((1, 2), (3, 4)) AND c = 1",
+			want: "select * from t where (a, b) in(?+) and c = ?"},
+		{name: "in list of strings with parenthesis", q: "SELECT * FROM t WHERE a IN ('(', ')')",
+			want: "select * from t where a in(?+)"},
+		{name: "in subquery", q: "SELECT * FROM t WHERE id IN (SELECT id FROM u WHERE a = 1)",
+			want: "select * from t where id in (select id from u where a = ?)"},
+		{name: "negative numbers", q: "SELECT * FROM t WHERE a = -1 AND b > -2.5 AND c IN (-1, +2)",
+			want: "select * from t where a = ? and b > ? and c in(?+)"},
+		{name: "binary minus", q: "SELECT a - 1, a-1 FROM t",
+			want: "select a - ?, a-? from t"},
+		{name: "hexadecimal numbers", q: "SELECT * FROM t WHERE h = 0xDEADBEEF OR h = X'ff' OR b = b'101'",
+			want: "select * from t where h = ? or h = ? or b = ?"},
+		{name: "null and booleans", q: "UPDATE t SET a = NULL, b = TRUE WHERE c IS NULL",
+			want: "update t set a = ?, b = ? where c is ?"},
+		{name: "numbers in identifiers", q: "SELECT * FROM users_2009 JOIN `logs_2021_03` ON t1.id = 1",
+			want: "select * from users_? join `logs_?_?` on t?.id = ?"},
+		{name: "whitespace", q: "SELECT\n\t a,\n  b  FROM   t  ",
+			want: "select a, b from t"},
+		{name: "mysqldump", q: "SELECT /*!40001 SQL_NO_CACHE */ * FROM `users`",
+			want: "mysqldump"},
+		{name: "pt-table-checksum", q: "SELECT /*shop.users:1/3*/ 'shop', 'users', '1', COUNT(*) FROM `shop`.`users`",
+			want: "percona-toolkit"},
+		{name: "use", q: "USE shop_production;",
+			want: "use ?"},
+		{name: "use quoted", q: "use `shop`",
+			want: "use ?"},
+		{name: "single-value insert", q: "INSERT INTO t (a, b) VALUES (1, 'x')",
+			want: "insert into t (a, b) values(?+)"},
+		{name: "multi-value insert", q: "INSERT INTO t (a, b) VALUES (1, 'x'), (2, 'y'), (3, 'z')",
+			want: "insert into t (a, b) values(?+)"},
+		{name: "multi-value insert with update", q: "INSERT INTO t (a) VALUES (1),(2) ON DUPLICATE KEY UPDATE a = a + 1",
+			want: "insert into t (a) values(?+) on duplicate key update a = a + ?"},
+		{name: "replace", q: "REPLACE INTO t VALUE (1, NULL)",
+			want: "replace into t value(?+)"},
+		{name: "insert with functions", q: "INSERT INTO t (a, b) VALUES (NOW(), 1)",
+			want: "insert into t (a, b) values (now(), ?)"},
+		{name: "union", q: "SELECT a FROM t WHERE id = 1 UNION SELECT a FROM t WHERE id = 2 UNION SELECT a FROM t WHERE id = 3",
+			want: "select a from t where id = ? /*repeat union*/"},
+		{name: "union all", q: "SELECT a FROM t WHERE id = 1 UNION ALL SELECT a FROM t WHERE id = 2",
+			want: "select a from t where id = ? /*repeat union all*/"},
+		{name: "different unions", q: "SELECT a FROM t UNION SELECT b FROM u UNION SELECT b FROM u",
+			want: "select a from t union select b from u /*repeat union*/"},
+		{name: "union in subquery", q: "SELECT * FROM (SELECT a FROM t UNION SELECT a FROM t) AS x",
+			want: "select * from (select a from t /*repeat union*/) as x"},
+		{name: "limit", q: "SELECT * FROM t LIMIT 10",
+			want: "select * from t limit ?"},
+		{name: "limit offset", q: "SELECT * FROM t LIMIT 10 OFFSET 20",
+			want: "select * from t limit ?"},
+		{name: "limit with comma", q: "SELECT * FROM t LIMIT 10, 20",
+			want: "select * from t limit ?"},
+		{name: "order by asc", q: "SELECT * FROM t ORDER BY a ASC, b DESC, c asc LIMIT 1",
+			want: "select * from t order by a, b desc, c limit ?"},
+		{name: "asc outside order by", q: "SELECT asc FROM t",
+			want: "select asc from t"},
+		{name: "placeholders", q: "SELECT * FROM t WHERE a = ? AND b = $1",
+			want: "select * from t where a = ? and b = ?"},
+		{name: "truncated", q: "SELECT * FROM t WHERE a = 'abc",
+			want: "select * from t where a = ?", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Fingerprint(tt.q)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Fingerprint() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Fingerprint() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFingerprintDialect(t *testing.T) {
+	tests := []struct {
+		name    string
+		q       string
+		dialect lexer.Dialect
+		want    string
+	}{
+		{name: "mysql double quotes are strings", q: `SELECT * FROM orders WHERE name = "x"`, dialect: lexer.MySQL,
+			want: "select * from orders where name = ?"},
+		{name: "ansi double quotes are identifiers", q: `SELECT * FROM "orders" WHERE name = 'x'`, dialect: lexer.ANSI,
+			want: `select * from "orders" where name = ?`},
+		{name: "ansi other table", q: `SELECT * FROM "users" WHERE name = 'x'`, dialect: lexer.ANSI,
+			want: `select * from "users" where name = ?`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := FingerprintDialect(tt.q, tt.dialect)
+			if err != nil {
+				t.Fatalf("FingerprintDialect() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("FingerprintDialect() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHash(t *testing.T) {
+	tests := []struct {
+		name string
+		q    string
+		want string
+	}{
+		{name: "foobar", q: "foobar", want: "3858f62230ac3c915f300c664312c63f"},
+		{name: "some long string", q: "some long string", want: "2fb66bbfb88cdf9e07a3f1d1dfad71ab"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Hash(tt.q); got != tt.want {
+				t.Errorf("Hash() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func BenchmarkFingerprint(b *testing.B) {
+	q := "SELECT col1, col2 FROM table1 AS t1 WHERE t1.id IN (1, 2, 3) AND name = 'foo' /* comment */ LIMIT 10"
+	for i := 0; i < b.N; i++ {
+		Fingerprint(q)
+	}
+}
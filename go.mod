@@ -6,7 +6,6 @@ require (
 	github.com/cheggaaa/pb/v3 v3.0.8
 	github.com/go-sql-driver/mysql v1.5.0
 	github.com/logrusorgru/aurora v2.0.3+incompatible
-	github.com/montanaflynn/stats v0.6.5
 	github.com/sirupsen/logrus v1.8.0
 	github.com/stretchr/testify v1.4.0 // indirect
 	golang.org/x/term v0.0.0-20201117132131-f5c789dd3221
@@ -0,0 +1,318 @@
+// Package lexer splits SQL queries into tokens. It understands the MySQL
+// dialect (and most of the PostgreSQL one) well enough to tell literals,
+// identifiers, comments and operators apart, which is what is needed to
+// fingerprint, analyse or anonymise queries. It does not validate the syntax.
+package lexer
+
+import (
+	"errors"
+	"strings"
+	"unicode/utf8"
+)
+
+// Kind is a token kind
+type Kind int
+
+const (
+	// Whitespace is a run of spaces, tabs and newlines
+	Whitespace Kind = iota
+	// Comment is a /* */, -- or # comment
+	Comment
+	// Word is a keyword or an unquoted identifier
+	Word
+	// QuotedIdentifier is an identifier between backticks, or between double
+	// quotes in the ANSI dialect
+	QuotedIdentifier
+	// String is a quoted string, including its quotes and prefix (N'', X'',
+	// B'', _charset'', E'') if any
+	String
+	// Number is a decimal, hexadecimal or binary number
+	Number
+	// Variable is a user (@var) or system (@@var) variable
+	Variable
+	// Placeholder is a prepared statement parameter, such as ? or $1
+	Placeholder
+	// Operator is an operator or a punctuation sign
+	Operator
+)
+
+// Dialect tells how a query quotes its strings and identifiers
+type Dialect int
+
+const (
+	// MySQL quotes strings between single or double quotes, and identifiers
+	// between backticks
+	MySQL Dialect = iota
+	// ANSI quotes identifiers between double quotes, as PostgreSQL and the
+	// ANSI_QUOTES mode of MySQL do
+	ANSI
+)
+
+// ErrUnterminated is returned when a string, quoted identifier or comment is
+// not terminated, which happens with truncated queries. The last token holds
+// the rest of the query
+var ErrUnterminated = errors.New("unterminated string, identifier or comment")
+
+// Token is a lexical token of a query
+type Token struct {
+	Kind  Kind
+	Value string
+	// Pos is the byte offset of the token in the query
+	Pos int
+}
+
+// operators lists the operators made of several characters, longest first
+var operators = []string{"<=>", "->>", "<=", ">=", "<>", "!=", ":=", "||", "&&", "<<", ">>", "->", "::"}
+
+// Tokenize splits a query of the MySQL dialect into tokens. Concatenating the
+// values of the tokens gives the query back
+func Tokenize(q string) ([]Token, error) {
+	return TokenizeDialect(q, MySQL)
+}
+
+// TokenizeDialect splits a query of the given dialect into tokens
+func TokenizeDialect(q string, d Dialect) ([]Token, error) {
+	var tokens []Token
+	var err error
+
+	for pos := 0; pos < len(q); {
+		kind, end, e := next(q, pos, d)
+		if e != nil {
+			err = e
+		}
+		tokens = append(tokens, Token{Kind: kind, Value: q[pos:end], Pos: pos})
+		pos = end
+	}
+
+	return tokens, err
+}
+
+// next returns the kind and the end of the token starting at pos
+func next(q string, pos int, d Dialect) (Kind, int, error) {
+	c := q[pos]
+
+	switch {
+	case isSpace(c):
+		end := pos + 1
+		for end < len(q) && isSpace(q[end]) {
+			end++
+		}
+		return Whitespace, end, nil
+
+	case c == '#':
+		return Comment, lineEnd(q, pos), nil
+
+	case c == '-' && strings.HasPrefix(q[pos:], "--") && (pos+2 == len(q) || isSpace(q[pos+2])):
+		return Comment, lineEnd(q, pos), nil
+
+	case c == '/' && strings.HasPrefix(q[pos:], "/*"):
+		return blockComment(q, pos)
+
+	case c == '"' && d == ANSI:
+		return quoted(q, pos, c, QuotedIdentifier)
+
+	case c == '\'' || c == '"':
+		return quoted(q, pos, c, String)
+
+	case c == '`':
+		return quoted(q, pos, c, QuotedIdentifier)
+
+	case c == '$' && pos+1 < len(q) && isDigit(q[pos+1]):
+		end := pos + 1
+		for end < len(q) && isDigit(q[end]) {
+			end++
+		}
+		return Placeholder, end, nil
+
+	case c == '$':
+		if end, ok := dollarQuoted(q, pos); ok {
+			return String, end, nil
+		} else if end == len(q) {
+			return String, end, ErrUnterminated
+		}
+
+	case c == '?':
+		return Placeholder, pos + 1, nil
+
+	case c == '@':
+		end := pos + 1
+		for end < len(q) && q[end] == '@' {
+			end++
+		}
+		if end < len(q) && (q[end] == '\'' || q[end] == '"' || q[end] == '`') {
+			_, end, err := quoted(q, end, q[end], Variable)
+			return Variable, end, err
+		}
+		for end < len(q) && isWordChar(q[end]) {
+			end++
+		}
+		return Variable, end, nil
+
+	case isDigit(c) || (c == '.' && pos+1 < len(q) && isDigit(q[pos+1])):
+		return number(q, pos)
+
+	case isWordStart(c):
+		// strings can have a prefix: N'', X'', B'', E'' and _charset''
+		end := pos + 1
+		for end < len(q) && isWordChar(q[end]) {
+			end++
+		}
+		if end < len(q) && q[end] == '\'' && isStringPrefix(q[pos:end]) {
+			_, end, err := quoted(q, end, '\'', String)
+			return String, end, err
+		}
+		return Word, end, nil
+	}
+
+	for _, op := range operators {
+		if strings.HasPrefix(q[pos:], op) {
+			return Operator, pos + len(op), nil
+		}
+	}
+	return Operator, pos + 1, nil
+}
+
+// quoted returns the end of a string or identifier quoted with quote. Quotes
+// are escaped by doubling them, or with a backslash inside strings
+func quoted(q string, pos int, quote byte, kind Kind) (Kind, int, error) {
+	for end := pos + 1; end < len(q); end++ {
+		switch q[end] {
+		case '\\':
+			if quote != '`' {
+				end++
+			}
+		case quote:
+			if end+1 < len(q) && q[end+1] == quote {
+				end++
+				continue
+			}
+			return kind, end + 1, nil
+		}
+	}
+	return kind, len(q), ErrUnterminated
+}
+
+// blockComment returns the end of a /* */ comment. Nested comments are
+// supported
+func blockComment(q string, pos int) (Kind, int, error) {
+	depth := 0
+	for end := pos; end+1 < len(q); end++ {
+		switch {
+		case q[end] == '/' && q[end+1] == '*':
+			depth++
+			end++
+		case q[end] == '*' && q[end+1] == '/':
+			depth--
+			end++
+			if depth == 0 {
+				return Comment, end + 1, nil
+			}
+		}
+	}
+	return Comment, len(q), ErrUnterminated
+}
+
+// dollarQuoted returns the end of a PostgreSQL $tag$...$tag$ string. It
+// returns false if there is no such string at pos
+func dollarQuoted(q string, pos int) (int, bool) {
+	end := pos + 1
+	for end < len(q) && q[end] != '$' && isWordChar(q[end]) {
+		end++
+	}
+	if end >= len(q) || q[end] != '$' {
+		return pos, false
+	}
+	tag := q[pos : end+1]
+
+	closing := strings.Index(q[end+1:], tag)
+	if closing < 0 {
+		return len(q), false
+	}
+	return end + 1 + closing + len(tag), true
+}
+
+// number returns the end of a number: 42, 4.2, .42, 4.2e-1, 0x2a or 0b101010
+func number(q string, pos int) (Kind, int, error) {
+	end := pos
+	if q[pos] == '0' && pos+2 < len(q) && (q[pos+1] == 'x' || q[pos+1] == 'X') && isHexDigit(q[pos+2]) {
+		end = pos + 2
+		for end < len(q) && isHexDigit(q[end]) {
+			end++
+		}
+		return Number, end, nil
+	}
+	if q[pos] == '0' && pos+2 < len(q) && (q[pos+1] == 'b' || q[pos+1] == 'B') && (q[pos+2] == '0' || q[pos+2] == '1') {
+		end = pos + 2
+		for end < len(q) && (q[end] == '0' || q[end] == '1') {
+			end++
+		}
+		return Number, end, nil
+	}
+
+	for end < len(q) && isDigit(q[end]) {
+		end++
+	}
+	if end < len(q) && q[end] == '.' {
+		end++
+		for end < len(q) && isDigit(q[end]) {
+			end++
+		}
+	}
+	if end+1 < len(q) && (q[end] == 'e' || q[end] == 'E') {
+		exp := end + 1
+		if q[exp] == '+' || q[exp] == '-' {
+			exp++
+		}
+		if exp < len(q) && isDigit(q[exp]) {
+			end = exp
+			for end < len(q) && isDigit(q[end]) {
+				end++
+			}
+		}
+	}
+
+	// identifiers can start with digits, such as 1table
+	if end < len(q) && isWordStart(q[end]) {
+		for end < len(q) && isWordChar(q[end]) {
+			end++
+		}
+		return Word, end, nil
+	}
+	return Number, end, nil
+}
+
+// lineEnd returns the end of the line starting at pos, without the newline
+func lineEnd(q string, pos int) int {
+	if idx := strings.IndexByte(q[pos:], '\n'); idx >= 0 {
+		return pos + idx
+	}
+	return len(q)
+}
+
+func isStringPrefix(p string) bool {
+	switch strings.ToLower(p) {
+	case "n", "x", "b", "e":
+		return true
+	}
+	return p[0] == '_'
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' || c == '\v'
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isHexDigit(c byte) bool {
+	return isDigit(c) || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+func isWordStart(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c == '_' || c >= utf8.RuneSelf
+}
+
+func isWordChar(c byte) bool {
+	return isWordStart(c) || isDigit(c) || c == '$'
+}
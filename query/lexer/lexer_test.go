@@ -0,0 +1,62 @@
+package lexer
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		name    string
+		q       string
+		dialect Dialect
+		want    []Kind
+		wantErr bool
+	}{
+		{name: "select", q: "SELECT a FROM t",
+			want: []Kind{Word, Whitespace, Word, Whitespace, Word, Whitespace, Word}},
+		{name: "escaped quotes", q: `'it\'s' 'it''s' "say ""hi"""`,
+			want: []Kind{String, Whitespace, String, Whitespace, String}},
+		{name: "prefixed strings", q: "N'foo' X'2a' _utf8mb4'bar'",
+			want: []Kind{String, Whitespace, String, Whitespace, String}},
+		{name: "numbers", q: "42 4.2 .42 4.2e-1 0x2A 0b101",
+			want: []Kind{Number, Whitespace, Number, Whitespace, Number, Whitespace, Number, Whitespace, Number, Whitespace, Number}},
+		{name: "negative number", q: "-1",
+			want: []Kind{Operator, Number}},
+		{name: "identifiers with digits", q: "users_2009 1table `my``table`",
+			want: []Kind{Word, Whitespace, Word, Whitespace, QuotedIdentifier}},
+		{name: "ansi quoted identifiers", q: `"my""table" 'a'`, dialect: ANSI,
+			want: []Kind{QuotedIdentifier, Whitespace, String}},
+		{name: "comments", q: "a /* b /* nested */ c */ d -- e\n# f\ng--1",
+			want: []Kind{Word, Whitespace, Comment, Whitespace, Word, Whitespace, Comment, Whitespace, Comment, Whitespace, Word, Operator, Operator, Number}},
+		{name: "operators", q: "a<=>b>=c!=d:=e",
+			want: []Kind{Word, Operator, Word, Operator, Word, Operator, Word, Operator, Word}},
+		{name: "variables and placeholders", q: "@a @@global.b ? $1",
+			want: []Kind{Variable, Whitespace, Variable, Operator, Word, Whitespace, Placeholder, Whitespace, Placeholder}},
+		{name: "dollar quoted", q: "$fn$ SELECT 'a' $fn$",
+			want: []Kind{String}},
+		{name: "unterminated", q: "SELECT 'abc",
+			want: []Kind{Word, Whitespace, String}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokens, err := TokenizeDialect(tt.q, tt.dialect)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("TokenizeDialect() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			var kinds []Kind
+			var q string
+			for _, tok := range tokens {
+				kinds = append(kinds, tok.Kind)
+				q += tok.Value
+			}
+			if !reflect.DeepEqual(kinds, tt.want) {
+				t.Errorf("Tokenize() = %v, want %v", kinds, tt.want)
+			}
+			if q != tt.q {
+				t.Errorf("tokens give %q, want %q", q, tt.q)
+			}
+		})
+	}
+}
@@ -1,6 +1,12 @@
 package query
 
-import "time"
+import (
+	"time"
+
+	"github.com/devops-works/slowql/fingerprint"
+	"github.com/devops-works/slowql/query/lexer"
+	"github.com/devops-works/slowql/query/structure"
+)
 
 // Query is a single SQL query and the data associated
 type Query struct {
@@ -19,4 +25,64 @@ type Query struct {
 	Schema       string
 	Query        string
 	QCHit        bool
+	// Line is the line of the log the query starts at, counting from 1. It is
+	// 0 when unknown
+	Line int
+	// Extra holds the attributes that are specific to a database kind and do
+	// not map onto one of the fields above
+	Extra map[string]string
+	// Dialect tells how the query quotes its identifiers: between double
+	// quotes for PostgreSQL
+	Dialect lexer.Dialect
+}
+
+// Block is the lines of a log holding a query, as split by the parser before
+// they are parsed by the database kind
+type Block struct {
+	// Line is the line of the log the block starts at, counting from 1
+	Line  int
+	Lines []string
+}
+
+// IsZero reports whether q is the zero Query, which is what the parser
+// returns when there is nothing left to read
+func (q Query) IsZero() bool {
+	return q.Time.IsZero() &&
+		q.QueryTime == 0 &&
+		q.LockTime == 0 &&
+		q.ID == 0 &&
+		q.RowsSent == 0 &&
+		q.RowsExamined == 0 &&
+		q.RowsAffected == 0 &&
+		q.LastErrNo == 0 &&
+		q.Killed == 0 &&
+		q.BytesSent == 0 &&
+		q.User == "" &&
+		q.Host == "" &&
+		q.Schema == "" &&
+		q.Query == "" &&
+		!q.QCHit &&
+		q.Line == 0 &&
+		len(q.Extra) == 0 &&
+		q.Dialect == lexer.MySQL
+}
+
+// Fingerprint returns the fingerprint of the query, which is a normalised
+// version of it where literals are replaced by placeholders. If the query is
+// malformed, such as a truncated one, the fingerprint is still usable and the
+// error tells what is wrong
+func (q Query) Fingerprint() (string, error) {
+	return fingerprint.FingerprintDialect(q.Query, q.Dialect)
+}
+
+// Hash returns the MD5 hash of the query's fingerprint
+func (q Query) Hash() (string, error) {
+	fp, err := q.Fingerprint()
+	return fingerprint.Hash(fp), err
+}
+
+// Class returns the class of the query: read-only, write, DDL, transaction
+// control or session state
+func (q Query) Class() structure.Class {
+	return structure.Classify(q.Query)
 }
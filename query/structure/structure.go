@@ -0,0 +1,617 @@
+// Package structure extracts the structure of SQL queries: their statement
+// type, the tables they reference and the columns they use to filter, sort
+// and group rows. It also classifies queries by what they can modify, which
+// tells whether they are safe to run on a replica. It relies on the lexer and
+// a handful of heuristics rather than on a full SQL grammar, so it is fast and
+// tolerant to dialects and truncated queries, at the cost of missing some
+// exotic constructs.
+package structure
+
+import (
+	"strings"
+
+	"github.com/devops-works/slowql/query/lexer"
+)
+
+// Type is a statement type
+type Type int
+
+const (
+	// Unknown type
+	Unknown Type = iota
+	// Select statement
+	Select
+	// Insert statement
+	Insert
+	// Update statement
+	Update
+	// Delete statement
+	Delete
+	// Replace statement
+	Replace
+	// DDL is a CREATE, ALTER, DROP, TRUNCATE or RENAME statement
+	DDL
+	// Transaction is a BEGIN, START TRANSACTION, COMMIT, ROLLBACK, SAVEPOINT
+	// or RELEASE statement
+	Transaction
+	// Set statement
+	Set
+	// Use statement
+	Use
+	// Show statement, including DESCRIBE and EXPLAIN
+	Show
+	// Call statement
+	Call
+	// Other statements
+	Other
+)
+
+var typeNames = map[Type]string{
+	Unknown:     "unknown",
+	Select:      "select",
+	Insert:      "insert",
+	Update:      "update",
+	Delete:      "delete",
+	Replace:     "replace",
+	DDL:         "ddl",
+	Transaction: "transaction",
+	Set:         "set",
+	Use:         "use",
+	Show:        "show",
+	Call:        "call",
+	Other:       "other",
+}
+
+// String returns the name of the type
+func (t Type) String() string {
+	return typeNames[t]
+}
+
+// Class is the class of a statement, which tells what it can modify
+type Class int
+
+const (
+	// ReadOnly statements only read data
+	ReadOnly Class = iota + 1
+	// Write statements modify data, or may do so. Statements that cannot be
+	// classified are considered as writes
+	Write
+	// DataDefinition statements modify the schema
+	DataDefinition
+	// TransactionControl statements start or end transactions
+	TransactionControl
+	// SessionState statements modify the state of the session, such as SET
+	// and USE
+	SessionState
+)
+
+var classNames = map[Class]string{
+	ReadOnly:           "read",
+	Write:              "write",
+	DataDefinition:     "ddl",
+	TransactionControl: "transaction",
+	SessionState:       "session",
+}
+
+// String returns the name of the class
+func (c Class) String() string {
+	if n, ok := classNames[c]; ok {
+		return n
+	}
+	return "unknown"
+}
+
+// Classes lists all the classes
+var Classes = []Class{ReadOnly, Write, DataDefinition, TransactionControl, SessionState}
+
+// Table is a table referenced by a query
+type Table struct {
+	Schema string
+	Name   string
+}
+
+// String returns the table name, prefixed with its schema if any
+func (t Table) String() string {
+	if t.Schema == "" {
+		return t.Name
+	}
+	return t.Schema + "." + t.Name
+}
+
+// Structure is the structure of a query
+type Structure struct {
+	Type Type
+	// Tables lists all the tables referenced by the query, including the
+	// joined ones and the ones from subqueries
+	Tables []Table
+	// Joins lists the tables that are joined
+	Joins []Table
+	// Where, OrderBy and GroupBy list the columns used in these clauses, as
+	// written in the query (possibly qualified by a table name or alias)
+	Where   []string
+	OrderBy []string
+	GroupBy []string
+}
+
+// firstWords maps the first word of a statement to its type
+var firstWords = map[string]Type{
+	"select":    Select,
+	"with":      Select,
+	"insert":    Insert,
+	"update":    Update,
+	"delete":    Delete,
+	"replace":   Replace,
+	"create":    DDL,
+	"alter":     DDL,
+	"drop":      DDL,
+	"truncate":  DDL,
+	"rename":    DDL,
+	"begin":     Transaction,
+	"start":     Transaction,
+	"commit":    Transaction,
+	"rollback":  Transaction,
+	"savepoint": Transaction,
+	"release":   Transaction,
+	"set":       Set,
+	"use":       Use,
+	"show":      Show,
+	"describe":  Show,
+	"desc":      Show,
+	"explain":   Show,
+	"call":      Call,
+}
+
+// keywords lists the reserved words that cannot be table aliases or columns
+var keywords = map[string]bool{}
+
+func init() {
+	for _, k := range strings.Fields(`
+		all and any as asc between by case cross current_date current_time
+		current_timestamp delete desc distinct div else end escape except exists
+		false for force from full group having if ignore in index inner insert
+		intersect interval into is join key left like limit lock mod natural not
+		null offset on or order outer partition regexp replace right rlike rollup
+		select set share straight_join table then to true union update use using
+		values when where window with xor binary collate outfile dumpfile`) {
+		keywords[k] = true
+	}
+}
+
+// clauseEnds lists the words that end a FROM, WHERE, GROUP BY or ORDER BY
+// clause
+var clauseEnds = map[string]bool{
+	"where": true, "group": true, "order": true, "having": true, "limit": true,
+	"union": true, "except": true, "intersect": true, "on": true, "using": true,
+	"join": true, "inner": true, "left": true, "right": true, "cross": true,
+	"natural": true, "straight_join": true, "full": true, "set": true,
+	"values": true, "value": true, "for": true, "lock": true, "window": true,
+	"into": true, "offset": true, "returning": true, "procedure": true,
+}
+
+// parser walks the significant tokens of a query
+type parser struct {
+	tokens []lexer.Token
+	pos    int
+	s      Structure
+	seen   map[Table]bool
+}
+
+// Extract returns the structure of a query
+func Extract(q string) Structure {
+	raw, _ := lexer.Tokenize(q)
+
+	p := parser{seen: make(map[Table]bool)}
+	for _, t := range raw {
+		if t.Kind != lexer.Whitespace && t.Kind != lexer.Comment {
+			p.tokens = append(p.tokens, t)
+		}
+	}
+
+	// slow query logs can prefix the statement with USE
+	p.s.Type = Unknown
+	for _, stmt := range statements(p.tokens) {
+		p.s.Type = statementType(stmt)
+		if p.s.Type != Use {
+			break
+		}
+	}
+	p.walk()
+	return p.s
+}
+
+// statements splits tokens into statements
+func statements(tokens []lexer.Token) [][]lexer.Token {
+	var stmts [][]lexer.Token
+	start := 0
+	for i, t := range tokens {
+		if t.Value == ";" {
+			if i > start {
+				stmts = append(stmts, tokens[start:i])
+			}
+			start = i + 1
+		}
+	}
+	if start < len(tokens) {
+		stmts = append(stmts, tokens[start:])
+	}
+	return stmts
+}
+
+// statementType returns the type of the statement from its first words
+func statementType(tokens []lexer.Token) Type {
+	// skip the parenthesis of queries such as (SELECT ...) UNION (SELECT ...)
+	i := 0
+	for i < len(tokens) && tokens[i].Value == "(" {
+		i++
+	}
+	if i >= len(tokens) {
+		return Unknown
+	}
+
+	first := strings.ToLower(tokens[i].Value)
+	t, ok := firstWords[first]
+	if !ok {
+		return Other
+	}
+
+	// the statement follows the common table expressions, as in WITH x AS
+	// (...) DELETE ...
+	if first == "with" {
+		depth := 0
+		for _, tok := range tokens[i+1:] {
+			switch w := strings.ToLower(tok.Value); {
+			case w == "(":
+				depth++
+			case w == ")":
+				depth--
+			case depth == 0 && tok.Kind == lexer.Word && firstWords[w] != Unknown && w != "with":
+				return firstWords[w]
+			}
+		}
+	}
+
+	// START is a transaction only when followed by TRANSACTION, and BEGIN
+	// can also start a compound statement
+	if first == "start" && (i+1 >= len(tokens) || !strings.EqualFold(tokens[i+1].Value, "transaction")) {
+		return Other
+	}
+	return t
+}
+
+// Classify returns the class of a query
+func Classify(q string) Class {
+	raw, _ := lexer.Tokenize(q)
+
+	var tokens []lexer.Token
+	for _, t := range raw {
+		if t.Kind != lexer.Whitespace && t.Kind != lexer.Comment {
+			tokens = append(tokens, t)
+		}
+	}
+
+	// a query made of several statements, such as the ones prefixed with USE
+	// in slow query logs, gets the class of its most significant statement
+	class := Class(0)
+	for _, stmt := range statements(tokens) {
+		if c := classify(stmt); class == 0 || significance[c] > significance[class] {
+			class = c
+		}
+	}
+	if class == 0 {
+		return Write
+	}
+	return class
+}
+
+// significance orders the classes, from the safest to the most dangerous
+var significance = map[Class]int{
+	SessionState:       1,
+	ReadOnly:           2,
+	TransactionControl: 3,
+	DataDefinition:     4,
+	Write:              5,
+}
+
+// classify returns the class of a statement
+func classify(tokens []lexer.Token) Class {
+	word := func(i int) string {
+		if i < 0 || i >= len(tokens) {
+			return ""
+		}
+		return strings.ToLower(tokens[i].Value)
+	}
+
+	switch statementType(tokens) {
+	case Select:
+		for i := range tokens {
+			switch word(i) {
+			case "for":
+				// locking reads take the same locks as writes, and would
+				// block the replication on a replica
+				switch word(i + 1) {
+				case "update", "share", "no", "key":
+					return Write
+				}
+			case "lock":
+				if word(i+1) == "in" {
+					return Write
+				}
+			case "into":
+				if w := word(i + 1); w == "outfile" || w == "dumpfile" {
+					return Write
+				}
+			}
+		}
+		return ReadOnly
+
+	case Show:
+		// EXPLAIN ANALYZE runs the statement
+		if word(0) == "explain" && word(1) == "analyze" {
+			return classify(tokens[2:])
+		}
+		return ReadOnly
+
+	case DDL:
+		return DataDefinition
+
+	case Transaction:
+		return TransactionControl
+
+	case Set:
+		for i, t := range tokens {
+			w := word(i)
+			switch {
+			case i == 1 && w == "transaction":
+				return TransactionControl
+			case i == 1 && (w == "password" || w == "default" && word(2) == "role"):
+				// SET PASSWORD and SET DEFAULT ROLE change accounts, which
+				// outlive the session
+				return Write
+			case t.Kind == lexer.Word && (w == "global" || w == "persist" || w == "persist_only"):
+				// the variables of the server are not part of the session
+				return Write
+			case t.Kind == lexer.Variable && (w == "@@global" || w == "@@persist" || w == "@@persist_only"):
+				return Write
+			}
+		}
+		return SessionState
+
+	case Use:
+		return SessionState
+	}
+
+	// INSERT, UPDATE, DELETE, REPLACE, CALL and the statements that cannot be
+	// classified, such as LOAD DATA, GRANT or FLUSH
+	return Write
+}
+
+// walk goes through the tokens and extracts tables and columns
+func (p *parser) walk() {
+	for p.pos < len(p.tokens) {
+		switch p.word(p.pos) {
+		case "from":
+			p.pos++
+			p.tableList(fromClause)
+		case "join", "straight_join":
+			p.pos++
+			p.tableList(joinClause)
+		case "into":
+			p.pos++
+			p.table(definition)
+		case "update":
+			// UPDATE t SET ..., but not ON DUPLICATE KEY UPDATE or FOR UPDATE
+			if prev := p.word(p.pos - 1); p.pos == 0 || prev == "(" || prev == ")" {
+				p.pos++
+				p.skip("low_priority", "ignore")
+				p.tableList(definition)
+			} else {
+				p.pos++
+			}
+		case "table":
+			// CREATE TABLE, ALTER TABLE, DROP TABLE, TRUNCATE TABLE, RENAME
+			// TABLE
+			p.pos++
+			p.skip("if", "not", "exists")
+			p.tableList(definition)
+		case "truncate":
+			p.pos++
+			if p.word(p.pos) != "table" {
+				p.tableList(definition)
+			}
+		case "on", "to":
+			// CREATE INDEX i ON t, RENAME TABLE a TO b
+			p.pos++
+			if p.s.Type == DDL {
+				p.tableList(definition)
+			}
+		case "where":
+			p.pos++
+			p.s.Where = append(p.s.Where, p.columns()...)
+		case "group", "order":
+			clause := p.word(p.pos)
+			if p.word(p.pos+1) != "by" {
+				p.pos++
+				continue
+			}
+			p.pos += 2
+			if clause == "group" {
+				p.s.GroupBy = append(p.s.GroupBy, p.columns()...)
+			} else {
+				p.s.OrderBy = append(p.s.OrderBy, p.columns()...)
+			}
+		default:
+			p.pos++
+		}
+	}
+}
+
+// context is where a table is referenced
+type context int
+
+const (
+	// fromClause is a FROM clause, where functions can be used as tables
+	fromClause context = iota
+	// joinClause is a JOIN clause
+	joinClause
+	// definition is any other place, such as INSERT INTO t (a, b) or CREATE
+	// TABLE t (a INT), where the table can be followed by a parenthesis
+	definition
+)
+
+// tableList reads a comma separated list of tables with their aliases
+func (p *parser) tableList(ctx context) {
+	for p.pos < len(p.tokens) {
+		p.table(ctx)
+
+		// alias
+		if p.word(p.pos) == "as" {
+			p.pos++
+		}
+		if p.pos < len(p.tokens) && p.isIdentifier(p.pos) {
+			p.pos++
+		}
+
+		if p.word(p.pos) != "," {
+			return
+		}
+		p.pos++
+	}
+}
+
+// table reads a table name, possibly qualified by its schema. Subqueries are
+// left to the walk
+func (p *parser) table(ctx context) {
+	p.skip("only", "lateral")
+	if p.pos >= len(p.tokens) || !p.isIdentifier(p.pos) {
+		return
+	}
+
+	t := Table{Name: unquote(p.tokens[p.pos].Value)}
+	p.pos++
+	if p.word(p.pos) == "." && p.pos+1 < len(p.tokens) && p.isName(p.pos+1) {
+		t.Schema = t.Name
+		t.Name = unquote(p.tokens[p.pos+1].Value)
+		p.pos += 2
+	}
+
+	// a function call, such as FROM generate_series(1, 10)
+	if ctx != definition && p.word(p.pos) == "(" {
+		return
+	}
+
+	if !p.seen[t] {
+		p.seen[t] = true
+		p.s.Tables = append(p.s.Tables, t)
+	}
+	if ctx == joinClause {
+		p.s.Joins = append(p.s.Joins, t)
+	}
+}
+
+// columns reads the columns used in a clause, until the end of the clause.
+// Function names, keywords and subqueries are not columns, and neither are
+// double quoted names since they are strings in MySQL
+func (p *parser) columns() []string {
+	var cols []string
+	depth := 0
+	for p.pos < len(p.tokens) {
+		w := p.word(p.pos)
+		switch {
+		case w == "(":
+			if p.word(p.pos+1) == "select" {
+				// the subquery is left to the walk
+				return cols
+			}
+			depth++
+		case w == ")":
+			if depth == 0 {
+				return cols
+			}
+			depth--
+		case w == ";":
+			return cols
+		case depth == 0 && clauseEnds[w]:
+			return cols
+		case w == "interval":
+			// INTERVAL 1 DAY
+			p.pos += 2
+		case w == "::" || w == "collate":
+			// PostgreSQL casts such as a::int, and collations
+			p.pos++
+		case p.isColumn(p.pos) && !keywords[w] && p.word(p.pos+1) != "(":
+			col := unquote(p.tokens[p.pos].Value)
+			for p.word(p.pos+1) == "." && p.pos+2 < len(p.tokens) && p.isColumn(p.pos+2) {
+				col += "." + unquote(p.tokens[p.pos+2].Value)
+				p.pos += 2
+			}
+			cols = appendUnique(cols, col)
+		}
+		p.pos++
+	}
+	return cols
+}
+
+// skip skips the given optional words
+func (p *parser) skip(words ...string) {
+	for p.pos < len(p.tokens) {
+		found := false
+		for _, w := range words {
+			if p.word(p.pos) == w {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return
+		}
+		p.pos++
+	}
+}
+
+// word returns the lowercased value of the token at i
+func (p *parser) word(i int) string {
+	if i < 0 || i >= len(p.tokens) {
+		return ""
+	}
+	return strings.ToLower(p.tokens[i].Value)
+}
+
+// isName tells if the token at i can be a table or column name
+func (p *parser) isName(i int) bool {
+	switch p.tokens[i].Kind {
+	case lexer.Word, lexer.QuotedIdentifier:
+		return true
+	case lexer.String:
+		// identifiers are double quoted in PostgreSQL
+		return strings.HasPrefix(p.tokens[i].Value, `"`)
+	}
+	return false
+}
+
+// isColumn tells if the token at i can be a column name
+func (p *parser) isColumn(i int) bool {
+	return p.tokens[i].Kind == lexer.Word || p.tokens[i].Kind == lexer.QuotedIdentifier
+}
+
+// isIdentifier tells if the token at i is a name that is not a keyword
+func (p *parser) isIdentifier(i int) bool {
+	return p.isName(i) && !keywords[p.word(i)] && !clauseEnds[p.word(i)]
+}
+
+// unquote removes the backticks or double quotes around an identifier
+func unquote(s string) string {
+	if len(s) >= 2 && (s[0] == '`' || s[0] == '"') && s[len(s)-1] == s[0] {
+		q := string(s[0])
+		return strings.ReplaceAll(s[1:len(s)-1], q+q, q)
+	}
+	return s
+}
+
+func appendUnique(list []string, s string) []string {
+	for _, v := range list {
+		if v == s {
+			return list
+		}
+	}
+	return append(list, s)
+}
@@ -0,0 +1,168 @@
+package structure
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExtract(t *testing.T) {
+	tests := []struct {
+		name string
+		q    string
+		want Structure
+	}{
+		{name: "simple select", q: "SELECT * FROM users WHERE id = 42",
+			want: Structure{Type: Select, Tables: []Table{{Name: "users"}}, Where: []string{"id"}}},
+		{name: "schema and quotes", q: "SELECT a FROM `shop`.`users` u WHERE u.`id` = 1 AND u.name LIKE 'a%'",
+			want: Structure{Type: Select, Tables: []Table{{Schema: "shop", Name: "users"}}, Where: []string{"u.id", "u.name"}}},
+		{name: "postgresql quotes", q: `SELECT a FROM public."Users" WHERE a::int = 1`,
+			want: Structure{Type: Select, Tables: []Table{{Schema: "public", Name: "Users"}}, Where: []string{"a"}}},
+		{name: "comma join", q: "SELECT * FROM a, b AS bb, c WHERE a.id = bb.id",
+			want: Structure{Type: Select, Tables: []Table{{Name: "a"}, {Name: "b"}, {Name: "c"}}, Where: []string{"a.id", "bb.id"}}},
+		{name: "joins", q: "SELECT * FROM orders o INNER JOIN users u ON u.id = o.user_id LEFT JOIN shop.items AS i USING (item_id) WHERE o.total > 10 ORDER BY o.created_at DESC, u.name",
+			want: Structure{Type: Select,
+				Tables:  []Table{{Name: "orders"}, {Name: "users"}, {Schema: "shop", Name: "items"}},
+				Joins:   []Table{{Name: "users"}, {Schema: "shop", Name: "items"}},
+				Where:   []string{"o.total"},
+				OrderBy: []string{"o.created_at", "u.name"}}},
+		{name: "group by", q: "SELECT country, COUNT(*) FROM users WHERE DATE(created_at) > NOW() - INTERVAL 1 DAY GROUP BY country WITH ROLLUP HAVING COUNT(*) > 1 ORDER BY 2",
+			want: Structure{Type: Select, Tables: []Table{{Name: "users"}}, Where: []string{"created_at"}, GroupBy: []string{"country"}}},
+		{name: "subquery", q: "SELECT * FROM t WHERE a = 1 AND id IN (SELECT t_id FROM u WHERE b = 2) ORDER BY c",
+			want: Structure{Type: Select, Tables: []Table{{Name: "t"}, {Name: "u"}}, Where: []string{"a", "id", "b"}, OrderBy: []string{"c"}}},
+		{name: "derived table", q: "SELECT x.a FROM (SELECT a FROM t) AS x",
+			want: Structure{Type: Select, Tables: []Table{{Name: "t"}}}},
+		{name: "mysql strings", q: `SELECT * FROM t WHERE a = "foo" OR b IS NOT NULL`,
+			want: Structure{Type: Select, Tables: []Table{{Name: "t"}}, Where: []string{"a", "b"}}},
+		{name: "insert", q: "INSERT INTO shop.users (a, b) VALUES (1, 2) ON DUPLICATE KEY UPDATE a = 3",
+			want: Structure{Type: Insert, Tables: []Table{{Schema: "shop", Name: "users"}}}},
+		{name: "insert select", q: "INSERT INTO a SELECT * FROM b",
+			want: Structure{Type: Insert, Tables: []Table{{Name: "a"}, {Name: "b"}}}},
+		{name: "replace", q: "REPLACE INTO t VALUES (1)",
+			want: Structure{Type: Replace, Tables: []Table{{Name: "t"}}}},
+		{name: "update", q: "UPDATE LOW_PRIORITY users SET name = 'x' WHERE id = 1",
+			want: Structure{Type: Update, Tables: []Table{{Name: "users"}}, Where: []string{"id"}}},
+		{name: "multi-table update", q: "UPDATE a JOIN b ON a.id = b.id SET a.x = b.y WHERE b.z = 1",
+			want: Structure{Type: Update, Tables: []Table{{Name: "a"}, {Name: "b"}}, Joins: []Table{{Name: "b"}}, Where: []string{"b.z"}}},
+		{name: "delete", q: "DELETE FROM sessions WHERE expires_at < NOW() LIMIT 1000",
+			want: Structure{Type: Delete, Tables: []Table{{Name: "sessions"}}, Where: []string{"expires_at"}}},
+		{name: "select for update", q: "SELECT * FROM t WHERE id = 1 FOR UPDATE",
+			want: Structure{Type: Select, Tables: []Table{{Name: "t"}}, Where: []string{"id"}}},
+		{name: "create table", q: "CREATE TABLE IF NOT EXISTS t (id INT PRIMARY KEY)",
+			want: Structure{Type: DDL, Tables: []Table{{Name: "t"}}}},
+		{name: "drop tables", q: "DROP TABLE IF EXISTS a, b",
+			want: Structure{Type: DDL, Tables: []Table{{Name: "a"}, {Name: "b"}}}},
+		{name: "create index", q: "CREATE INDEX idx ON shop.users (email)",
+			want: Structure{Type: DDL, Tables: []Table{{Schema: "shop", Name: "users"}}}},
+		{name: "rename", q: "RENAME TABLE a TO b",
+			want: Structure{Type: DDL, Tables: []Table{{Name: "a"}, {Name: "b"}}}},
+		{name: "truncate", q: "TRUNCATE logs",
+			want: Structure{Type: DDL, Tables: []Table{{Name: "logs"}}}},
+		{name: "common table expression", q: "WITH old AS (SELECT id FROM t WHERE a < 1) DELETE FROM t WHERE id IN (SELECT id FROM old)",
+			want: Structure{Type: Delete, Tables: []Table{{Name: "t"}, {Name: "old"}}, Where: []string{"a", "id"}}},
+		{name: "common table expression update", q: "WITH x AS (SELECT 1) UPDATE t SET a = 1",
+			want: Structure{Type: Update, Tables: []Table{{Name: "t"}}}},
+		{name: "union", q: "(SELECT a FROM t) UNION (SELECT a FROM u)",
+			want: Structure{Type: Select, Tables: []Table{{Name: "t"}, {Name: "u"}}}},
+		{name: "transaction", q: "START TRANSACTION READ ONLY",
+			want: Structure{Type: Transaction}},
+		{name: "commit", q: "COMMIT",
+			want: Structure{Type: Transaction}},
+		{name: "set", q: "SET NAMES utf8mb4",
+			want: Structure{Type: Set}},
+		{name: "use", q: "use shop",
+			want: Structure{Type: Use}},
+		{name: "show", q: "SHOW FULL PROCESSLIST",
+			want: Structure{Type: Show}},
+		{name: "prefixed with use", q: "use shop;SELECT a FROM t;",
+			want: Structure{Type: Select, Tables: []Table{{Name: "t"}}}},
+		{name: "comments", q: "/* app:42 */ SELECT a FROM t -- end",
+			want: Structure{Type: Select, Tables: []Table{{Name: "t"}}}},
+		{name: "other", q: "FLUSH TABLES",
+			want: Structure{Type: Other}},
+		{name: "empty", q: "",
+			want: Structure{Type: Unknown}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Extract(tt.q); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Extract() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		name string
+		q    string
+		want Class
+	}{
+		{name: "select", q: "SELECT * FROM t WHERE a = 1", want: ReadOnly},
+		{name: "select into variable", q: "SELECT a INTO @a FROM t", want: ReadOnly},
+		{name: "show", q: "SHOW TABLES", want: ReadOnly},
+		{name: "explain", q: "EXPLAIN DELETE FROM t", want: ReadOnly},
+		{name: "explain analyze", q: "EXPLAIN ANALYZE DELETE FROM t", want: Write},
+		{name: "select for update", q: "SELECT * FROM t WHERE a = 1 FOR UPDATE", want: Write},
+		{name: "select for share", q: "SELECT * FROM t FOR SHARE", want: Write},
+		{name: "lock in share mode", q: "SELECT * FROM t LOCK IN SHARE MODE", want: Write},
+		{name: "select into outfile", q: "SELECT * FROM t INTO OUTFILE '/tmp/t.csv'", want: Write},
+		{name: "insert", q: "INSERT INTO t VALUES (1)", want: Write},
+		{name: "update", q: "UPDATE t SET a = 1", want: Write},
+		{name: "delete", q: "DELETE FROM t", want: Write},
+		{name: "replace", q: "REPLACE INTO t VALUES (1)", want: Write},
+		{name: "common table expression delete", q: "WITH x AS (SELECT 1) DELETE FROM t", want: Write},
+		{name: "common table expression select", q: "WITH x AS (SELECT 1) SELECT * FROM x", want: ReadOnly},
+		{name: "call", q: "CALL purge()", want: Write},
+		{name: "load data", q: "LOAD DATA INFILE 'x' INTO TABLE t", want: Write},
+		{name: "create", q: "CREATE TABLE t (a INT)", want: DataDefinition},
+		{name: "alter", q: "ALTER TABLE t ADD COLUMN b INT", want: DataDefinition},
+		{name: "begin", q: "BEGIN", want: TransactionControl},
+		{name: "start transaction", q: "START TRANSACTION", want: TransactionControl},
+		{name: "commit", q: "COMMIT", want: TransactionControl},
+		{name: "set transaction", q: "SET TRANSACTION ISOLATION LEVEL READ COMMITTED", want: TransactionControl},
+		{name: "set names", q: "SET NAMES utf8mb4", want: SessionState},
+		{name: "set session variable", q: "SET @@session.sql_mode = ''", want: SessionState},
+		{name: "set global", q: "SET GLOBAL max_connections = 1000", want: Write},
+		{name: "set global variable", q: "SET @@global.max_connections = 1000", want: Write},
+		{name: "set password", q: "SET PASSWORD FOR 'app'@'%' = 'secret'", want: Write},
+		{name: "set own password", q: "SET PASSWORD = 'secret'", want: Write},
+		{name: "set default role", q: "SET DEFAULT ROLE admin TO 'app'@'%'", want: Write},
+		{name: "set role", q: "SET ROLE DEFAULT", want: SessionState},
+		{name: "use", q: "USE shop", want: SessionState},
+		{name: "prefixed with use", q: "use shop;SELECT * FROM t;", want: ReadOnly},
+		{name: "several statements", q: "SELECT 1; DELETE FROM t", want: Write},
+		{name: "empty", q: "", want: Write},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Classify(tt.q); got != tt.want {
+				t.Errorf("Classify() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTable_String(t *testing.T) {
+	tests := []struct {
+		name  string
+		table Table
+		want  string
+	}{
+		{name: "without schema", table: Table{Name: "users"}, want: "users"},
+		{name: "with schema", table: Table{Schema: "shop", Name: "users"}, want: "shop.users"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.table.String(); got != tt.want {
+				t.Errorf("Table.String() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func BenchmarkExtract(b *testing.B) {
+	q := "SELECT o.id, u.name FROM orders o JOIN users u ON u.id = o.user_id WHERE o.total > 10 AND u.country IN ('FR', 'DE') ORDER BY o.created_at DESC LIMIT 10"
+	for i := 0; i < b.N; i++ {
+		Extract(q)
+	}
+}
@@ -0,0 +1,187 @@
+package slowql
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Seek returns a reader of the slow query log r starting with the first query
+// logged at or after since, preceded by the server banner. Since slow query
+// logs are ordered by time, the query is found with a binary search on the
+// "# Time:" lines, without reading the whole log.
+//
+// Only the MySQL, PXC, MariaDB and TiDB slow query logs can be searched: for
+// the other kinds, and for logs that are not in the slow query log format
+// (such as logs exported from AWS CloudWatch), the whole log is returned. The
+// queries that are logged before since are not always skipped, so the caller
+// still has to check the time of the queries.
+func Seek(k Kind, r io.ReadSeeker, since time.Time) (io.Reader, error) {
+	var headerLines int
+	switch k {
+	case MySQL, PXC, MariaDB:
+		headerLines = 3
+	case TiDB:
+		headerLines = 0
+	default:
+		_, err := r.Seek(0, io.SeekStart)
+		return r, err
+	}
+
+	size, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+
+	// keep the banner
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	br := bufio.NewReader(r)
+	var header []byte
+	for i := 0; i < headerLines; i++ {
+		line, err := br.ReadBytes('\n')
+		header = append(header, line...)
+		if err != nil {
+			break
+		}
+	}
+	headerEnd := int64(len(header))
+
+	// the log has to start with a query header for the search to make sense
+	if first, err := br.Peek(1); err != nil || first[0] != '#' {
+		_, err := r.Seek(0, io.SeekStart)
+		return r, err
+	}
+
+	// find the lowest offset from which the next "# Time:" line is at or
+	// after since
+	lo, hi := headerEnd, size
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		_, t, found, err := nextTimeLine(r, mid, hi)
+		if err != nil {
+			return nil, err
+		}
+		if found && t.Before(since) {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+
+	start, _, found, err := nextTimeLine(r, lo, size)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		// every query is logged before since
+		start = size
+	}
+
+	if _, err := r.Seek(start, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return io.MultiReader(bytes.NewReader(header), r), nil
+}
+
+// nextTimeLine returns the offset and the time of the first "# Time:" line
+// starting at or after from, and before limit
+func nextTimeLine(r io.ReadSeeker, from, limit int64) (int64, time.Time, bool, error) {
+	offset := from
+	if from > 0 {
+		// start from the previous byte, to know if a line starts at from
+		offset = from - 1
+	}
+	if _, err := r.Seek(offset, io.SeekStart); err != nil {
+		return 0, time.Time{}, false, err
+	}
+	br := bufio.NewReader(r)
+
+	if from > 0 {
+		// skip the end of the current line
+		skipped, err := br.ReadString('\n')
+		offset += int64(len(skipped))
+		if err == io.EOF {
+			return 0, time.Time{}, false, nil
+		} else if err != nil {
+			return 0, time.Time{}, false, err
+		}
+	}
+
+	for offset < limit {
+		line, err := br.ReadString('\n')
+		if strings.HasPrefix(line, "# Time: ") {
+			if t, ok := parseTimeLine(line); ok {
+				return offset, t, true, nil
+			}
+		}
+		offset += int64(len(line))
+
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return 0, time.Time{}, false, err
+		}
+	}
+	return 0, time.Time{}, false, nil
+}
+
+// parseTimeLine parses the time of a "# Time:" line, as the parsers do: MySQL
+// and TiDB log RFC 3339 times, while MariaDB logs times such as 210323 11:31:57
+// in UTC
+func parseTimeLine(line string) (time.Time, bool) {
+	fields := strings.Fields(strings.TrimPrefix(line, "# Time: "))
+	switch len(fields) {
+	case 1:
+		t, err := time.Parse(time.RFC3339Nano, fields[0])
+		return t, err == nil
+	case 2:
+		t, err := time.Parse("060102 15:04:05", fields[0]+" "+fields[1])
+		return t, err == nil
+	}
+	return time.Time{}, false
+}
+
+// InWindow tells if t is between since and until, and if it is past until.
+// Logs are ordered by time, so the queries that follow one past until are
+// outside the window too. A zero since or until leaves the window open on that
+// side
+func InWindow(t, since, until time.Time) (in, past bool) {
+	if !until.IsZero() && t.After(until) {
+		return false, true
+	}
+	return since.IsZero() || !t.Before(since), false
+}
+
+// ParseTime parses an absolute time, such as 2021-03-23T11:31:57Z or
+// 2021-03-23 11:31:57 (in UTC), or a time relative to now, such as -2h, -30m or
+// -1d
+func ParseTime(s string, now time.Time) (time.Time, error) {
+	if strings.HasPrefix(s, "-") || strings.HasPrefix(s, "+") {
+		// days are not supported by time.ParseDuration
+		if strings.HasSuffix(s, "d") {
+			days, err := strconv.Atoi(strings.TrimSuffix(s, "d"))
+			if err != nil {
+				return time.Time{}, errors.New("invalid relative time: " + s)
+			}
+			return now.AddDate(0, 0, days), nil
+		}
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return time.Time{}, errors.New("invalid relative time: " + s)
+		}
+		return now.Add(d), nil
+	}
+
+	for _, layout := range timeLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, errors.New("invalid time: " + s)
+}
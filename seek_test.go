@@ -0,0 +1,223 @@
+package slowql
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// buildLog returns a slow query log of n queries, logged every minute from
+// start
+func buildLog(k Kind, start time.Time, n int) string {
+	var b strings.Builder
+	if k != TiDB {
+		b.WriteString("/usr/sbin/mysqld, Version: 8.0.26 (MySQL Community Server - GPL). started with:\n")
+		b.WriteString("Tcp port: 3306  Unix socket: /var/run/mysqld/mysqld.sock\n")
+		b.WriteString("Time                 Id Command    Argument\n")
+	}
+	for i := 0; i < n; i++ {
+		t := start.Add(time.Duration(i) * time.Minute)
+		switch k {
+		case MariaDB:
+			fmt.Fprintf(&b, "# Time: %s\n", t.Format("060102 15:04:05"))
+			fmt.Fprintf(&b, "# User@Host: app[app] @  [10.0.0.1]\n# Thread_id: %d  Schema: shop  QC_hit: No\n", i)
+			b.WriteString("# Query_time: 0.100000  Lock_time: 0.000000  Rows_sent: 1  Rows_examined: 1\n")
+		case TiDB:
+			fmt.Fprintf(&b, "# Time: %s\n# Conn_ID: %d\n# Query_time: 0.1\n", t.Format(time.RFC3339Nano), i)
+		default:
+			fmt.Fprintf(&b, "# Time: %s\n", t.Format("2006-01-02T15:04:05.000000Z07:00"))
+			fmt.Fprintf(&b, "# User@Host: app[app] @  [10.0.0.1]  Id: %d\n", i)
+			b.WriteString("# Query_time: 0.100000  Lock_time: 0.000000 Rows_sent: 1  Rows_examined: 1\n")
+		}
+		fmt.Fprintf(&b, "SET timestamp=%d;\nSELECT %d\nFROM t;\n", t.Unix(), i)
+	}
+	return b.String()
+}
+
+func TestSeek(t *testing.T) {
+	start := time.Date(2021, 3, 23, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name      string
+		kind      Kind
+		since     time.Time
+		wantFirst int
+		wantCount int
+	}{
+		{name: "mysql middle", kind: MySQL, since: start.Add(137 * time.Minute), wantFirst: 137, wantCount: 63},
+		{name: "mysql between two queries", kind: MySQL, since: start.Add(42*time.Minute + time.Second), wantFirst: 43, wantCount: 157},
+		{name: "mysql before the log", kind: MySQL, since: start.Add(-time.Hour), wantFirst: 0, wantCount: 200},
+		{name: "mysql after the log", kind: MySQL, since: start.Add(24 * time.Hour), wantCount: 0},
+		{name: "mariadb", kind: MariaDB, since: start.Add(61 * time.Minute), wantFirst: 61, wantCount: 139},
+		{name: "tidb", kind: TiDB, since: start.Add(199 * time.Minute), wantFirst: 199, wantCount: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := Seek(tt.kind, strings.NewReader(buildLog(tt.kind, start, 200)), tt.since)
+			if err != nil {
+				t.Fatalf("Seek() error = %v", err)
+			}
+
+			p := NewParser(tt.kind, r)
+			count := 0
+			for {
+				q := p.GetNext()
+				if q.IsZero() {
+					break
+				}
+				if count == 0 && q.Query != fmt.Sprintf("SELECT %d FROM t;", tt.wantFirst) {
+					t.Errorf("Seek() first query = %q, want SELECT %d FROM t;", q.Query, tt.wantFirst)
+				}
+				count++
+			}
+			if count != tt.wantCount {
+				t.Errorf("Seek() returned %d queries, want %d", count, tt.wantCount)
+			}
+		})
+	}
+}
+
+func TestSeek_unsupported(t *testing.T) {
+	tests := []struct {
+		name string
+		kind Kind
+		log  string
+	}{
+		{name: "postgresql", kind: PostgreSQL,
+			log: "2021-03-23 11:31:57.123 UTC [42] LOG:  duration: 1.5 ms  statement: SELECT 1\n"},
+		{name: "cloudwatch", kind: MySQL,
+			log: `{"timestamp":1616499117000,"message":"# Time: 2021-03-23T11:31:57.123456Z\n# Query_time: 1.2\nSELECT 1;"}` + "\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := Seek(tt.kind, strings.NewReader(tt.log), time.Now())
+			if err != nil {
+				t.Fatalf("Seek() error = %v", err)
+			}
+			got, err := ioutil.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.log {
+				t.Errorf("Seek() = %q, want the whole log %q", got, tt.log)
+			}
+		})
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	now := time.Date(2021, 3, 23, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		s       string
+		want    time.Time
+		wantErr bool
+	}{
+		{name: "rfc3339", s: "2021-03-23T11:31:57+01:00", want: time.Date(2021, 3, 23, 10, 31, 57, 0, time.UTC)},
+		{name: "date and time", s: "2021-03-23 11:31:57", want: time.Date(2021, 3, 23, 11, 31, 57, 0, time.UTC)},
+		{name: "date", s: "2021-03-23", want: time.Date(2021, 3, 23, 0, 0, 0, 0, time.UTC)},
+		{name: "relative hours", s: "-2h", want: time.Date(2021, 3, 23, 10, 0, 0, 0, time.UTC)},
+		{name: "relative minutes", s: "-1h30m", want: time.Date(2021, 3, 23, 10, 30, 0, 0, time.UTC)},
+		{name: "relative days", s: "-2d", want: time.Date(2021, 3, 21, 12, 0, 0, 0, time.UTC)},
+		{name: "invalid relative", s: "-2w", wantErr: true},
+		{name: "invalid", s: "yesterday", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseTime(tt.s, now)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseTime() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("ParseTime() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInWindow(t *testing.T) {
+	since := time.Date(2021, 3, 23, 11, 0, 0, 0, time.UTC)
+	until := time.Date(2021, 3, 23, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		t        time.Time
+		since    time.Time
+		until    time.Time
+		wantIn   bool
+		wantPast bool
+	}{
+		{name: "no window", t: since, wantIn: true},
+		{name: "inside", t: since.Add(time.Minute), since: since, until: until, wantIn: true},
+		{name: "at since", t: since, since: since, until: until, wantIn: true},
+		{name: "at until", t: until, since: since, until: until, wantIn: true},
+		{name: "before since", t: since.Add(-time.Second), since: since, until: until},
+		{name: "after until", t: until.Add(time.Second), since: since, until: until, wantPast: true},
+		{name: "only since", t: until.Add(time.Hour), since: since, wantIn: true},
+		{name: "only until", t: since.Add(-time.Hour), until: until, wantIn: true},
+		{name: "unknown time", since: since, until: until},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in, past := InWindow(tt.t, tt.since, tt.until)
+			if in != tt.wantIn || past != tt.wantPast {
+				t.Errorf("InWindow() = %v, %v, want %v, %v", in, past, tt.wantIn, tt.wantPast)
+			}
+		})
+	}
+}
+
+func TestInWindow_withoutTime(t *testing.T) {
+	// MariaDB only logs "# Time:" when the second changes
+	log := `/usr/sbin/mysqld, Version: 10.5.9-MariaDB-1:10.5.9+maria~focal-log (mariadb.org binary distribution). started with:
+Tcp port: 3306  Unix socket: /run/mysqld/mysqld.sock
+Time                Id Command  Argument
+# Time: 210323 11:31:56
+# User@Host: app[app] @  [10.0.0.1]
+# Thread_id: 1  Schema: shop  QC_hit: No
+# Query_time: 0.100000  Lock_time: 0.000000  Rows_sent: 1  Rows_examined: 1
+SELECT 0 FROM t;
+# Time: 210323 11:31:57
+# User@Host: app[app] @  [10.0.0.1]
+# Thread_id: 2  Schema: shop  QC_hit: No
+# Query_time: 0.100000  Lock_time: 0.000000  Rows_sent: 1  Rows_examined: 1
+SELECT 1 FROM t;
+# User@Host: app[app] @  [10.0.0.1]
+# Thread_id: 3  Schema: shop  QC_hit: No
+# Query_time: 0.100000  Lock_time: 0.000000  Rows_sent: 1  Rows_examined: 1
+SELECT 2 FROM t;
+# User@Host: app[app] @  [10.0.0.1]
+# Thread_id: 4  Schema: shop  QC_hit: No
+# Query_time: 0.100000  Lock_time: 0.000000  Rows_sent: 1  Rows_examined: 1
+SELECT 3 FROM t;
+`
+	since := time.Date(2021, 3, 23, 11, 31, 57, 0, time.UTC)
+	p := NewParser(MariaDB, strings.NewReader(log))
+	var got []string
+	for {
+		q := p.GetNext()
+		if q.IsZero() {
+			break
+		}
+		if in, _ := InWindow(q.Time, since, time.Time{}); in {
+			got = append(got, q.Query)
+		}
+	}
+	want := []string{"SELECT 1 FROM t;", "SELECT 2 FROM t;", "SELECT 3 FROM t;"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("queries in the window = %q, want %q", got, want)
+	}
+}
+
+func BenchmarkSeek(b *testing.B) {
+	start := time.Date(2021, 3, 23, 0, 0, 0, 0, time.UTC)
+	log := []byte(buildLog(MySQL, start, 100000))
+	since := start.Add(75000 * time.Minute)
+	for i := 0; i < b.N; i++ {
+		if _, err := Seek(MySQL, bytes.NewReader(log), since); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
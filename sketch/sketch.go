@@ -0,0 +1,317 @@
+// Package sketch computes approximate quantiles of a stream of values with a
+// DDSketch: values are counted in buckets whose width grows exponentially, so
+// that any quantile is returned with a bounded relative error, whatever the
+// number of values.
+//
+// Sketches use a bounded amount of memory, can be merged, and can be saved as
+// JSON. They are meant for positive values, such as durations; negative values
+// are counted as zeros.
+//
+// See "DDSketch: A Fast and Fully-Mergeable Quantile Sketch with
+// Relative-Error Guarantees", Masson et al., VLDB 2019.
+package sketch
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+)
+
+const (
+	// DefaultAccuracy is the relative accuracy of the sketches created by
+	// New: quantiles are within 1% of the exact values
+	DefaultAccuracy = 0.01
+
+	// MaxBuckets bounds the number of buckets of a sketch. When there are
+	// more, the lowest buckets are collapsed, which loses accuracy for the
+	// lowest quantiles only. With the default accuracy, it takes values
+	// spanning more than 35 orders of magnitude
+	MaxBuckets = 4096
+
+	// minIndexable is the smallest value that has its own bucket: smaller
+	// values are counted as zeros
+	minIndexable = 1e-9
+)
+
+// Sketch is a quantile sketch. The zero value is not usable: use New or
+// NewWithAccuracy
+type Sketch struct {
+	accuracy float64
+	gamma    float64
+	logGamma float64
+
+	// buckets[i] is the number of values of the bucket offset+i. The bucket k
+	// holds the values in (gamma^(k-1), gamma^k]
+	offset  int
+	buckets []uint64
+	zeros   uint64
+
+	count      uint64
+	sum        float64
+	sumSquares float64
+	min        float64
+	max        float64
+}
+
+// New returns an empty sketch with the default accuracy
+func New() *Sketch {
+	s, _ := NewWithAccuracy(DefaultAccuracy)
+	return s
+}
+
+// NewWithAccuracy returns an empty sketch whose quantiles are within accuracy
+// (relatively) of the exact values. The accuracy must be between 0 and 1
+func NewWithAccuracy(accuracy float64) (*Sketch, error) {
+	if accuracy <= 0 || accuracy >= 1 {
+		return nil, errors.New("accuracy must be between 0 and 1")
+	}
+	gamma := (1 + accuracy) / (1 - accuracy)
+	return &Sketch{
+		accuracy: accuracy,
+		gamma:    gamma,
+		logGamma: math.Log(gamma),
+	}, nil
+}
+
+// Accuracy returns the relative accuracy of the sketch
+func (s *Sketch) Accuracy() float64 {
+	return s.accuracy
+}
+
+// Add adds a value to the sketch
+func (s *Sketch) Add(v float64) {
+	if s.count == 0 || v < s.min {
+		s.min = v
+	}
+	if s.count == 0 || v > s.max {
+		s.max = v
+	}
+	s.count++
+	s.sum += v
+	s.sumSquares += v * v
+
+	if v <= minIndexable {
+		s.zeros++
+		return
+	}
+	s.grow(s.index(v))
+	s.buckets[s.index(v)-s.offset]++
+	s.collapse()
+}
+
+// Merge adds the values of o to the sketch. Both sketches must have the same
+// accuracy
+func (s *Sketch) Merge(o *Sketch) error {
+	if o == nil || o.count == 0 {
+		return nil
+	}
+	if s.gamma != o.gamma {
+		return errors.New("cannot merge sketches of different accuracies")
+	}
+
+	if s.count == 0 || o.min < s.min {
+		s.min = o.min
+	}
+	if s.count == 0 || o.max > s.max {
+		s.max = o.max
+	}
+	s.count += o.count
+	s.sum += o.sum
+	s.sumSquares += o.sumSquares
+	s.zeros += o.zeros
+
+	if len(o.buckets) > 0 {
+		s.grow(o.offset)
+		s.grow(o.offset + len(o.buckets) - 1)
+		for i, c := range o.buckets {
+			s.buckets[o.offset+i-s.offset] += c
+		}
+		s.collapse()
+	}
+	return nil
+}
+
+// Quantile returns the approximate value of the q quantile, q being between 0
+// and 1: Quantile(0.95) is the 95th percentile. It returns 0 for an empty
+// sketch
+func (s *Sketch) Quantile(q float64) float64 {
+	if s.count == 0 {
+		return 0
+	}
+	if q <= 0 {
+		return s.min
+	}
+	if q >= 1 {
+		return s.max
+	}
+
+	rank := q * float64(s.count-1)
+	if rank < float64(s.zeros) {
+		return s.clamp(0)
+	}
+	cum := float64(s.zeros)
+	for i, c := range s.buckets {
+		cum += float64(c)
+		if cum > rank {
+			return s.clamp(s.value(s.offset + i))
+		}
+	}
+	return s.max
+}
+
+// Count returns the number of values
+func (s *Sketch) Count() uint64 {
+	return s.count
+}
+
+// Sum returns the sum of the values
+func (s *Sketch) Sum() float64 {
+	return s.sum
+}
+
+// Min returns the smallest value, or 0 for an empty sketch
+func (s *Sketch) Min() float64 {
+	return s.min
+}
+
+// Max returns the largest value, or 0 for an empty sketch
+func (s *Sketch) Max() float64 {
+	return s.max
+}
+
+// Mean returns the mean of the values, or 0 for an empty sketch
+func (s *Sketch) Mean() float64 {
+	if s.count == 0 {
+		return 0
+	}
+	return s.sum / float64(s.count)
+}
+
+// StdDev returns the population standard deviation of the values, which is
+// exact
+func (s *Sketch) StdDev() float64 {
+	if s.count == 0 {
+		return 0
+	}
+	mean := s.Mean()
+	variance := s.sumSquares/float64(s.count) - mean*mean
+	if variance < 0 {
+		// rounding errors
+		return 0
+	}
+	return math.Sqrt(variance)
+}
+
+// Buckets calls f for each non-empty bucket, from the lowest to the highest,
+// with the bounds of the bucket and its number of values. The lower bound of
+// the bucket of zeros is 0
+func (s *Sketch) Buckets(f func(lower, upper float64, count uint64)) {
+	if s.zeros > 0 {
+		f(0, minIndexable, s.zeros)
+	}
+	for i, c := range s.buckets {
+		if c == 0 {
+			continue
+		}
+		k := s.offset + i
+		f(math.Pow(s.gamma, float64(k-1)), math.Pow(s.gamma, float64(k)), c)
+	}
+}
+
+// index returns the bucket of a value
+func (s *Sketch) index(v float64) int {
+	return int(math.Ceil(math.Log(v) / s.logGamma))
+}
+
+// value returns the value that represents the bucket k, which is within the
+// relative accuracy of any value of the bucket
+func (s *Sketch) value(k int) float64 {
+	return 2 * math.Pow(s.gamma, float64(k)) / (s.gamma + 1)
+}
+
+// clamp bounds a value by the smallest and largest values
+func (s *Sketch) clamp(v float64) float64 {
+	return math.Max(s.min, math.Min(s.max, v))
+}
+
+// grow extends the buckets so that they include the bucket k
+func (s *Sketch) grow(k int) {
+	switch {
+	case len(s.buckets) == 0:
+		s.offset = k
+		s.buckets = make([]uint64, 1)
+	case k < s.offset:
+		buckets := make([]uint64, s.offset-k+len(s.buckets))
+		copy(buckets[s.offset-k:], s.buckets)
+		s.buckets = buckets
+		s.offset = k
+	case k >= s.offset+len(s.buckets):
+		s.buckets = append(s.buckets, make([]uint64, k-s.offset-len(s.buckets)+1)...)
+	}
+}
+
+// collapse merges the lowest buckets when there are more than MaxBuckets
+func (s *Sketch) collapse() {
+	extra := len(s.buckets) - MaxBuckets
+	if extra <= 0 {
+		return
+	}
+	for _, c := range s.buckets[:extra] {
+		s.buckets[extra] += c
+	}
+	s.buckets = append([]uint64(nil), s.buckets[extra:]...)
+	s.offset += extra
+}
+
+// jsonSketch is the JSON representation of a sketch
+type jsonSketch struct {
+	Accuracy   float64  `json:"accuracy"`
+	Offset     int      `json:"offset"`
+	Buckets    []uint64 `json:"buckets"`
+	Zeros      uint64   `json:"zeros"`
+	Count      uint64   `json:"count"`
+	Sum        float64  `json:"sum"`
+	SumSquares float64  `json:"sum_squares"`
+	Min        float64  `json:"min"`
+	Max        float64  `json:"max"`
+}
+
+// MarshalJSON implements json.Marshaler
+func (s *Sketch) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonSketch{
+		Accuracy:   s.accuracy,
+		Offset:     s.offset,
+		Buckets:    s.buckets,
+		Zeros:      s.zeros,
+		Count:      s.count,
+		Sum:        s.sum,
+		SumSquares: s.sumSquares,
+		Min:        s.min,
+		Max:        s.max,
+	})
+}
+
+// UnmarshalJSON implements json.Unmarshaler
+func (s *Sketch) UnmarshalJSON(data []byte) error {
+	var js jsonSketch
+	if err := json.Unmarshal(data, &js); err != nil {
+		return err
+	}
+	n, err := NewWithAccuracy(js.Accuracy)
+	if err != nil {
+		return err
+	}
+	if len(js.Buckets) > MaxBuckets {
+		return errors.New("too many buckets")
+	}
+	n.offset = js.Offset
+	n.buckets = js.Buckets
+	n.zeros = js.Zeros
+	n.count = js.Count
+	n.sum = js.Sum
+	n.sumSquares = js.SumSquares
+	n.min = js.Min
+	n.max = js.Max
+	*s = *n
+	return nil
+}
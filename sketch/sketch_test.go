@@ -0,0 +1,207 @@
+package sketch
+
+import (
+	"encoding/json"
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+// values returns n values spanning several orders of magnitude, like query
+// times
+func values(n int, seed int64) []float64 {
+	r := rand.New(rand.NewSource(seed))
+	v := make([]float64, n)
+	for i := range v {
+		v[i] = math.Exp(r.NormFloat64()*2 - 4)
+	}
+	return v
+}
+
+// exact returns the exact q quantile of sorted values, with the rank used by
+// Quantile
+func exact(sorted []float64, q float64) float64 {
+	return sorted[int(q*float64(len(sorted)-1))]
+}
+
+func TestSketch_Quantile(t *testing.T) {
+	tests := []struct {
+		name     string
+		values   []float64
+		accuracy float64
+	}{
+		{name: "default accuracy", values: values(100000, 1), accuracy: DefaultAccuracy},
+		{name: "coarse accuracy", values: values(10000, 2), accuracy: 0.05},
+		{name: "with zeros", values: append(values(1000, 3), 0, 0, 0, 0, 0), accuracy: DefaultAccuracy},
+		{name: "single value", values: []float64{1.5}, accuracy: DefaultAccuracy},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := NewWithAccuracy(tt.accuracy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, v := range tt.values {
+				s.Add(v)
+			}
+			sorted := append([]float64(nil), tt.values...)
+			sort.Float64s(sorted)
+
+			for _, q := range []float64{0, 0.01, 0.25, 0.5, 0.75, 0.9, 0.95, 0.99, 0.999, 1} {
+				want := exact(sorted, q)
+				got := s.Quantile(q)
+				if math.Abs(got-want) > tt.accuracy*want+1e-12 {
+					t.Errorf("Quantile(%v) = %v, want %v within %v", q, got, want, tt.accuracy)
+				}
+			}
+		})
+	}
+}
+
+func TestSketch_stats(t *testing.T) {
+	s := New()
+	for _, v := range []float64{1, 2, 3, 4} {
+		s.Add(v)
+	}
+	tests := []struct {
+		name string
+		got  float64
+		want float64
+	}{
+		{name: "count", got: float64(s.Count()), want: 4},
+		{name: "sum", got: s.Sum(), want: 10},
+		{name: "min", got: s.Min(), want: 1},
+		{name: "max", got: s.Max(), want: 4},
+		{name: "mean", got: s.Mean(), want: 2.5},
+		{name: "stddev", got: s.StdDev(), want: math.Sqrt(1.25)},
+		{name: "empty quantile", got: New().Quantile(0.5), want: 0},
+		{name: "empty stddev", got: New().StdDev(), want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if math.Abs(tt.got-tt.want) > 1e-9 {
+				t.Errorf("got %v, want %v", tt.got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSketch_Merge(t *testing.T) {
+	a, b, all := New(), New(), New()
+	for i, v := range values(20000, 4) {
+		if i%3 == 0 {
+			a.Add(v)
+		} else {
+			b.Add(v)
+		}
+		all.Add(v)
+	}
+	b.Add(0)
+	all.Add(0)
+
+	if err := a.Merge(b); err != nil {
+		t.Fatalf("Merge() error = %v", err)
+	}
+	if a.Count() != all.Count() || a.Min() != all.Min() || a.Max() != all.Max() {
+		t.Errorf("Merge() = %d values in [%v, %v], want %d in [%v, %v]",
+			a.Count(), a.Min(), a.Max(), all.Count(), all.Min(), all.Max())
+	}
+	for _, q := range []float64{0.1, 0.5, 0.95, 0.99} {
+		if a.Quantile(q) != all.Quantile(q) {
+			t.Errorf("Quantile(%v) = %v after Merge(), want %v", q, a.Quantile(q), all.Quantile(q))
+		}
+	}
+
+	if err := New().Merge(nil); err != nil {
+		t.Errorf("Merge(nil) error = %v", err)
+	}
+	coarse, _ := NewWithAccuracy(0.05)
+	coarse.Add(1)
+	if err := a.Merge(coarse); err == nil {
+		t.Error("Merge() of sketches of different accuracies succeeded")
+	}
+}
+
+func TestSketch_collapse(t *testing.T) {
+	// with an accuracy of 0.01%, values from 1 to 20000 need about 50000
+	// buckets, while MaxBuckets only cover a factor of 2.27
+	const accuracy = 0.0001
+	s, _ := NewWithAccuracy(accuracy)
+	var all []float64
+	for i := 0; i < 10000; i++ {
+		v := math.Pow(1.001, float64(i))
+		s.Add(v)
+		all = append(all, v)
+	}
+	if len(s.buckets) != MaxBuckets {
+		t.Errorf("sketch has %d buckets, want %d", len(s.buckets), MaxBuckets)
+	}
+	if s.Count() != uint64(len(all)) {
+		t.Errorf("Count() = %d, want %d", s.Count(), len(all))
+	}
+	// the highest quantiles are still accurate
+	for _, q := range []float64{0.95, 0.99, 1} {
+		if got, want := s.Quantile(q), exact(all, q); math.Abs(got-want) > accuracy*want {
+			t.Errorf("Quantile(%v) = %v, want %v", q, got, want)
+		}
+	}
+}
+
+func TestSketch_Buckets(t *testing.T) {
+	s := New()
+	for _, v := range []float64{0, 0.001, 0.001, 1, 100} {
+		s.Add(v)
+	}
+	var total uint64
+	previous := -1.0
+	s.Buckets(func(lower, upper float64, count uint64) {
+		if lower < previous || upper <= lower {
+			t.Errorf("Buckets() bucket (%v, %v] is out of order", lower, upper)
+		}
+		previous = upper
+		total += count
+	})
+	if total != s.Count() {
+		t.Errorf("Buckets() counted %d values, want %d", total, s.Count())
+	}
+}
+
+func TestSketch_JSON(t *testing.T) {
+	s := New()
+	for _, v := range values(1000, 5) {
+		s.Add(v)
+	}
+	s.Add(0)
+
+	data, err := json.Marshal(s)
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	var got Sketch
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if got.Count() != s.Count() || got.Accuracy() != s.Accuracy() || got.StdDev() != s.StdDev() {
+		t.Errorf("Unmarshal() = %d values, accuracy %v, want %d values, accuracy %v",
+			got.Count(), got.Accuracy(), s.Count(), s.Accuracy())
+	}
+	for _, q := range []float64{0, 0.5, 0.99, 1} {
+		if got.Quantile(q) != s.Quantile(q) {
+			t.Errorf("Quantile(%v) = %v after Unmarshal(), want %v", q, got.Quantile(q), s.Quantile(q))
+		}
+	}
+
+	if err := json.Unmarshal([]byte(`{"accuracy": 2}`), &got); err == nil {
+		t.Error("Unmarshal() of an invalid accuracy succeeded")
+	}
+}
+
+func BenchmarkSketch_Add(b *testing.B) {
+	v := values(1024, 6)
+	s := New()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.Add(v[i%len(v)])
+	}
+}
@@ -1,5 +1,5 @@
 // Package slowql provides everything needed to parse slow query logs from
-// different databases (such as MySQL, MariaDB).
+// different databases (such as MySQL, MariaDB, PostgreSQL, TiDB).
 // Along to a parser, it proposes a simple API with few functions that allow
 // you to get everything needed to compute your slow queries.
 package slowql
@@ -7,11 +7,15 @@ package slowql
 import (
 	"bufio"
 	"io"
+	"regexp"
 	"strings"
 	"time"
 
+	"github.com/devops-works/slowql/database/general"
 	"github.com/devops-works/slowql/database/mariadb"
 	"github.com/devops-works/slowql/database/mysql"
+	"github.com/devops-works/slowql/database/postgresql"
+	"github.com/devops-works/slowql/database/tidb"
 	"github.com/devops-works/slowql/query"
 	"github.com/devops-works/slowql/server"
 	"github.com/sirupsen/logrus"
@@ -29,6 +33,12 @@ const (
 	MariaDB
 	// PXC type
 	PXC
+	// PostgreSQL type
+	PostgreSQL
+	// TiDB type
+	TiDB
+	// General is the MySQL and MariaDB general query log
+	General
 )
 
 // Database is the parser interface
@@ -37,7 +47,7 @@ type Database interface {
 	// GetNext() Query
 	// // GetServerMeta returns informations about the SQL server in usage
 	// GetServerMeta() Server
-	ParseBlocks(rawBlocks chan []string)
+	ParseBlocks(rawBlocks chan query.Block)
 	ParseServerMeta(chan []string)
 	GetServerMeta() server.Server
 }
@@ -46,7 +56,7 @@ type Database interface {
 type Parser struct {
 	db          Database
 	waitingList chan query.Query
-	rawBlocks   chan []string
+	rawBlocks   chan query.Block
 	servermeta  chan []string
 }
 
@@ -54,17 +64,27 @@ type Parser struct {
 func NewParser(k Kind, r io.Reader) Parser {
 	var p Parser
 
-	p.rawBlocks = make(chan []string, 4096)
+	p.rawBlocks = make(chan query.Block, 4096)
 	p.servermeta = make(chan []string)
 	p.waitingList = make(chan query.Query, 4096)
 
-	go scan(*bufio.NewScanner(r), p.rawBlocks, p.servermeta)
-
 	switch k {
 	case MySQL, PXC:
 		p.db = mysql.New(p.waitingList)
+		go scan(*bufio.NewScanner(r), p.rawBlocks, p.servermeta, 3)
 	case MariaDB:
 		p.db = mariadb.New(p.waitingList)
+		go scan(*bufio.NewScanner(r), p.rawBlocks, p.servermeta, 3)
+	case TiDB:
+		// TiDB slow logs do not start with a banner
+		p.db = tidb.New(p.waitingList)
+		go scan(*bufio.NewScanner(r), p.rawBlocks, p.servermeta, 0)
+	case General:
+		p.db = general.New(p.waitingList)
+		go scanGeneral(*bufio.NewScanner(r), p.rawBlocks, p.servermeta)
+	case PostgreSQL:
+		p.db = postgresql.New(p.waitingList)
+		go scanPostgreSQL(*bufio.NewScanner(r), p.rawBlocks, p.servermeta)
 	}
 
 	p.db.ParseServerMeta(p.servermeta)
@@ -93,8 +113,13 @@ func (p *Parser) GetServerMeta() server.Server {
 	return p.db.GetServerMeta()
 }
 
-func scan(s bufio.Scanner, rawBlocks, servermeta chan []string) {
+// scan splits a slow query log into blocks made of header lines followed by
+// the query lines. The first headerLines lines hold the server banner
+func scan(s bufio.Scanner, rawBlocks chan query.Block, servermeta chan []string, headerLines int) {
 	var bloc []string
+	// line is the number of the current line, start the one of the first
+	// line of the bloc
+	line, start := headerLines, 0
 	inHeader, inQuery := false, false
 
 	// initial buffer size (64k)
@@ -105,30 +130,31 @@ func scan(s bufio.Scanner, rawBlocks, servermeta chan []string) {
 
 	// Parse the server informations
 	var lines []string
-	for i := 0; i < 3; i++ {
+	for i := 0; i < headerLines; i++ {
 		s.Scan()
 		lines = append(lines, s.Text())
 	}
 	servermeta <- lines
 
 	for s.Scan() {
-		line := s.Text()
+		line++
+		text := s.Text()
 		// Drop useless lines
-		if strings.Contains(s.Text(), "SET timestamp") {
+		if strings.Contains(text, "SET timestamp") {
 			continue
 		}
 
 		// This big if/else statement detects if the curernt line in a header
 		// or a request, and if it belongs to the same bloc or not
 		// In header
-		if strings.HasPrefix(line, "#") {
+		if strings.HasPrefix(text, "#") {
 			inHeader = true
 			if inQuery {
 				// A new bloc is starting, we send the previous one if it is not
 				// the first one
 				inQuery = false
 				if len(bloc) > 0 {
-					rawBlocks <- bloc
+					rawBlocks <- query.Block{Line: start, Lines: bloc}
 					bloc = nil
 				}
 			}
@@ -140,7 +166,10 @@ func scan(s bufio.Scanner, rawBlocks, servermeta chan []string) {
 				inHeader = false
 			}
 		}
-		bloc = append(bloc, line)
+		if len(bloc) == 0 {
+			start = line
+		}
+		bloc = append(bloc, text)
 	}
 
 	// In case of error, log it
@@ -149,7 +178,106 @@ func scan(s bufio.Scanner, rawBlocks, servermeta chan []string) {
 	}
 
 	// Send the last bloc
-	rawBlocks <- bloc
+	rawBlocks <- query.Block{Line: start, Lines: bloc}
+
+	close(rawBlocks)
+}
+
+// postgresqlField matches the first field of a PostgreSQL message, such as LOG
+// or DETAIL, which comes right after the log_line_prefix
+var postgresqlField = regexp.MustCompile(`^.*?\b(DEBUG[1-5]|INFO|NOTICE|WARNING|ERROR|LOG|FATAL|PANIC|DETAIL|HINT|QUERY|CONTEXT|LOCATION|STATEMENT):  `)
+
+// scanPostgreSQL splits a PostgreSQL log into records. A record starts with a
+// line holding the log_line_prefix and goes on with the tab-indented lines of
+// multi-line messages. DETAIL records are attached to the record they belong
+// to, so the parameters of a statement come with it
+func scanPostgreSQL(s bufio.Scanner, rawBlocks chan query.Block, servermeta chan []string) {
+	var bloc []string
+	line, start := 0, 0
+
+	buf := make([]byte, 0, 64*1024)
+	s.Buffer(buf, 1024*1024)
+
+	// PostgreSQL logs do not start with a banner
+	servermeta <- nil
+
+	for s.Scan() {
+		line++
+		text := s.Text()
+		if text == "" {
+			continue
+		}
+
+		if !strings.HasPrefix(text, "\t") && !isPostgreSQLDetail(text) {
+			if len(bloc) > 0 {
+				rawBlocks <- query.Block{Line: start, Lines: bloc}
+			}
+			bloc = nil
+		}
+		if len(bloc) == 0 {
+			start = line
+		}
+		bloc = append(bloc, text)
+	}
+
+	if err := s.Err(); err != nil {
+		logrus.Error(err)
+	}
+
+	if len(bloc) > 0 {
+		rawBlocks <- query.Block{Line: start, Lines: bloc}
+	}
+
+	close(rawBlocks)
+}
+
+// isPostgreSQLDetail tells if a line holds a DETAIL record, as opposed to a
+// message that only mentions DETAIL in its text, such as a statement
+func isPostgreSQLDetail(line string) bool {
+	m := postgresqlField.FindStringSubmatch(line)
+	return m != nil && m[1] == "DETAIL"
+}
+
+// scanGeneral splits a general query log into records. A record starts with a
+// line holding the time, thread ID and command, and goes on with the lines of
+// multi-line arguments
+func scanGeneral(s bufio.Scanner, rawBlocks chan query.Block, servermeta chan []string) {
+	var bloc []string
+	line, start := 3, 0
+
+	buf := make([]byte, 0, 64*1024)
+	s.Buffer(buf, 1024*1024)
+
+	// Parse the server informations
+	var lines []string
+	for i := 0; i < 3; i++ {
+		s.Scan()
+		lines = append(lines, s.Text())
+	}
+	servermeta <- lines
+
+	for s.Scan() {
+		line++
+		text := s.Text()
+		if general.RecordRegexp.MatchString(text) {
+			if len(bloc) > 0 {
+				rawBlocks <- query.Block{Line: start, Lines: bloc}
+			}
+			bloc = nil
+		}
+		if len(bloc) == 0 {
+			start = line
+		}
+		bloc = append(bloc, text)
+	}
+
+	if err := s.Err(); err != nil {
+		logrus.Error(err)
+	}
+
+	if len(bloc) > 0 {
+		rawBlocks <- query.Block{Line: start, Lines: bloc}
+	}
 
 	close(rawBlocks)
 }
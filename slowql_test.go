@@ -0,0 +1,31 @@
+package slowql
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParser_postgreSQLRecords(t *testing.T) {
+	log := strings.Join([]string{
+		"2021-03-23 11:31:57 UTC [1234] LOG:  duration: 2.000 ms  execute <unnamed>: SELECT * FROM users WHERE id = $1",
+		"2021-03-23 11:31:57 UTC [1234] DETAIL:  parameters: $1 = '42'",
+		"2021-03-23 11:31:58 UTC [1234] LOG:  duration: 1.000 ms  statement: SELECT 'DETAIL:  none'",
+		"2021-03-23 11:31:59 UTC [1234] LOG:  duration: 3.000 ms  statement: SELECT a",
+		"\tFROM t",
+	}, "\n") + "\n"
+
+	var got []string
+	p := NewParser(PostgreSQL, strings.NewReader(log))
+	for {
+		q := p.GetNext()
+		if q.IsZero() {
+			break
+		}
+		got = append(got, q.Query)
+	}
+	want := []string{"SELECT * FROM users WHERE id = '42'", "SELECT 'DETAIL:  none'", "SELECT a\nFROM t"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("GetNext() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,80 @@
+package slowql
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/devops-works/slowql/query"
+	"github.com/devops-works/slowql/server"
+)
+
+// Writer writes queries in the slow query log format of a database kind
+type Writer struct {
+	w    io.Writer
+	kind Kind
+}
+
+// NewWriter returns a new writer for the desired kind. Only the MySQL, PXC and
+// MariaDB slow query log formats can be written
+func NewWriter(k Kind, w io.Writer) (*Writer, error) {
+	switch k {
+	case MySQL, PXC, MariaDB:
+	default:
+		return nil, errors.New("cannot write slow query logs of this kind")
+	}
+
+	return &Writer{w: w, kind: k}, nil
+}
+
+// WriteServerMeta writes the server banner that starts the slow query log. It
+// has to be called before writing queries for the log to be parsed again
+func (wr *Writer) WriteServerMeta(srv server.Server) error {
+	_, err := fmt.Fprintf(wr.w, "%s, Version: %s (%s). started with:\nTcp port: %d  Unix socket: %s\nTime                 Id Command    Argument\n",
+		srv.Binary,
+		srv.Version,
+		srv.VersionDescription,
+		srv.Port,
+		srv.Socket,
+	)
+	return err
+}
+
+// Write writes a query and its headers
+func (wr *Writer) Write(q query.Query) error {
+	var b strings.Builder
+
+	switch wr.kind {
+	case MariaDB:
+		fmt.Fprintf(&b, "# Time: %s\n", q.Time.Format("060102 15:04:05"))
+		fmt.Fprintf(&b, "# User@Host: %s[%s] @  [%s]\n", q.User, q.User, q.Host)
+		qcHit := "No"
+		if q.QCHit {
+			qcHit = "Yes"
+		}
+		fmt.Fprintf(&b, "# Thread_id: %d  Schema: %s  QC_hit: %s\n", q.ID, q.Schema, qcHit)
+		fmt.Fprintf(&b, "# Query_time: %.6f  Lock_time: %.6f  Rows_sent: %d  Rows_examined: %d\n",
+			q.QueryTime, q.LockTime, q.RowsSent, q.RowsExamined)
+		fmt.Fprintf(&b, "# Rows_affected: %d  Bytes_sent: %d\n", q.RowsAffected, q.BytesSent)
+	default:
+		fmt.Fprintf(&b, "# Time: %s\n", q.Time.Format("2006-01-02T15:04:05.000000Z07:00"))
+		fmt.Fprintf(&b, "# User@Host: %s[%s] @  [%s]  Id: %d\n", q.User, q.User, q.Host, q.ID)
+		fmt.Fprintf(&b, "# Schema: %s  Last_errno: %d  Killed: %d\n", q.Schema, q.LastErrNo, q.Killed)
+		fmt.Fprintf(&b, "# Query_time: %.6f  Lock_time: %.6f  Rows_sent: %d  Rows_examined: %d  Rows_affected: %d\n",
+			q.QueryTime, q.LockTime, q.RowsSent, q.RowsExamined, q.RowsAffected)
+		fmt.Fprintf(&b, "# Bytes_sent: %d\n", q.BytesSent)
+	}
+
+	fmt.Fprintf(&b, "SET timestamp=%d;\n", q.Time.Unix())
+
+	// statements always end with a semicolon in slow query logs
+	b.WriteString(q.Query)
+	if !strings.HasSuffix(q.Query, ";") {
+		b.WriteString(";")
+	}
+	b.WriteString("\n")
+
+	_, err := io.WriteString(wr.w, b.String())
+	return err
+}
@@ -0,0 +1,205 @@
+package slowql
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/devops-works/slowql/query"
+	"github.com/devops-works/slowql/server"
+)
+
+func TestWriter_Write(t *testing.T) {
+	tests := []struct {
+		name string
+		kind Kind
+		q    query.Query
+		want string
+	}{
+		{
+			name: "mysql",
+			kind: MySQL,
+			q: query.Query{
+				Time:         time.Date(2020, 7, 7, 12, 28, 2, 804900000, time.UTC),
+				User:         "api",
+				Host:         "192.168.0.101",
+				ID:           5603761,
+				Schema:       "client-prod",
+				QueryTime:    0.000089,
+				RowsAffected: 2,
+				BytesSent:    1183,
+				Query:        "DELETE FROM sessions WHERE id = 3",
+			},
+			want: `# Time: 2020-07-07T12:28:02.804900Z
+# User@Host: api[api] @  [192.168.0.101]  Id: 5603761
+# Schema: client-prod  Last_errno: 0  Killed: 0
+# Query_time: 0.000089  Lock_time: 0.000000  Rows_sent: 0  Rows_examined: 0  Rows_affected: 2
+# Bytes_sent: 1183
+SET timestamp=1594124882;
+DELETE FROM sessions WHERE id = 3;
+`,
+		},
+		{
+			name: "mariadb",
+			kind: MariaDB,
+			q: query.Query{
+				Time:      time.Date(2021, 3, 23, 11, 31, 57, 0, time.UTC),
+				User:      "hugo",
+				Host:      "172.18.0.3",
+				ID:        12794,
+				QueryTime: 0.000035,
+				BytesSent: 11,
+				Query:     "SELECT col1 AS c1 FROM table1 AS t1;",
+			},
+			want: `# Time: 210323 11:31:57
+# User@Host: hugo[hugo] @  [172.18.0.3]
+# Thread_id: 12794  Schema:   QC_hit: No
+# Query_time: 0.000035  Lock_time: 0.000000  Rows_sent: 0  Rows_examined: 0
+# Rows_affected: 0  Bytes_sent: 11
+SET timestamp=1616499117;
+SELECT col1 AS c1 FROM table1 AS t1;
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			w, err := NewWriter(tt.kind, &buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := w.Write(tt.q); err != nil {
+				t.Fatal(err)
+			}
+			if buf.String() != tt.want {
+				t.Errorf("got = %q, want %q", buf.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestWriter_unsupportedKind(t *testing.T) {
+	if _, err := NewWriter(PostgreSQL, &bytes.Buffer{}); err == nil {
+		t.Error("NewWriter() should fail for PostgreSQL")
+	}
+}
+
+// TestWriter_roundTrip writes the queries of the database/* tests and parses
+// them back
+func TestWriter_roundTrip(t *testing.T) {
+	tests := []struct {
+		name    string
+		kind    Kind
+		srv     server.Server
+		queries []query.Query
+	}{
+		{
+			name: "mysql",
+			kind: MySQL,
+			srv: server.Server{
+				Binary:             "/usr/sbin/mysqld",
+				Port:               3306,
+				Socket:             "/var/run/mysqld/mysqld.sock",
+				Version:            "8.0.23",
+				VersionShort:       "8.0.2",
+				VersionDescription: "MySQL Community Server - GPL",
+			},
+			queries: []query.Query{
+				{
+					Time:         time.Date(2020, 7, 7, 12, 28, 2, 804900000, time.UTC),
+					User:         "api",
+					Host:         "192.168.0.101",
+					ID:           5603761,
+					Schema:       "client-prod",
+					LastErrNo:    1,
+					Killed:       2,
+					QueryTime:    0.000089,
+					LockTime:     0.000013,
+					RowsSent:     1,
+					RowsExamined: 1,
+					BytesSent:    1183,
+					Query:        "SELECT col1 AS c1 FROM table1 AS t1;",
+					Line:         4,
+				},
+				{
+					Time:         time.Date(2021, 3, 23, 14, 38, 32, 489447000, time.UTC),
+					User:         "root",
+					Host:         "172.18.0.1",
+					ID:           9,
+					QueryTime:    0.000328,
+					RowsAffected: 3,
+					Query:        "UPDATE table1 SET col1 = 'foo' WHERE col2 = 42;",
+					Line:         11,
+				},
+			},
+		},
+		{
+			name: "mariadb",
+			kind: MariaDB,
+			srv: server.Server{
+				Binary:             "/opt/bitnami/mariadb/sbin/mysqld",
+				Port:               3306,
+				Socket:             "/opt/bitnami/mariadb/tmp/mysql.sock",
+				Version:            "10.5.9-MariaDB",
+				VersionShort:       "10.5.9",
+				VersionDescription: "Source distribution",
+			},
+			queries: []query.Query{
+				{
+					Time:         time.Date(2021, 3, 23, 11, 31, 57, 0, time.UTC),
+					User:         "hugo",
+					Host:         "172.18.0.3",
+					ID:           12794,
+					Schema:       "shop",
+					QueryTime:    0.000035,
+					RowsSent:     4,
+					RowsExamined: 10,
+					BytesSent:    11,
+					QCHit:        true,
+					Query:        "SELECT col1 AS c1 FROM table1 AS t1;",
+					Line:         4,
+				},
+				{
+					Time:      time.Date(2021, 3, 23, 11, 31, 58, 0, time.UTC),
+					User:      "hugo",
+					Host:      "172.18.0.3",
+					ID:        12794,
+					QueryTime: 1.5,
+					Query:     "SET NAMES utf8mb4;",
+					Line:      11,
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			w, err := NewWriter(tt.kind, &buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := w.WriteServerMeta(tt.srv); err != nil {
+				t.Fatal(err)
+			}
+			for _, q := range tt.queries {
+				if err := w.Write(q); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			p := NewParser(tt.kind, &buf)
+			if srv := p.GetServerMeta(); srv != tt.srv {
+				t.Errorf("got = %v, want = %v", srv, tt.srv)
+			}
+			for _, want := range tt.queries {
+				if got := p.GetNext(); !reflect.DeepEqual(got, want) {
+					t.Errorf("got = %v, want = %v", got, want)
+				}
+			}
+			if q := p.GetNext(); !q.IsZero() {
+				t.Errorf("got = %v, want the zero query", q)
+			}
+		})
+	}
+}